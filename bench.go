@@ -0,0 +1,253 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchPrograms are the programs run by "glox bench". Each exercises a
+// different part of the interpreter, as described at the top of the file.
+//
+//go:embed bench/*.lox
+var benchPrograms embed.FS
+
+// benchReport is the output of "glox bench -json", which can be saved and
+// passed back with -baseline to compare later runs against.
+type benchReport struct {
+	Backend string        `json:"backend"`
+	Results []benchResult `json:"results"`
+}
+
+// benchResult is what's measured for one program.
+type benchResult struct {
+	Name string        `json:"name"`
+	Time time.Duration `json:"time_ns"` // of the fastest run
+	// bytes allocated by the program, as counted against MemoryQuota
+	Allocated int64 `json:"allocated_bytes"`
+	// allocations made by the Go runtime while running it
+	Mallocs    uint64 `json:"mallocs"`
+	Statements int64  `json:"statements"`
+	// the program's result in the baseline, if it's in there
+	Baseline *benchResult `json:"baseline,omitempty"`
+}
+
+type benchOptions struct {
+	vm       bool
+	optimize bool
+	count    int            // runs of each program, keeping the fastest
+	filter   *regexp.Regexp // of program names, nil for all of them
+}
+
+func (o benchOptions) backend() string {
+	if o.vm {
+		return "vm"
+	}
+	return "tree"
+}
+
+// benchCommand implements "glox bench", with the arguments after "bench".
+func benchCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	useVM := flags.Bool("vm", false, "run the benchmarks with the bytecode VM")
+	optimize := flags.Bool("optimize", false, "run the Optimizer over each program first")
+	count := flags.Int("count", 1, "run each program this many times, and report the fastest")
+	run := flags.String("run", "", "only run programs whose names match this regular expression")
+	asJSON := flags.Bool("json", false, "report the results as JSON, which can be saved as a baseline")
+	baseline := flags.String("baseline", "", "compare the results with those from a file saved from -json")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: glox bench [flags]")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() > 0 || *count < 1 {
+		flags.Usage()
+		return fmt.Errorf("invalid arguments")
+	}
+
+	opts := benchOptions{vm: *useVM, optimize: *optimize, count: *count}
+	if *run != "" {
+		filter, err := regexp.Compile(*run)
+		if err != nil {
+			return fmt.Errorf("invalid -run pattern: %w", err)
+		}
+		opts.filter = filter
+	}
+	programs, err := fs.Sub(benchPrograms, "bench")
+	if err != nil {
+		return err
+	}
+	report, err := runBenchmarks(programs, opts)
+	if err != nil {
+		return err
+	}
+	if *baseline != "" {
+		if err := compareBaseline(&report, *baseline); err != nil {
+			return err
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	return writeBenchText(stdout, report)
+}
+
+// runBenchmarks runs each .lox program in programs, in order of name.
+func runBenchmarks(programs fs.FS, opts benchOptions) (benchReport, error) {
+	report := benchReport{Backend: opts.backend()}
+	paths, err := fs.Glob(programs, "*.lox")
+	if err != nil {
+		return report, err
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(path, ".lox")
+		if opts.filter != nil && !opts.filter.MatchString(name) {
+			continue
+		}
+		src, err := fs.ReadFile(programs, path)
+		if err != nil {
+			return report, err
+		}
+		result, err := runBenchmark(name, string(src), opts)
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", name, err)
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+func runBenchmark(name, src string, opts benchOptions) (benchResult, error) {
+	result := benchResult{Name: name}
+	tokens, err := (&Scanner{}).ScanTokens(src)
+	if err != nil {
+		return result, err
+	}
+	stmts, err := (&Parser{Tokens: tokens}).Parse()
+	if err != nil {
+		return result, err
+	}
+	if err := (&Resolver{}).Resolve(stmts); err != nil {
+		return result, err
+	}
+	if opts.optimize {
+		stmts = (&Optimizer{}).Optimize(stmts)
+	}
+
+	for run := 0; run < opts.count; run++ {
+		i := &Interpreter{Stdout: ioutil.Discard}
+		// don't charge this run for garbage left by the last one
+		runtime.GC()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		if opts.vm {
+			err = NewVM(i).Interpret(stmts)
+		} else {
+			err = i.Interpret(stmts)
+		}
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+		if err != nil {
+			return result, err
+		}
+
+		if run == 0 || elapsed < result.Time {
+			result.Time = elapsed
+		}
+		result.Allocated = i.MemoryUsed()
+		result.Mallocs = after.Mallocs - before.Mallocs
+		result.Statements = i.StatementsExecuted()
+	}
+	return result, nil
+}
+
+// compareBaseline attaches the results saved in the baseline file to
+// those in report for the same programs.
+func compareBaseline(report *benchReport, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var baseline benchReport
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return fmt.Errorf("reading baseline %s: %w", path, err)
+	}
+	if baseline.Backend != report.Backend {
+		return fmt.Errorf("baseline %s was run with the %s backend, not %s", path, baseline.Backend, report.Backend)
+	}
+	saved := map[string]benchResult{}
+	for _, result := range baseline.Results {
+		result.Baseline = nil
+		saved[result.Name] = result
+	}
+	for idx := range report.Results {
+		if result, found := saved[report.Results[idx].Name]; found {
+			report.Results[idx].Baseline = &result
+		}
+	}
+	return nil
+}
+
+func writeBenchText(w io.Writer, report benchReport) error {
+	compared := false
+	for _, r := range report.Results {
+		compared = compared || r.Baseline != nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if compared {
+		fmt.Fprintln(tw, "program\ttime\tdelta\tallocated\tdelta\tmallocs\tdelta\tstatements\tdelta")
+	} else {
+		fmt.Fprintln(tw, "program\ttime\tallocated\tmallocs\tstatements")
+	}
+	for _, r := range report.Results {
+		cells := []string{
+			r.Name,
+			r.Time.Round(time.Microsecond).String(),
+			fmt.Sprint(r.Allocated),
+			fmt.Sprint(r.Mallocs),
+			fmt.Sprint(r.Statements),
+		}
+		if compared {
+			deltas := []string{"", "?", "?", "?", "?"}
+			if b := r.Baseline; b != nil {
+				deltas = []string{"",
+					percentChange(float64(r.Time), float64(b.Time)),
+					percentChange(float64(r.Allocated), float64(b.Allocated)),
+					percentChange(float64(r.Mallocs), float64(b.Mallocs)),
+					percentChange(float64(r.Statements), float64(b.Statements)),
+				}
+			}
+			withDeltas := []string{cells[0]}
+			for idx := 1; idx < len(cells); idx++ {
+				withDeltas = append(withDeltas, cells[idx], deltas[idx])
+			}
+			cells = withDeltas
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// percentChange describes how value differs from a baseline value.
+func percentChange(value, baseline float64) string {
+	if value == baseline {
+		return "~"
+	}
+	if baseline == 0 {
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*(value-baseline)/baseline)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestBenchPrograms(t *testing.T) {
+	programs, err := fs.Sub(benchPrograms, "bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := fs.Glob(programs, "*.lox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no benchmark programs bundled")
+	}
+	// they take too long to run here, but they should at least be valid
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			src, err := fs.ReadFile(programs, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens, err := (&Scanner{}).ScanTokens(string(src))
+			if err != nil {
+				t.Fatalf("scanning error: %s", err)
+			}
+			stmts, err := (&Parser{Tokens: tokens}).Parse()
+			if err != nil {
+				t.Fatalf("parsing error: %s", err)
+			}
+			if err := (&Resolver{}).Resolve(stmts); err != nil {
+				t.Fatalf("resolution error: %s", err)
+			}
+			if err := (&Checker{}).Check(stmts); err != nil {
+				t.Fatalf("type error: %s", err)
+			}
+		})
+	}
+}
+
+func TestRunBenchmarks(t *testing.T) {
+	programs := fstest.MapFS{
+		"loop.lox":  {Data: []byte("var i = 0; while (i < 10) i = i + 1;")},
+		"calls.lox": {Data: []byte(`fun f(s) { return s + "!"; } for (var i = 0; i < 5; i = i + 1) print f("x");`)},
+		"notes.txt": {Data: []byte("not a program")},
+	}
+	testCases := map[string]struct {
+		opts          benchOptions
+		expectedNames []string
+	}{
+		"tree": {
+			opts:          benchOptions{count: 1},
+			expectedNames: []string{"calls", "loop"},
+		},
+		"vm": {
+			opts:          benchOptions{vm: true, count: 2},
+			expectedNames: []string{"calls", "loop"},
+		},
+		"optimized": {
+			opts:          benchOptions{optimize: true, count: 1},
+			expectedNames: []string{"calls", "loop"},
+		},
+		"filtered": {
+			opts:          benchOptions{count: 1, filter: regexp.MustCompile("^l")},
+			expectedNames: []string{"loop"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			report, err := runBenchmarks(programs, tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if report.Backend != tc.opts.backend() {
+				t.Errorf("expected backend %q, got %q", tc.opts.backend(), report.Backend)
+			}
+			var names []string
+			for _, result := range report.Results {
+				names = append(names, result.Name)
+				if result.Time <= 0 || result.Statements <= 0 || result.Mallocs == 0 {
+					t.Errorf("expected %s to be measured, got %+v", result.Name, result)
+				}
+			}
+			if strings.Join(names, " ") != strings.Join(tc.expectedNames, " ") {
+				t.Errorf("expected results for %v, got %v", tc.expectedNames, names)
+			}
+		})
+	}
+}
+
+func TestRunBenchmarks_statements(t *testing.T) {
+	programs := fstest.MapFS{
+		"loop.lox": {Data: []byte("var i = 0; while (i < 10) i = i + 1;")},
+		"calls.lox": {Data: []byte(`
+fun fib(n) { if (n < 2) return n; else return fib(n - 1) + fib(n - 2); }
+class C { init() { this.n = 0; } add(k) { this.n = this.n + k; return this; } }
+var c = C();
+for (var i = 0; i < 5; i = i + 1) { c.add(fib(i)); }
+`)},
+	}
+	// the backends count the same statements, whatever they compile to
+	expected := map[string]int64{"calls": 75, "loop": 12}
+	for _, opts := range []benchOptions{{count: 1}, {vm: true, count: 1}} {
+		report, err := runBenchmarks(programs, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", opts.backend(), err)
+		}
+		for _, result := range report.Results {
+			if result.Statements != expected[result.Name] {
+				t.Errorf("%s: expected %s to execute %d statements, got %d", opts.backend(), result.Name, expected[result.Name], result.Statements)
+			}
+		}
+	}
+}
+
+func TestRunBenchmarks_error(t *testing.T) {
+	programs := fstest.MapFS{
+		"broken.lox": {Data: []byte("print 1;\nprint 1 - nil;")},
+	}
+	_, err := runBenchmarks(programs, benchOptions{count: 1})
+	expectedErr := "broken: runtime error on line 2"
+	if err == nil || !strings.Contains(err.Error(), expectedErr) {
+		t.Errorf("expected error containing %q, got %v", expectedErr, err)
+	}
+}
+
+func TestCompareBaseline(t *testing.T) {
+	baseline := benchReport{
+		Backend: "tree",
+		Results: []benchResult{
+			{Name: "fib", Time: 100 * time.Millisecond, Allocated: 1000, Mallocs: 50, Statements: 10},
+			{Name: "removed", Time: time.Second},
+		},
+	}
+	data, err := json.Marshal(baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := ioutil.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	report := benchReport{
+		Backend: "tree",
+		Results: []benchResult{
+			{Name: "fib", Time: 150 * time.Millisecond, Allocated: 500, Mallocs: 50, Statements: 10},
+			{Name: "zoo", Time: time.Millisecond, Allocated: 1, Mallocs: 2, Statements: 3},
+		},
+	}
+	if err := compareBaseline(&report, path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	out := &bytes.Buffer{}
+	if err := writeBenchText(out, report); err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		"program  time   delta   allocated  delta   mallocs  delta  statements  delta\n" +
+		"fib      150ms  +50.0%  500        -50.0%  50       ~      10          ~\n" +
+		"zoo      1ms    ?       1          ?       2        ?      3           ?\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	report.Backend = "vm"
+	expectedErr := "was run with the tree backend, not vm"
+	if err := compareBaseline(&report, path); err == nil || !strings.Contains(err.Error(), expectedErr) {
+		t.Errorf("expected error containing %q, got %v", expectedErr, err)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// decimalDivisionDigits is how many extra digits after the decimal point
+// are kept when a division's result can't be represented exactly.
+const decimalDivisionDigits = 16
+
+var (
+	errDivisionByZero = errors.New("Division by zero.")
+	errMixedFloat     = errors.New("Can't mix floats with bigints or decimals, convert explicitly with number(), bigint() or decimal().")
+)
+
+// Decimal is an arbitrary-precision decimal number, with the value
+// unscaled * 10^-scale. Its scale records how many digits after the
+// decimal point were written (or computed), so 1.10d prints as "1.10".
+// Decimals are immutable.
+type Decimal struct {
+	unscaled *big.Int
+	scale    int
+}
+
+func parseDecimal(s string) (Decimal, error) {
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	scale := 0
+	if idx := strings.IndexByte(digits, '.'); idx >= 0 {
+		scale = len(digits) - idx - 1
+		digits = digits[:idx] + digits[idx+1:]
+	}
+	unscaled, ok := new(big.Int).SetString(digits, 10)
+	if !ok || strings.HasPrefix(digits, "+") || strings.HasPrefix(digits, "-") {
+		return Decimal{}, fmt.Errorf("Invalid decimal %q.", s)
+	}
+	if neg {
+		unscaled.Neg(unscaled)
+	}
+	return Decimal{unscaled: unscaled, scale: scale}, nil
+}
+
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.unscaled).String()
+	if d.scale > 0 {
+		if len(digits) <= d.scale {
+			digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-d.scale] + "." + digits[len(digits)-d.scale:]
+	}
+	if d.unscaled.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+func (d Decimal) rat() *big.Rat {
+	denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.scale)), nil)
+	return new(big.Rat).SetFrac(d.unscaled, denom)
+}
+
+// rescale returns d's unscaled value at a larger scale.
+func (d Decimal) rescale(scale int) *big.Int {
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale-d.scale)), nil)
+	return factor.Mul(factor, d.unscaled)
+}
+
+func (d Decimal) add(other Decimal) Decimal {
+	scale := maxInt(d.scale, other.scale)
+	sum := new(big.Int).Add(d.rescale(scale), other.rescale(scale))
+	return Decimal{unscaled: sum, scale: scale}
+}
+
+func (d Decimal) sub(other Decimal) Decimal {
+	return d.add(other.neg())
+}
+
+func (d Decimal) mul(other Decimal) Decimal {
+	product := new(big.Int).Mul(d.unscaled, other.unscaled)
+	return Decimal{unscaled: product, scale: d.scale + other.scale}
+}
+
+// quo divides d by other. An exact result is returned if there is one
+// with at most decimalDivisionDigits more digits than the operands,
+// otherwise the result is rounded half-to-even at that many digits.
+func (d Decimal) quo(other Decimal) (Decimal, error) {
+	if other.unscaled.Sign() == 0 {
+		return Decimal{}, errDivisionByZero
+	}
+	minScale := maxInt(d.scale, other.scale)
+	quotient := new(big.Rat).Quo(d.rat(), other.rat())
+	return roundRat(quotient, minScale+decimalDivisionDigits).trim(minScale), nil
+}
+
+func (d Decimal) neg() Decimal {
+	return Decimal{unscaled: new(big.Int).Neg(d.unscaled), scale: d.scale}
+}
+
+// trim drops trailing zeros after the decimal point, down to minScale.
+func (d Decimal) trim(minScale int) Decimal {
+	unscaled, scale := new(big.Int).Set(d.unscaled), d.scale
+	ten, rem := big.NewInt(10), new(big.Int)
+	for scale > minScale {
+		q, r := new(big.Int).QuoRem(unscaled, ten, rem)
+		if r.Sign() != 0 {
+			break
+		}
+		unscaled, scale = q, scale-1
+	}
+	return Decimal{unscaled: unscaled, scale: scale}
+}
+
+// roundRat rounds r half-to-even to the given number of decimal places.
+func roundRat(r *big.Rat, scale int) Decimal {
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	num := new(big.Int).Mul(r.Num(), factor)
+	den := r.Denom()
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+
+	twiceRem := new(big.Int).Abs(rem)
+	twiceRem.Lsh(twiceRem, 1)
+	cmp := twiceRem.Cmp(den)
+	if cmp > 0 || (cmp == 0 && q.Bit(0) == 1) {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return Decimal{unscaled: q, scale: scale}
+}
+
+func isBigNumber(v interface{}) bool {
+	switch v.(type) {
+	case *big.Int, Decimal:
+		return true
+	}
+	return false
+}
+
+func toBigInt(v interface{}) *big.Int {
+	switch n := v.(type) {
+	case int64:
+		return big.NewInt(n)
+	case *big.Int:
+		return n
+	}
+	panic("toBigInt called on a non-integer")
+}
+
+func toDecimal(v interface{}) Decimal {
+	switch n := v.(type) {
+	case int64, *big.Int:
+		return Decimal{unscaled: toBigInt(n), scale: 0}
+	case Decimal:
+		return n
+	}
+	panic("toDecimal called on a non-exact number")
+}
+
+// toRat converts any number to an exact rational. It returns nil for
+// floats which aren't finite.
+func toRat(v interface{}) *big.Rat {
+	switch n := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(n)
+	case float64:
+		return new(big.Rat).SetFloat64(n)
+	case *big.Int:
+		return new(big.Rat).SetInt(n)
+	case Decimal:
+		return n.rat()
+	}
+	panic("toRat called on a non-number")
+}
+
+// bigArithmetic applies an arithmetic operator where at least one
+// operand is a bigint or decimal. Bigints stay bigints unless divided
+// inexactly, in which case (as with regular integers) the result is
+// promoted, in this case to a decimal.
+func bigArithmetic(op TokenType, left, right interface{}) (interface{}, error) {
+	_, leftIsFloat := left.(float64)
+	_, rightIsFloat := right.(float64)
+	if leftIsFloat || rightIsFloat {
+		return nil, errMixedFloat
+	}
+
+	_, leftIsDecimal := left.(Decimal)
+	_, rightIsDecimal := right.(Decimal)
+	if !leftIsDecimal && !rightIsDecimal {
+		a, b := toBigInt(left), toBigInt(right)
+		switch op {
+		case PLUS:
+			return new(big.Int).Add(a, b), nil
+		case MINUS:
+			return new(big.Int).Sub(a, b), nil
+		case STAR:
+			return new(big.Int).Mul(a, b), nil
+		case SLASH:
+			if b.Sign() == 0 {
+				return nil, errDivisionByZero
+			}
+			q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+			if r.Sign() == 0 {
+				return q, nil
+			}
+		}
+	}
+
+	a, b := toDecimal(left), toDecimal(right)
+	switch op {
+	case PLUS:
+		return a.add(b), nil
+	case MINUS:
+		return a.sub(b), nil
+	case STAR:
+		return a.mul(b), nil
+	case SLASH:
+		return a.quo(b)
+	}
+	panic("bigArithmetic called with a non-arithmetic operator")
+}
+
+// compareExact compares two numbers, at least one of them a bigint or
+// decimal, without any loss of precision. ok is false if either is a
+// non-finite float, which never compares equal, less or greater.
+func compareExact(left, right interface{}) (cmp int, ok bool) {
+	a, b := toRat(left), toRat(right)
+	if a == nil || b == nil {
+		return 0, false
+	}
+	return a.Cmp(b), true
+}
+
+// The conversion builtins below are how numbers move between the exact
+// and inexact types; none of the arithmetic operators do so implicitly.
+
+type BigIntBuiltin struct{}
+
+func (bb BigIntBuiltin) Arity() int { return 1 }
+
+func (bb BigIntBuiltin) Call(i *Interpreter, args []interface{}) interface{} {
+	switch n := args[0].(type) {
+	case int64, *big.Int:
+		return toBigInt(n)
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			break
+		}
+		truncated, _ := big.NewFloat(n).Int(nil)
+		return truncated
+	case Decimal:
+		return new(big.Int).Quo(n.unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n.scale)), nil))
+	case string:
+		if parsed, ok := new(big.Int).SetString(n, 10); ok {
+			return parsed
+		}
+	}
+	i.runtimeError(i.callLine, fmt.Sprintf("Can't convert %v to a bigint.", args[0]))
+	return nil
+}
+
+type DecimalBuiltin struct{}
+
+func (db DecimalBuiltin) Arity() int { return 1 }
+
+func (db DecimalBuiltin) Call(i *Interpreter, args []interface{}) interface{} {
+	switch n := args[0].(type) {
+	case int64, *big.Int, Decimal:
+		return toDecimal(n)
+	case float64:
+		// use the shortest representation of the float, so that
+		// decimal(0.1) == 0.1d
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			break
+		}
+		d, err := parseDecimal(strconv.FormatFloat(n, 'f', -1, 64))
+		if err == nil {
+			return d
+		}
+	case string:
+		d, err := parseDecimal(n)
+		if err == nil {
+			return d
+		}
+	}
+	i.runtimeError(i.callLine, fmt.Sprintf("Can't convert %v to a decimal.", args[0]))
+	return nil
+}
+
+type NumberBuiltin struct{}
+
+func (nb NumberBuiltin) Arity() int { return 1 }
+
+func (nb NumberBuiltin) Call(i *Interpreter, args []interface{}) interface{} {
+	switch n := args[0].(type) {
+	case int64, float64:
+		return n
+	case *big.Int:
+		if n.IsInt64() {
+			return n.Int64()
+		}
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f
+	case Decimal:
+		f, _ := n.rat().Float64()
+		return f
+	case string:
+		if parsed, err := strconv.ParseInt(n, 10, 64); err == nil {
+			return parsed
+		}
+		if parsed, err := strconv.ParseFloat(n, 64); err == nil {
+			return parsed
+		}
+	}
+	i.runtimeError(i.callLine, fmt.Sprintf("Can't convert %v to a number.", args[0]))
+	return nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
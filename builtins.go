@@ -1,6 +1,11 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
 
 type ClockBuiltin struct{}
 
@@ -9,3 +14,106 @@ func (cb ClockBuiltin) Arity() int { return 0 }
 func (cb ClockBuiltin) Call(i *Interpreter, args []interface{}) interface{} {
 	return float64(time.Now().Unix())
 }
+
+type ChannelBuiltin struct{}
+
+func (cb ChannelBuiltin) Arity() int { return 1 }
+
+func (cb ChannelBuiltin) Call(i *Interpreter, args []interface{}) interface{} {
+	capacity, ok := toInt(args[0])
+	if !ok || capacity < 0 {
+		i.runtimeError(i.callLine, errNegativeCapacity.Error())
+	}
+	i.allocate(i.callLine, valueSize*int(capacity))
+	return &Channel{sched: i.tasks, capacity: int(capacity)}
+}
+
+// nativeFunction is a function implemented in Go. It's used for builtins
+// and for the methods of native values, e.g. the send/receive/close
+// methods of a Channel.
+type nativeFunction struct {
+	name  string
+	arity int
+	fn    func(i *Interpreter, args []interface{}) (interface{}, error)
+}
+
+func (nf nativeFunction) Arity() int { return nf.arity }
+
+func (nf nativeFunction) Call(i *Interpreter, args []interface{}) interface{} {
+	line := i.callLine
+	value, err := nf.fn(i, args)
+	if err != nil {
+		i.runtimeError(line, err.Error())
+	}
+	return value
+}
+
+func (nf nativeFunction) String() string {
+	return fmt.Sprintf("<native fn %s>", nf.name)
+}
+
+// helpBuiltin prints the signature and doc comment of a function, or the
+// doc comment and methods of a class, for use from the REPL.
+var helpBuiltin = nativeFunction{name: "help", arity: 1, fn: help}
+
+func help(i *Interpreter, args []interface{}) (interface{}, error) {
+	var sb strings.Builder
+	switch target := args[0].(type) {
+	case interface{ declaration() FunctionStmt }:
+		decl := target.declaration()
+		sb.WriteString("fun " + functionSignature(decl) + "\n")
+		writeDoc(&sb, decl.Doc, "")
+	case *Class:
+		sb.WriteString("class " + target.Name)
+		if target.Superclass != nil {
+			sb.WriteString(" < " + target.Superclass.Name)
+		}
+		sb.WriteString("\n")
+		writeDoc(&sb, target.Doc, "")
+		names := make([]string, 0, len(target.Methods))
+		for name := range target.Methods {
+			if !strings.HasPrefix(name, "#") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			method := target.Methods[name].declaration()
+			sb.WriteString("  " + functionSignature(method) + "\n")
+			writeDoc(&sb, method.Doc, "    ")
+		}
+	default:
+		return nil, fmt.Errorf("help() expects a function or class, got %s.", typeName(target))
+	}
+
+	i.tasks.outMu.Lock()
+	defer i.tasks.outMu.Unlock()
+	_, _ = fmt.Fprint(i.Stdout, sb.String())
+	return nil, nil
+}
+
+// functionSignature formats a function's name and parameters, with their
+// type annotations, like "add(a: number, b: number): number".
+func functionSignature(decl FunctionStmt) string {
+	params := make([]string, len(decl.Params))
+	for idx, param := range decl.Params {
+		params[idx] = param.Lexeme
+		if decl.ParamTypes != nil && decl.ParamTypes[idx] != nil {
+			params[idx] += ": " + decl.ParamTypes[idx].Lexeme
+		}
+	}
+	sig := decl.Name.Lexeme + "(" + strings.Join(params, ", ") + ")"
+	if decl.ReturnType != nil {
+		sig += ": " + decl.ReturnType.Lexeme
+	}
+	return sig
+}
+
+func writeDoc(sb *strings.Builder, doc, indent string) {
+	if doc == "" {
+		return
+	}
+	for _, line := range strings.Split(doc, "\n") {
+		sb.WriteString(indent + line + "\n")
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	errDeadlock         = errors.New("Deadlock: all tasks are blocked on channel operations.")
+	errSendOnClosed     = errors.New("Send on closed channel.")
+	errCloseOnClosed    = errors.New("Close of closed channel.")
+	errNegativeCapacity = errors.New("Channel capacity must be a non-negative number.")
+)
+
+// scheduler tracks the tasks started by "spawn" and owns the lock that
+// guards every channel created by an interpreter. Keeping all channel
+// state under one lock lets us tell exactly when every live task is
+// parked on a channel operation, which is how we detect deadlocks.
+type scheduler struct {
+	mu      sync.Mutex
+	outMu   sync.Mutex // serializes writes to Interpreter.Stdout
+	live    int        // tasks which haven't finished, including the main one
+	blocked int        // live tasks currently parked on a channel operation
+	parked  map[*parkedTask]bool
+	running sync.WaitGroup
+	err     error           // first runtime error raised by a spawned task
+	done    <-chan struct{} // closed when the program is cancelled
+}
+
+// parkedTask is a task waiting for one of several channel operations to
+// become possible. Whoever completes the operation (or detects a deadlock)
+// "fires" it, which records the outcome and wakes the waiting goroutine.
+type parkedTask struct {
+	fired bool
+	index int
+	value interface{}
+	err   error
+	wake  chan struct{}
+}
+
+// waiter is a parkedTask's entry on a single channel's send/receive queue.
+type waiter struct {
+	task  *parkedTask
+	index int
+	value interface{} // the value being sent, for send waiters
+}
+
+// chanOp is one candidate operation passed to scheduler.perform.
+type chanOp struct {
+	ch    *Channel
+	send  bool
+	value interface{}
+}
+
+func (s *scheduler) begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.live++
+}
+
+// finish marks the calling task as done. If every remaining task is
+// parked, nothing can ever wake them, so they are all failed.
+func (s *scheduler) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.live--
+	s.checkDeadlock()
+}
+
+// spawn runs fn in a new task. A runtime error raised by fn is recorded
+// and later reported by wait().
+func (s *scheduler) spawn(fn func()) {
+	s.begin()
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				s.fail(recoveredError(r))
+			}
+			s.finish()
+		}()
+		fn()
+	}()
+}
+
+func (s *scheduler) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// wait blocks until every spawned task has finished, and returns (and
+// clears) the first error raised by any of them.
+func (s *scheduler) wait() error {
+	s.running.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.err
+	s.err = nil
+	return err
+}
+
+func (s *scheduler) checkDeadlock() {
+	if s.live == 0 || s.blocked != s.live {
+		return
+	}
+	// the last task running may have only stopped because the program
+	// was cancelled, which is what should be reported
+	err := errDeadlock
+	select {
+	case <-s.done:
+		err = ErrCancelled
+	default:
+	}
+	for p := range s.parked {
+		s.fire(p, -1, nil, err)
+	}
+}
+
+func (s *scheduler) fire(p *parkedTask, index int, value interface{}, err error) {
+	p.fired = true
+	p.index = index
+	p.value = value
+	p.err = err
+	s.blocked--
+	delete(s.parked, p)
+	close(p.wake)
+}
+
+// perform carries out the first of ops which can proceed, in order, and
+// returns its index along with the received value (for receives). If none
+// can proceed it returns -1 when block is false, otherwise it parks the
+// calling task until one of them can, or the program is cancelled.
+func (s *scheduler) perform(ops []chanOp, block bool) (int, interface{}, error) {
+	s.mu.Lock()
+	for idx, op := range ops {
+		if op.send {
+			ok, err := op.ch.trySend(op.value)
+			if err != nil || ok {
+				s.mu.Unlock()
+				return idx, nil, err
+			}
+		} else {
+			value, ok := op.ch.tryReceive()
+			if ok {
+				s.mu.Unlock()
+				return idx, value, nil
+			}
+		}
+	}
+	if !block {
+		s.mu.Unlock()
+		return -1, nil, nil
+	}
+
+	p := &parkedTask{wake: make(chan struct{})}
+	for idx, op := range ops {
+		w := &waiter{task: p, index: idx, value: op.value}
+		if op.send {
+			op.ch.sendq = append(op.ch.sendq, w)
+		} else {
+			op.ch.recvq = append(op.ch.recvq, w)
+		}
+	}
+	if s.parked == nil {
+		s.parked = make(map[*parkedTask]bool)
+	}
+	s.parked[p] = true
+	s.blocked++
+	s.checkDeadlock()
+	s.mu.Unlock()
+
+	select {
+	case <-p.wake:
+	case <-s.done:
+	}
+
+	s.mu.Lock()
+	if !p.fired {
+		s.fire(p, -1, nil, ErrCancelled)
+	}
+	for _, op := range ops {
+		op.ch.forget(p)
+	}
+	s.mu.Unlock()
+	if p.err == ErrCancelled {
+		panic(interruption{ErrCancelled})
+	}
+	return p.index, p.value, p.err
+}
+
+// Channel is the Lox channel type, created with the channel() builtin.
+// Like Go channels, a capacity of 0 makes every send wait for a receiver.
+type Channel struct {
+	sched    *scheduler
+	capacity int
+	buf      []interface{}
+	closed   bool
+	sendq    []*waiter
+	recvq    []*waiter
+}
+
+func (c *Channel) String() string {
+	return "<channel>"
+}
+
+func (c *Channel) Get(name Token) (interface{}, error) {
+	switch name.Lexeme {
+	case "send":
+		return nativeFunction{name: "send", arity: 1, fn: c.send}, nil
+	case "receive":
+		return nativeFunction{name: "receive", arity: 0, fn: c.receive}, nil
+	case "close":
+		return nativeFunction{name: "close", arity: 0, fn: c.close}, nil
+	}
+	return nil, fmt.Errorf("Undefined property %q.", name.Lexeme)
+}
+
+func (c *Channel) send(i *Interpreter, args []interface{}) (interface{}, error) {
+	_, _, err := c.sched.perform([]chanOp{{ch: c, send: true, value: args[0]}}, true)
+	return nil, err
+}
+
+func (c *Channel) receive(i *Interpreter, args []interface{}) (interface{}, error) {
+	_, value, err := c.sched.perform([]chanOp{{ch: c}}, true)
+	return value, err
+}
+
+func (c *Channel) close(i *Interpreter, args []interface{}) (interface{}, error) {
+	c.sched.mu.Lock()
+	defer c.sched.mu.Unlock()
+	if c.closed {
+		return nil, errCloseOnClosed
+	}
+	c.closed = true
+	// pending receivers get nil, pending senders get an error
+	for _, w := range c.recvq {
+		if !w.task.fired {
+			c.sched.fire(w.task, w.index, nil, nil)
+		}
+	}
+	for _, w := range c.sendq {
+		if !w.task.fired {
+			c.sched.fire(w.task, w.index, nil, errSendOnClosed)
+		}
+	}
+	c.recvq, c.sendq = nil, nil
+	return nil, nil
+}
+
+// The try* and queue operations below must be called with sched.mu held.
+
+func (c *Channel) trySend(value interface{}) (bool, error) {
+	if c.closed {
+		return false, errSendOnClosed
+	}
+	if w := popWaiter(&c.recvq); w != nil {
+		c.sched.fire(w.task, w.index, value, nil)
+		return true, nil
+	}
+	if len(c.buf) < c.capacity {
+		c.buf = append(c.buf, value)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (c *Channel) tryReceive() (interface{}, bool) {
+	if len(c.buf) > 0 {
+		value := c.buf[0]
+		c.buf = c.buf[1:]
+		// a buffer slot just opened up, so admit a waiting sender
+		if w := popWaiter(&c.sendq); w != nil {
+			c.buf = append(c.buf, w.value)
+			c.sched.fire(w.task, w.index, nil, nil)
+		}
+		return value, true
+	}
+	if w := popWaiter(&c.sendq); w != nil {
+		c.sched.fire(w.task, w.index, nil, nil)
+		return w.value, true
+	}
+	if c.closed {
+		return nil, true
+	}
+	return nil, false
+}
+
+func (c *Channel) forget(p *parkedTask) {
+	c.sendq = removeWaiters(c.sendq, p)
+	c.recvq = removeWaiters(c.recvq, p)
+}
+
+// popWaiter dequeues the first waiter whose task hasn't already been
+// woken by an operation on some other channel.
+func popWaiter(q *[]*waiter) *waiter {
+	for len(*q) > 0 {
+		w := (*q)[0]
+		*q = (*q)[1:]
+		if !w.task.fired {
+			return w
+		}
+	}
+	return nil
+}
+
+func removeWaiters(q []*waiter, p *parkedTask) []*waiter {
+	kept := q[:0]
+	for _, w := range q {
+		if w.task != p {
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Checker is an optional static type-checking pass, which runs between
+// the Resolver and the Interpreter. Type annotations are optional:
+//
+//	var x: number = 1;
+//	fun greet(name: string): string { return "hi " + name; }
+//
+// Anything unannotated has the type "any", which is compatible with every
+// other type, and mismatches are only reported where an annotation is
+// involved, so unannotated code is never rejected. Within that, types are
+// inferred for literals, operators and calls to annotated functions.
+//
+// The built-in type names are number, string, bool, nil, function,
+// channel and any; class and interface names can also be used. Like the
+// interpreter's, nil is compatible with every type.
+type Checker struct {
+	scopes        []map[string]checkType
+	globals       map[string]checkType
+	types         map[string]userType // declared classes and interfaces
+	currentReturn checkType
+	errs          []string
+}
+
+// checkType is the static type of an expression.
+type checkType struct {
+	name     string     // a built-in type name, or a class/interface name for instances
+	sig      *signature // for functions and classes, if their parameters are known
+	class    string     // for classes, the type of the instances they create
+	declared bool       // true if the type derives from an annotation
+}
+
+type signature struct {
+	params []checkType
+	ret    checkType
+	// whether any of it was annotated; calls are only checked against
+	// the signature if so, so unannotated functions keep failing (or not)
+	// at runtime, as they always have
+	annotated bool
+}
+
+// userType describes a class or interface declared by the script.
+type userType struct {
+	isInterface bool
+	superclass  string
+}
+
+var (
+	anyType    = checkType{name: "any"}
+	nilType    = checkType{name: "nil"}
+	numberType = checkType{name: "number"}
+	stringType = checkType{name: "string"}
+	boolType   = checkType{name: "bool"}
+)
+
+var builtinTypeNames = map[string]bool{
+	"any":           true,
+	"bool":          true,
+	"channel":       true,
+	"function":      true,
+	"list":          true,
+	"nil":           true,
+	"number":        true,
+	"set":           true,
+	"string":        true,
+	"stringBuilder": true,
+}
+
+func (ct checkType) String() string {
+	return ct.name
+}
+
+func (ct checkType) known() bool {
+	return ct.name != "any"
+}
+
+func (ct checkType) withDeclared(declared bool) checkType {
+	ct.declared = ct.declared || declared
+	return ct
+}
+
+func (c *Checker) Check(stmts []Stmt) error {
+	c.ensureInit()
+	c.errs = nil
+	for _, stmt := range stmts {
+		c.checkStmt(stmt)
+	}
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(c.errs, "\n"))
+}
+
+func (c *Checker) ensureInit() {
+	if c.globals != nil {
+		return
+	}
+	c.types = make(map[string]userType)
+	c.globals = make(map[string]checkType)
+	builtin := func(ret checkType, params ...checkType) checkType {
+		return checkType{
+			name:     "function",
+			sig:      &signature{params: params, ret: ret.withDeclared(true)},
+			declared: true,
+		}
+	}
+	c.globals["clock"] = builtin(numberType)
+	c.globals["channel"] = builtin(checkType{name: "channel"}, numberType)
+	c.globals["bigint"] = builtin(numberType, anyType)
+	c.globals["decimal"] = builtin(numberType, anyType)
+	c.globals["number"] = builtin(numberType, anyType)
+	c.globals["set"] = checkType{name: "function", declared: true} // variadic
+	c.globals["stringBuilder"] = builtin(checkType{name: "stringBuilder"})
+	c.globals["help"] = builtin(nilType, anyType)
+	listType := checkType{name: "list"}
+	c.globals["typeOf"] = builtin(stringType, anyType)
+	c.globals["classOf"] = builtin(anyType, anyType)
+	c.globals["superclassOf"] = builtin(anyType, anyType)
+	c.globals["fields"] = builtin(listType, anyType)
+	c.globals["methods"] = builtin(listType, anyType)
+	c.globals["hasField"] = builtin(boolType, anyType, stringType)
+	c.globals["getField"] = builtin(anyType, anyType, stringType)
+	c.globals["setField"] = builtin(anyType, anyType, stringType, anyType)
+	c.globals["deleteField"] = builtin(boolType, anyType, stringType)
+	c.currentReturn = anyType
+}
+
+func (c *Checker) typeError(line int, msg string) {
+	c.errs = append(c.errs, fmt.Sprintf("type error on line %d: %s", line, msg))
+}
+
+func (c *Checker) checkStmt(stmt Stmt) {
+	stmt.Accept(c)
+}
+
+func (c *Checker) checkStmts(stmts []Stmt) {
+	for _, stmt := range stmts {
+		c.checkStmt(stmt)
+	}
+}
+
+func (c *Checker) checkExpr(expr Expr) checkType {
+	return expr.Accept(c).(checkType)
+}
+
+func (c *Checker) beginScope() {
+	c.scopes = append(c.scopes, make(map[string]checkType))
+}
+
+func (c *Checker) endScope() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *Checker) define(name string, typ checkType) {
+	if len(c.scopes) == 0 {
+		c.globals[name] = typ
+		return
+	}
+	c.scopes[len(c.scopes)-1][name] = typ
+}
+
+func (c *Checker) lookup(name string) checkType {
+	for idx := len(c.scopes) - 1; idx >= 0; idx-- {
+		if typ, found := c.scopes[idx][name]; found {
+			return typ
+		}
+	}
+	if typ, found := c.globals[name]; found {
+		return typ
+	}
+	return anyType
+}
+
+// annotation converts an optional type annotation into a type.
+func (c *Checker) annotation(tok *Token) checkType {
+	if tok == nil {
+		return anyType
+	}
+	if !builtinTypeNames[tok.Lexeme] {
+		if _, found := c.types[tok.Lexeme]; !found {
+			c.typeError(tok.Line, fmt.Sprintf("Unknown type %q.", tok.Lexeme))
+			return anyType
+		}
+	}
+	return checkType{name: tok.Lexeme, declared: true}
+}
+
+func (c *Checker) signature(params []Token, paramTypes []*Token, returnType *Token) *signature {
+	sig := &signature{ret: c.annotation(returnType), annotated: returnType != nil}
+	for idx := range params {
+		var typ *Token
+		if paramTypes != nil {
+			typ = paramTypes[idx]
+		}
+		sig.params = append(sig.params, c.annotation(typ))
+		sig.annotated = sig.annotated || typ != nil
+	}
+	return sig
+}
+
+// assignable reports whether a value of type actual may be used where
+// the type target is expected.
+func (c *Checker) assignable(target, actual checkType) bool {
+	if !target.known() || !actual.known() || actual.name == "nil" || target.name == actual.name {
+		return true
+	}
+	if target.name == "function" {
+		return actual.name == "class"
+	}
+
+	targetType, targetIsUser := c.types[target.name]
+	_, actualIsUser := c.types[actual.name]
+	if !targetIsUser || !actualIsUser {
+		return false
+	}
+	// any instance might satisfy an interface, which "is" checks at runtime
+	if targetType.isInterface {
+		return true
+	}
+	for name := actual.name; name != ""; name = c.types[name].superclass {
+		if name == target.name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Checker) checkFunction(fs FunctionStmt, sig *signature) {
+	enclosingReturn := c.currentReturn
+	c.currentReturn = sig.ret
+	c.beginScope()
+	for idx, param := range fs.Params {
+		c.define(param.Lexeme, sig.params[idx])
+	}
+	c.checkStmts(fs.Body)
+	c.endScope()
+	c.currentReturn = enclosingReturn
+}
+
+// expectNumbers reports operands of an arithmetic or comparison operator
+// which can't be numbers, as long as an annotation is involved.
+func (c *Checker) expectNumbers(op Token, operands ...checkType) {
+	declared := false
+	for _, operand := range operands {
+		declared = declared || operand.declared
+	}
+	if !declared {
+		return
+	}
+	for _, operand := range operands {
+		if operand.known() && operand.name != "number" {
+			c.typeError(op.Line, fmt.Sprintf("Operands of %q must be numbers, got %s.", op.Lexeme, operand))
+			return
+		}
+	}
+}
+
+func (c *Checker) VisitBlockStmt(stmt Stmt) interface{} {
+	c.beginScope()
+	c.checkStmts(stmt.(BlockStmt).Statements)
+	c.endScope()
+	return nil
+}
+
+func (c *Checker) VisitClassStmt(stmt Stmt) interface{} {
+	cs := stmt.(ClassStmt)
+	declared := userType{}
+	if cs.Superclass != nil {
+		declared.superclass = cs.Superclass.Name.Lexeme
+		c.checkExpr(*cs.Superclass)
+	}
+	c.types[cs.Name.Lexeme] = declared
+
+	class := checkType{name: "class", class: cs.Name.Lexeme, declared: true}
+	for _, method := range cs.Methods {
+		if method.Name.Lexeme == "init" {
+			class.sig = c.signature(method.Params, method.ParamTypes, nil)
+		}
+	}
+	c.define(cs.Name.Lexeme, class)
+
+	for _, method := range cs.Methods {
+		sig := c.signature(method.Params, method.ParamTypes, method.ReturnType)
+		c.checkFunction(method, sig)
+	}
+	return nil
+}
+
+func (c *Checker) VisitExpressionStmt(stmt Stmt) interface{} {
+	c.checkExpr(stmt.(ExprStmt).Expression)
+	return nil
+}
+
+func (c *Checker) VisitFunctionStmt(stmt Stmt) interface{} {
+	fs := stmt.(FunctionStmt)
+	sig := c.signature(fs.Params, fs.ParamTypes, fs.ReturnType)
+	c.define(fs.Name.Lexeme, checkType{name: "function", sig: sig, declared: true})
+	c.checkFunction(fs, sig)
+	return nil
+}
+
+func (c *Checker) VisitIfStmt(stmt Stmt) interface{} {
+	is := stmt.(IfStmt)
+	c.checkExpr(is.Condition)
+	c.checkStmt(is.Then)
+	if is.Else != nil {
+		c.checkStmt(is.Else)
+	}
+	return nil
+}
+
+func (c *Checker) VisitInterfaceStmt(stmt Stmt) interface{} {
+	is := stmt.(InterfaceStmt)
+	c.types[is.Name.Lexeme] = userType{isInterface: true}
+	c.define(is.Name.Lexeme, checkType{name: "interface", declared: true})
+	for _, method := range is.Methods {
+		c.signature(method.Params, method.ParamTypes, method.ReturnType)
+	}
+	return nil
+}
+
+func (c *Checker) VisitAssertStmt(stmt Stmt) interface{} {
+	as := stmt.(AssertStmt)
+	c.checkExpr(as.Condition)
+	if as.Message != nil {
+		c.checkExpr(as.Message)
+	}
+	return nil
+}
+
+func (c *Checker) VisitPrintStmt(stmt Stmt) interface{} {
+	c.checkExpr(stmt.(PrintStmt).Expression)
+	return nil
+}
+
+func (c *Checker) VisitReturnStmt(stmt Stmt) interface{} {
+	rs := stmt.(ReturnStmt)
+	value := nilType
+	if rs.Value != nil {
+		value = c.checkExpr(rs.Value)
+	}
+	if c.currentReturn.declared && !c.assignable(c.currentReturn, value) {
+		c.typeError(rs.Keyword.Line, fmt.Sprintf("Can't return %s from a function returning %s.", value, c.currentReturn))
+	}
+	return nil
+}
+
+func (c *Checker) VisitSelectStmt(stmt Stmt) interface{} {
+	ss := stmt.(SelectStmt)
+	for _, sc := range ss.Cases {
+		c.checkExpr(sc.Channel)
+		if sc.Send {
+			c.checkExpr(sc.Value)
+		}
+		c.beginScope()
+		if sc.Name != nil {
+			c.define(sc.Name.Lexeme, anyType)
+		}
+		c.checkStmts(sc.Body)
+		c.endScope()
+	}
+	if ss.Default != nil {
+		c.beginScope()
+		c.checkStmts(ss.Default)
+		c.endScope()
+	}
+	return nil
+}
+
+func (c *Checker) VisitSpawnStmt(stmt Stmt) interface{} {
+	c.checkExpr(stmt.(SpawnStmt).Call)
+	return nil
+}
+
+func (c *Checker) VisitVarStmt(stmt Stmt) interface{} {
+	vs := stmt.(VariableStmt)
+	value := nilType
+	if vs.Initializer != nil {
+		value = c.checkExpr(vs.Initializer)
+	}
+	if vs.Type == nil {
+		c.define(vs.Name.Lexeme, anyType)
+		return nil
+	}
+	typ := c.annotation(vs.Type)
+	if !c.assignable(typ, value) {
+		c.typeError(vs.Name.Line, fmt.Sprintf("Can't initialize %q of type %s with %s.", vs.Name.Lexeme, typ, value))
+	}
+	c.define(vs.Name.Lexeme, typ)
+	return nil
+}
+
+func (c *Checker) VisitWhileStmt(stmt Stmt) interface{} {
+	ws := stmt.(WhileStmt)
+	c.checkExpr(ws.Condition)
+	c.checkStmt(ws.Body)
+	return nil
+}
+
+func (c *Checker) VisitAssign(expr Expr) interface{} {
+	ae := expr.(Assign)
+	value := c.checkExpr(ae.Value)
+	target := c.lookup(ae.Name.Lexeme)
+	if target.declared && !c.assignable(target, value) {
+		c.typeError(ae.Name.Line, fmt.Sprintf("Can't assign %s to %q of type %s.", value, ae.Name.Lexeme, target))
+	}
+	return value
+}
+
+func (c *Checker) VisitBinary(expr Expr) interface{} {
+	be := expr.(Binary)
+	left := c.checkExpr(be.Left)
+	right := c.checkExpr(be.Right)
+	declared := left.declared || right.declared
+
+	switch be.Operator.Type {
+	case MINUS, SLASH, STAR:
+		c.expectNumbers(be.Operator, left, right)
+		return numberType.withDeclared(declared)
+	case GREATER, GREATER_EQUAL, LESS, LESS_EQUAL:
+		c.expectNumbers(be.Operator, left, right)
+		return boolType.withDeclared(declared)
+	case PLUS:
+		if left.known() && right.known() {
+			if left.name == right.name && (left.name == "number" || left.name == "string") {
+				return left.withDeclared(declared)
+			}
+			if declared {
+				c.typeError(be.Operator.Line, fmt.Sprintf("Operands of '+' must be two numbers or two strings, got %s and %s.", left, right))
+			}
+			return anyType
+		}
+		for _, operand := range []checkType{left, right} {
+			if !operand.known() {
+				continue
+			}
+			if operand.name == "number" || operand.name == "string" {
+				return operand.withDeclared(declared)
+			}
+			if declared {
+				c.typeError(be.Operator.Line, fmt.Sprintf("Operands of '+' must be two numbers or two strings, got %s.", operand))
+			}
+		}
+		return anyType
+	}
+	// equality and "is"
+	return boolType.withDeclared(declared)
+}
+
+func (c *Checker) VisitCall(expr Expr) interface{} {
+	ce := expr.(Call)
+	callee := c.checkExpr(ce.Callee)
+	var args []checkType
+	for _, arg := range ce.Args {
+		args = append(args, c.checkExpr(arg))
+	}
+	for _, arg := range ce.Named {
+		c.checkExpr(arg.Value)
+	}
+
+	if callee.sig != nil && callee.sig.annotated {
+		if len(args) != len(callee.sig.params) {
+			c.typeError(ce.Paren.Line, fmt.Sprintf("Expected %d args but got %d.", len(callee.sig.params), len(args)))
+		} else {
+			for idx, param := range callee.sig.params {
+				if param.declared && !c.assignable(param, args[idx]) {
+					c.typeError(ce.Paren.Line, fmt.Sprintf("Argument %d must be %s, got %s.", idx+1, param, args[idx]))
+				}
+			}
+		}
+	}
+
+	if callee.class != "" {
+		return checkType{name: callee.class, declared: true}
+	}
+	if callee.sig != nil {
+		return callee.sig.ret
+	}
+	return anyType
+}
+
+func (c *Checker) VisitGet(expr Expr) interface{} {
+	c.checkExpr(expr.(Get).Object)
+	return anyType
+}
+
+func (c *Checker) VisitGrouping(expr Expr) interface{} {
+	return c.checkExpr(expr.(Grouping).Expression)
+}
+
+func (c *Checker) VisitLiteral(expr Expr) interface{} {
+	switch expr.(Literal).Value.(type) {
+	case int64, float64, *big.Int, Decimal:
+		return numberType
+	case string:
+		return stringType
+	case bool:
+		return boolType
+	case nil:
+		return nilType
+	}
+	return anyType
+}
+
+func (c *Checker) VisitLogical(expr Expr) interface{} {
+	le := expr.(Logical)
+	left := c.checkExpr(le.Left)
+	right := c.checkExpr(le.Right)
+	if left.name == right.name {
+		return left.withDeclared(right.declared)
+	}
+	return anyType
+}
+
+func (c *Checker) VisitSet(expr Expr) interface{} {
+	se := expr.(Set)
+	c.checkExpr(se.Object)
+	return c.checkExpr(se.Value)
+}
+
+func (c *Checker) VisitSuper(expr Expr) interface{} {
+	return anyType
+}
+
+func (c *Checker) VisitThis(expr Expr) interface{} {
+	return anyType
+}
+
+func (c *Checker) VisityUnary(expr Expr) interface{} {
+	ue := expr.(Unary)
+	right := c.checkExpr(ue.Right)
+	if ue.Operator.Type == BANG {
+		return boolType.withDeclared(right.declared)
+	}
+	c.expectNumbers(ue.Operator, right)
+	return numberType.withDeclared(right.declared)
+}
+
+func (c *Checker) VisitVariable(expr Expr) interface{} {
+	return c.lookup(expr.(Variable).Name.Lexeme)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChecker_Check_script(t *testing.T) {
+	testCases := map[string]struct {
+		in          string
+		errExpected bool
+		expectedErr string
+	}{
+		"unannotated code is never rejected": {
+			in: `
+var a = 1;
+a = "one";
+fun f(x) { return x + 1; }
+print f("x");
+if (false) print 1 + "one";
+`,
+		},
+		"calls to unannotated functions aren't checked": {
+			in: `
+fun f(a) { print a; }
+class C { init(a) { this.a = a; } m() {} }
+if (false) f(1, 2);
+if (false) C();
+if (false) C(1).m(2);
+if (false) clock(1);
+print "ok";
+`,
+		},
+		"err: wrong number of args to an annotated function": {
+			in:          "fun f(a: number) { print a; }\nif (false) f(1, 2);",
+			errExpected: true,
+			expectedErr: "type error on line 2: Expected 1 args but got 2.",
+		},
+		"err: wrong number of args to a function with only a return type": {
+			in:          "fun f(a): number { return a; }\nf();",
+			errExpected: true,
+			expectedErr: "Expected 1 args but got 0.",
+		},
+		"annotated code that type-checks": {
+			in: `
+var x: number = 1;
+x = x + 2.5;
+fun greet(name: string): string { return "hi " + name; }
+var s: string = greet("bob");
+var unknown;
+var n: number = unknown;
+var nothing: number;
+`,
+		},
+		"subclass instances are assignable to the superclass": {
+			in: `
+class Animal {}
+class Dog < Animal {}
+var a: Animal = Dog();
+`,
+		},
+		"data class fields are annotated via init": {
+			in:          "data class Point(x: number, y: number) {} Point(1, \"two\");",
+			errExpected: true,
+			expectedErr: "line 1: Argument 2 must be number, got string",
+		},
+		"assigning the wrong type to an annotated variable": {
+			in:          "var x: number = 1;\nx = \"two\";",
+			errExpected: true,
+			expectedErr: "line 2: Can't assign string to \"x\" of type number",
+		},
+		"initializing with the wrong type": {
+			in:          "var ok: bool = 1;",
+			errExpected: true,
+			expectedErr: "Can't initialize \"ok\" of type bool with number",
+		},
+		"passing the wrong type to an annotated parameter": {
+			in:          "fun f(a: string) { print a; }\nf(3);",
+			errExpected: true,
+			expectedErr: "line 2: Argument 1 must be string, got number",
+		},
+		"returning the wrong type": {
+			in:          "fun f(): bool { return 1; }",
+			errExpected: true,
+			expectedErr: "Can't return number from a function returning bool",
+		},
+		"arithmetic on an annotated string": {
+			in:          "var s: string = \"a\";\nprint s - 1;",
+			errExpected: true,
+			expectedErr: "line 2: Operands of \"-\" must be numbers, got string",
+		},
+		"inferred result of an annotated function": {
+			in:          "fun name(): string { return \"bob\"; } print name() * 2;",
+			errExpected: true,
+			expectedErr: "Operands of \"*\" must be numbers, got string",
+		},
+		"superclass instance isn't assignable to the subclass": {
+			in:          "class A {} class B < A {} var b: B = A();",
+			errExpected: true,
+			expectedErr: "Can't initialize \"b\" of type B with A",
+		},
+		"unknown type name": {
+			in:          "var x: Widget = nil;",
+			errExpected: true,
+			expectedErr: "Unknown type \"Widget\"",
+		},
+		"all mismatches are reported": {
+			in:          "var a: number = \"a\";\nvar b: string = 1;",
+			errExpected: true,
+			expectedErr: "line 1: Can't initialize \"a\" of type number with string.\ntype error on line 2",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tokens, scanErr := (&Scanner{}).ScanTokens(tc.in)
+			if scanErr != nil {
+				t.Fatalf("scanning error in test input: %s", scanErr)
+			}
+			stmts, parseErr := (&Parser{Tokens: tokens}).Parse()
+			if parseErr != nil {
+				t.Fatalf("parsing error in test input: %s", parseErr)
+			}
+			resolveErr := (&Resolver{}).Resolve(stmts)
+			if resolveErr != nil {
+				t.Fatalf("resolution error in test input: %s", resolveErr)
+			}
+			err := (&Checker{}).Check(stmts)
+			if !tc.errExpected && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if tc.errExpected && err == nil {
+				t.Error("expected error, didn't get one")
+			} else if tc.errExpected && !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("expected error containing %q, got %q", tc.expectedErr, err)
+			}
+		})
+	}
+}
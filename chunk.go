@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OpCode is a bytecode instruction for the VM. Operands follow the opcode
+// in the instruction stream: "u8" operands take one byte, "u16" operands
+// (constant indexes and jump offsets) two, big-endian.
+type OpCode byte
+
+const (
+	OP_CONSTANT OpCode = iota // u16 constant
+	OP_NIL
+	OP_TRUE
+	OP_FALSE
+	OP_POP
+	OP_GET_LOCAL     // u8 slot
+	OP_SET_LOCAL     // u8 slot
+	OP_GET_GLOBAL    // u16 name
+	OP_DEFINE_GLOBAL // u16 name
+	OP_SET_GLOBAL    // u16 name
+	OP_GET_UPVALUE   // u8 index
+	OP_SET_UPVALUE   // u8 index
+	OP_GET_PROPERTY  // u16 name
+	OP_SET_PROPERTY  // u16 name
+	OP_GET_PRIVATE   // u16 name
+	OP_SET_PRIVATE   // u16 name
+	OP_GET_SUPER     // u16 name
+	OP_EQUAL
+	OP_NOT_EQUAL
+	OP_GREATER
+	OP_GREATER_EQUAL
+	OP_LESS
+	OP_LESS_EQUAL
+	OP_IS
+	OP_ADD
+	OP_SUBTRACT
+	OP_MULTIPLY
+	OP_DIVIDE
+	OP_NOT
+	OP_NEGATE
+	OP_PRINT
+	OP_JUMP          // u16 offset
+	OP_JUMP_IF_FALSE // u16 offset
+	OP_LOOP          // u16 offset, backwards
+	OP_CALL          // u8 argument count
+	OP_CALL_NAMED    // u8 positional argument count, u16 names
+	OP_INVOKE        // u16 name, u8 argument count
+	OP_SUPER_INVOKE  // u16 name, u8 argument count
+	OP_CLOSURE       // u16 function, then u8 isLocal and u8 index per upvalue
+	OP_CLOSE_UPVALUE
+	OP_RETURN
+	OP_CLASS // u16 classTemplate
+	OP_INHERIT
+	OP_METHOD      // u16 name
+	OP_IMPLEMENTS  // u16 implementsCheck
+	OP_ASSERT      // u16 offset past the assertion, taken when assertions are disabled
+	OP_ASSERT_FAIL // u16 assertTemplate
+	OP_SPAWN       // u8 positional argument count, u16 names
+	OP_SELECT      // u16 selectTemplate
+	OP_SELECT_CASE // u8 case index (0xff for default), u16 offset to the next case
+	OP_STATEMENT   // starts each statement, to count it against the budget
+)
+
+var opCodeNames = [...]string{
+	OP_CONSTANT:      "OP_CONSTANT",
+	OP_NIL:           "OP_NIL",
+	OP_TRUE:          "OP_TRUE",
+	OP_FALSE:         "OP_FALSE",
+	OP_POP:           "OP_POP",
+	OP_GET_LOCAL:     "OP_GET_LOCAL",
+	OP_SET_LOCAL:     "OP_SET_LOCAL",
+	OP_GET_GLOBAL:    "OP_GET_GLOBAL",
+	OP_DEFINE_GLOBAL: "OP_DEFINE_GLOBAL",
+	OP_SET_GLOBAL:    "OP_SET_GLOBAL",
+	OP_GET_UPVALUE:   "OP_GET_UPVALUE",
+	OP_SET_UPVALUE:   "OP_SET_UPVALUE",
+	OP_GET_PROPERTY:  "OP_GET_PROPERTY",
+	OP_SET_PROPERTY:  "OP_SET_PROPERTY",
+	OP_GET_PRIVATE:   "OP_GET_PRIVATE",
+	OP_SET_PRIVATE:   "OP_SET_PRIVATE",
+	OP_GET_SUPER:     "OP_GET_SUPER",
+	OP_EQUAL:         "OP_EQUAL",
+	OP_NOT_EQUAL:     "OP_NOT_EQUAL",
+	OP_GREATER:       "OP_GREATER",
+	OP_GREATER_EQUAL: "OP_GREATER_EQUAL",
+	OP_LESS:          "OP_LESS",
+	OP_LESS_EQUAL:    "OP_LESS_EQUAL",
+	OP_IS:            "OP_IS",
+	OP_ADD:           "OP_ADD",
+	OP_SUBTRACT:      "OP_SUBTRACT",
+	OP_MULTIPLY:      "OP_MULTIPLY",
+	OP_DIVIDE:        "OP_DIVIDE",
+	OP_NOT:           "OP_NOT",
+	OP_NEGATE:        "OP_NEGATE",
+	OP_PRINT:         "OP_PRINT",
+	OP_JUMP:          "OP_JUMP",
+	OP_JUMP_IF_FALSE: "OP_JUMP_IF_FALSE",
+	OP_LOOP:          "OP_LOOP",
+	OP_CALL:          "OP_CALL",
+	OP_CALL_NAMED:    "OP_CALL_NAMED",
+	OP_INVOKE:        "OP_INVOKE",
+	OP_SUPER_INVOKE:  "OP_SUPER_INVOKE",
+	OP_CLOSURE:       "OP_CLOSURE",
+	OP_CLOSE_UPVALUE: "OP_CLOSE_UPVALUE",
+	OP_RETURN:        "OP_RETURN",
+	OP_CLASS:         "OP_CLASS",
+	OP_INHERIT:       "OP_INHERIT",
+	OP_METHOD:        "OP_METHOD",
+	OP_IMPLEMENTS:    "OP_IMPLEMENTS",
+	OP_ASSERT:        "OP_ASSERT",
+	OP_ASSERT_FAIL:   "OP_ASSERT_FAIL",
+	OP_SPAWN:         "OP_SPAWN",
+	OP_SELECT:        "OP_SELECT",
+	OP_STATEMENT:     "OP_STATEMENT",
+	OP_SELECT_CASE:   "OP_SELECT_CASE",
+}
+
+func (op OpCode) String() string {
+	if int(op) < len(opCodeNames) && opCodeNames[op] != "" {
+		return opCodeNames[op]
+	}
+	return fmt.Sprintf("OP_UNKNOWN(%d)", byte(op))
+}
+
+// Chunk is the bytecode for one function, along with its constants and
+// the source line of every byte.
+type Chunk struct {
+	Code      []byte
+	Lines     []int
+	Constants []interface{}
+}
+
+func (c *Chunk) write(b byte, line int) {
+	c.Code = append(c.Code, b)
+	c.Lines = append(c.Lines, line)
+}
+
+func (c *Chunk) addConstant(value interface{}) int {
+	c.Constants = append(c.Constants, value)
+	return len(c.Constants) - 1
+}
+
+func (c *Chunk) readU16(offset int) int {
+	return int(c.Code[offset])<<8 | int(c.Code[offset+1])
+}
+
+// Disassemble returns a human-readable listing of the chunk, for debugging
+// the compiler.
+func (c *Chunk) Disassemble(name string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "== %s ==\n", name)
+	for offset := 0; offset < len(c.Code); {
+		offset = c.disassembleInstruction(&sb, offset)
+	}
+	return sb.String()
+}
+
+func (c *Chunk) disassembleInstruction(sb *strings.Builder, offset int) int {
+	fmt.Fprintf(sb, "%04d %4d ", offset, c.Lines[offset])
+	op := OpCode(c.Code[offset])
+	switch op {
+	case OP_CONSTANT, OP_GET_GLOBAL, OP_DEFINE_GLOBAL, OP_SET_GLOBAL,
+		OP_GET_PROPERTY, OP_SET_PROPERTY, OP_GET_PRIVATE, OP_SET_PRIVATE,
+		OP_GET_SUPER, OP_CLASS, OP_METHOD, OP_IMPLEMENTS, OP_ASSERT_FAIL, OP_SELECT:
+		constant := c.readU16(offset + 1)
+		fmt.Fprintf(sb, "%-16s %4d '%v'\n", op, constant, c.Constants[constant])
+		return offset + 3
+	case OP_GET_LOCAL, OP_SET_LOCAL, OP_GET_UPVALUE, OP_SET_UPVALUE, OP_CALL:
+		fmt.Fprintf(sb, "%-16s %4d\n", op, c.Code[offset+1])
+		return offset + 2
+	case OP_JUMP, OP_JUMP_IF_FALSE, OP_ASSERT:
+		fmt.Fprintf(sb, "%-16s %4d -> %d\n", op, offset, offset+3+c.readU16(offset+1))
+		return offset + 3
+	case OP_LOOP:
+		fmt.Fprintf(sb, "%-16s %4d -> %d\n", op, offset, offset+3-c.readU16(offset+1))
+		return offset + 3
+	case OP_INVOKE, OP_SUPER_INVOKE:
+		constant := c.readU16(offset + 1)
+		fmt.Fprintf(sb, "%-16s (%d args) %4d '%v'\n", op, c.Code[offset+3], constant, c.Constants[constant])
+		return offset + 4
+	case OP_CALL_NAMED, OP_SPAWN:
+		constant := c.readU16(offset + 2)
+		fmt.Fprintf(sb, "%-16s (%d args) %v\n", op, c.Code[offset+1], c.Constants[constant])
+		return offset + 4
+	case OP_SELECT_CASE:
+		fmt.Fprintf(sb, "%-16s %4d -> %d\n", op, c.Code[offset+1], offset+4+c.readU16(offset+2))
+		return offset + 4
+	case OP_CLOSURE:
+		constant := c.readU16(offset + 1)
+		fn := c.Constants[constant].(*vmFunction)
+		fmt.Fprintf(sb, "%-16s %4d %v\n", op, constant, fn)
+		offset += 3
+		for j := 0; j < fn.upvalueCount; j++ {
+			kind := "upvalue"
+			if c.Code[offset] == 1 {
+				kind = "local"
+			}
+			fmt.Fprintf(sb, "%04d    |                     %s %d\n", offset, kind, c.Code[offset+1])
+			offset += 2
+		}
+		return offset
+	}
+	fmt.Fprintf(sb, "%s\n", op)
+	return offset + 1
+}
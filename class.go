@@ -1,29 +1,73 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Method is a function declared in a class body. The tree-walking
+// Interpreter's Function and the VM's vmClosure both implement it, so
+// classes and instances are shared by the two backends.
+type Method interface {
+	Callable
+	bind(inst *Instance) Callable
+	declaration() FunctionStmt
+}
 
 type Class struct {
 	Name       string
-	Methods    map[string]Function
+	Methods    map[string]Method
 	Superclass *Class
+	// Data classes ("data class Point(x, y) {...}") also get structural
+	// equality, a generated toString() and copy(), and print their fields,
+	// which can't be changed once they're initialized.
+	IsData bool
+	Fields []string // the fields declared by a data class, in order
+	Doc    string
+	// methodTable flattens the hierarchy: it holds Methods along with
+	// the methods inherited from superclasses, so looking one up doesn't
+	// walk the chain. It's filled in by inherit and addMethod.
+	methodTable map[string]Method
+}
+
+// inherit makes superclass the superclass. It has to be called before
+// the class's own methods are added, which override inherited ones.
+func (c *Class) inherit(superclass *Class) {
+	c.Superclass = superclass
+	c.methodTable = make(map[string]Method, len(superclass.methodTable))
+	for name, method := range superclass.methodTable {
+		c.methodTable[name] = method
+	}
 }
 
-func (c Class) String() string {
+// addMethod declares a method of the class.
+func (c *Class) addMethod(name string, method Method) {
+	if c.methodTable == nil {
+		c.methodTable = make(map[string]Method)
+	}
+	c.Methods[name] = method
+	c.methodTable[name] = method
+}
+
+func (c *Class) String() string {
 	return c.Name
 }
 
-func (c Class) Call(i *Interpreter, args []interface{}) interface{} {
+func (c *Class) Call(i *Interpreter, args []interface{}) interface{} {
+	i.allocate(i.callLine, instanceSize)
 	inst := &Instance{
 		Class: c,
 	}
 	initializer, found := c.findMethod("init")
 	if found {
-		initializer.bindMethodToInstance(inst).Call(i, args)
+		initializer.bind(inst).Call(i, args)
 	}
 	return inst
 }
 
-func (c Class) Arity() int {
+func (c *Class) Arity() int {
 	initializer, found := c.findMethod("init")
 	if !found {
 		return 0
@@ -31,43 +75,271 @@ func (c Class) Arity() int {
 	return initializer.Arity()
 }
 
-func (c Class) findMethod(name string) (Function, bool) {
-	method, found := c.Methods[name]
-	if found {
-		return method, found
+func (c *Class) findMethod(name string) (Method, bool) {
+	method, found := c.methodTable[name]
+	return method, found
+}
+
+// MethodCache is the inline cache of a Get expression: the class of the
+// instance it last looked a method up for, and what it found. Most Get
+// expressions only ever see instances of one class, so this saves
+// looking the method up again. The cache is shared by every task
+// running the expression, so entries are replaced rather than updated.
+type MethodCache struct {
+	entry atomic.Value // methodCacheEntry
+}
+
+type methodCacheEntry struct {
+	class  *Class
+	method Method
+	found  bool
+}
+
+// findMethod is class.findMethod(name), for the Get expression the
+// cache belongs to. The cache can be nil, for trees built by hand.
+func (mc *MethodCache) findMethod(class *Class, name string) (Method, bool) {
+	if mc == nil {
+		return class.findMethod(name)
 	}
-	if c.Superclass != nil {
-		method, found = c.Superclass.findMethod(name)
+	if entry, ok := mc.entry.Load().(methodCacheEntry); ok && entry.class == class {
+		return entry.method, entry.found
 	}
+	method, found := class.findMethod(name)
+	mc.entry.Store(methodCacheEntry{class: class, method: method, found: found})
 	return method, found
 }
 
+// isInstance reports whether value is an instance of the class or of one
+// of its subclasses.
+func (c *Class) isInstance(value interface{}) bool {
+	instance, ok := value.(*Instance)
+	if !ok {
+		return false
+	}
+	for class := instance.Class; class != nil; class = class.Superclass {
+		if class == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Class) hasField(name string) bool {
+	for _, field := range c.Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
 type Instance struct {
-	Class  Class
+	Class  *Class
+	mu     sync.RWMutex // instances can be shared between tasks
 	Fields map[string]interface{}
+	// private fields ("#name") are kept apart from Fields, with a
+	// separate namespace for each class in the instance's hierarchy
+	private map[*Class]map[string]interface{}
 }
 
-func (i Instance) String() string {
+func (i *Instance) String() string {
+	if i.Class.IsData {
+		return i.dataString()
+	}
 	return i.Class.Name + " instance"
 }
 
 func (i *Instance) Get(name Token) (interface{}, error) {
+	i.mu.RLock()
 	field, found := i.Fields[name.Lexeme]
+	i.mu.RUnlock()
 	if found {
 		return field, nil
 	}
 
 	method, found := i.Class.findMethod(name.Lexeme)
 	if found {
-		return method.bindMethodToInstance(i), nil
+		return method.bind(i), nil
+	}
+
+	if i.Class.IsData {
+		switch name.Lexeme {
+		case "toString":
+			return nativeFunction{name: "toString", fn: func(*Interpreter, []interface{}) (interface{}, error) {
+				return i.dataString(), nil
+			}}, nil
+		case "copy":
+			return dataCopy{inst: i}, nil
+		}
 	}
 
 	return nil, fmt.Errorf("Undefined property %q.", name.Lexeme)
 }
 
-func (i *Instance) Set(name Token, value interface{}) {
+func (i *Instance) hasField(name string) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	_, found := i.Fields[name]
+	return found
+}
+
+// Set sets a field, returning whether it's a new one. The fields declared
+// by a data class can only be set by its initializer: they determine its
+// equality and its key as a set member, so they can't change afterwards.
+func (i *Instance) Set(name Token, value interface{}) (added bool, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	if i.Fields == nil {
 		i.Fields = make(map[string]interface{})
 	}
+	_, found := i.Fields[name.Lexeme]
+	if found && i.Class.IsData && i.Class.hasField(name.Lexeme) {
+		return false, errDataField(i.Class, name.Lexeme)
+	}
 	i.Fields[name.Lexeme] = value
+	return !found, nil
+}
+
+func errDataField(class *Class, name string) error {
+	return fmt.Errorf("Can't change field %q of a %s; copy() it instead.", name, class.Name)
+}
+
+// getPrivate looks up a private field, or else a private method, as seen
+// from methods declared by owner. Private methods aren't inherited.
+func (i *Instance) getPrivate(owner *Class, name Token) (interface{}, error) {
+	i.mu.RLock()
+	field, found := i.private[owner][name.Lexeme]
+	i.mu.RUnlock()
+	if found {
+		return field, nil
+	}
+
+	method, found := owner.Methods[name.Lexeme]
+	if found {
+		return method.bind(i), nil
+	}
+
+	return nil, fmt.Errorf("Undefined property %q.", name.Lexeme)
+}
+
+func (i *Instance) setPrivate(owner *Class, name Token, value interface{}) (added bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.private == nil {
+		i.private = make(map[*Class]map[string]interface{})
+	}
+	if i.private[owner] == nil {
+		i.private[owner] = make(map[string]interface{})
+	}
+	_, found := i.private[owner][name.Lexeme]
+	i.private[owner][name.Lexeme] = value
+	return !found
+}
+
+func (i *Instance) field(name string) interface{} {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.Fields[name]
+}
+
+// dataString formats a data class instance like "Point(x=1, y=2)".
+func (i *Instance) dataString() string {
+	parts := make([]string, len(i.Class.Fields))
+	for idx, name := range i.Class.Fields {
+		parts[idx] = fmt.Sprintf("%s=%v", name, i.field(name))
+	}
+	return fmt.Sprintf("%s(%s)", i.Class.Name, strings.Join(parts, ", "))
+}
+
+// dataEqual reports whether two instances of the same data class have
+// equal values for all of its declared fields.
+func (i *Instance) dataEqual(other *Instance) bool {
+	if i.Class != other.Class {
+		return false
+	}
+	for _, name := range i.Class.Fields {
+		if !isEqual(i.field(name), other.field(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// dataCopy is the copy() method of a data class instance. It takes only
+// named arguments, for the fields which should differ in the copy:
+//
+//	var q = p.copy(y: 3);
+type dataCopy struct {
+	inst *Instance
+}
+
+func (dc dataCopy) Arity() int { return 0 }
+
+func (dc dataCopy) Call(i *Interpreter, args []interface{}) interface{} {
+	return dc.CallNamed(i, args, nil)
+}
+
+func (dc dataCopy) CallNamed(i *Interpreter, args []interface{}, named map[string]interface{}) interface{} {
+	line := i.callLine
+	cp := &Instance{Class: dc.inst.Class}
+	dc.inst.mu.RLock()
+	cp.Fields = make(map[string]interface{}, len(dc.inst.Fields))
+	for name, value := range dc.inst.Fields {
+		cp.Fields[name] = value
+	}
+	for owner, fields := range dc.inst.private {
+		for name, value := range fields {
+			cp.setPrivate(owner, Token{Lexeme: name}, value)
+		}
+	}
+	dc.inst.mu.RUnlock()
+	i.allocate(line, instanceSize+fieldSize*len(cp.Fields))
+
+	for name, value := range named {
+		if !dc.inst.Class.hasField(name) {
+			i.runtimeError(line, fmt.Sprintf("%s has no field %q.", dc.inst.Class.Name, name))
+		}
+		cp.Fields[name] = value
+	}
+	return cp
+}
+
+func (dc dataCopy) String() string {
+	return "<native fn copy>"
+}
+
+// Interface is a set of method signatures. Classes may declare that they
+// implement it, which is checked when the class is defined, but the "is"
+// operator is duck-typed: any instance with matching methods satisfies it.
+type Interface struct {
+	Name    string
+	Methods []InterfaceMethod
+}
+
+func (in *Interface) String() string {
+	return in.Name
+}
+
+// missingMethod returns a description of the first of the interface's
+// methods which class doesn't implement (including by inheritance), or
+// "" if it implements them all.
+func (in *Interface) missingMethod(class *Class) string {
+	for _, required := range in.Methods {
+		method, found := class.findMethod(required.Name.Lexeme)
+		if !found {
+			return fmt.Sprintf("missing method %s()", required.Name.Lexeme)
+		}
+		if method.Arity() != len(required.Params) {
+			return fmt.Sprintf(
+				"method %s() takes %d parameters, but %d are required",
+				required.Name.Lexeme, method.Arity(), len(required.Params),
+			)
+		}
+	}
+	return ""
+}
+
+func (in *Interface) isSatisfiedBy(value interface{}) bool {
+	instance, ok := value.(*Instance)
+	return ok && in.missingMethod(instance.Class) == ""
 }
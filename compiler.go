@@ -0,0 +1,824 @@
+package main
+
+import "fmt"
+
+type compileError struct {
+	line int
+	msg  string
+}
+
+func (ce compileError) error() error {
+	return fmt.Errorf("compile error on line %d: %s", ce.line, ce.msg)
+}
+
+// vmFunction is a function compiled to bytecode. At runtime it's always
+// wrapped in a vmClosure, which holds its captured variables.
+type vmFunction struct {
+	name          string
+	arity         int
+	upvalueCount  int
+	chunk         Chunk
+	decl          FunctionStmt // for help()
+	isInitializer bool
+}
+
+func (f *vmFunction) String() string {
+	if f.name == "" {
+		return "<script>"
+	}
+	return fmt.Sprintf("<fn %s>", f.name)
+}
+
+// classTemplate is the constant operand of OP_CLASS.
+type classTemplate struct {
+	name   string
+	isData bool
+	fields []string
+	doc    string
+}
+
+func (ct *classTemplate) String() string {
+	return ct.name
+}
+
+// implementsCheck is the constant operand of OP_IMPLEMENTS.
+type implementsCheck struct {
+	interfaceName string
+	classLine     int
+}
+
+func (ic implementsCheck) String() string {
+	return ic.interfaceName
+}
+
+// assertTemplate is the constant operand of OP_ASSERT_FAIL.
+type assertTemplate struct {
+	source     string
+	hasMessage bool
+}
+
+func (at assertTemplate) String() string {
+	return at.source
+}
+
+// selectTemplate is the constant operand of OP_SELECT, describing the
+// operands it finds on the stack: a channel for each case, followed by
+// the value to send for send cases.
+type selectTemplate struct {
+	cases      []selectCaseTemplate
+	hasDefault bool
+}
+
+type selectCaseTemplate struct {
+	send bool
+	line int
+}
+
+const selectDefaultCase = 0xff
+
+type functionKind int
+
+const (
+	kindScript functionKind = iota
+	kindFunction
+	kindMethod
+	kindInitializer
+)
+
+type local struct {
+	name       string
+	depth      int // -1 until the variable is initialized
+	isCaptured bool
+}
+
+type upvalueRef struct {
+	index   byte
+	isLocal bool
+}
+
+// functionCompiler holds the state for the function currently being
+// compiled; they're chained to track the functions enclosing it.
+type functionCompiler struct {
+	enclosing  *functionCompiler
+	function   *vmFunction
+	kind       functionKind
+	locals     []local
+	upvalues   []upvalueRef
+	scopeDepth int
+}
+
+// Compiler compiles resolved statements to bytecode for the VM, in a
+// single pass over the AST. Like clox, it resolves local variables to
+// stack slots and captured variables to upvalues itself; the Resolver
+// has already reported any scoping errors.
+type Compiler struct {
+	current *functionCompiler
+	line    int // line of the last token seen, for code without a token of its own
+}
+
+// Compile compiles a program into the function for its top-level code.
+func (c *Compiler) Compile(stmts []Stmt) (script *vmFunction, returnErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ce, ok := r.(compileError)
+			if !ok {
+				panic(r)
+			}
+			returnErr = ce.error()
+		}
+	}()
+
+	c.beginFunction(kindScript, FunctionStmt{})
+	c.block(stmts)
+	return c.endFunction(), nil
+}
+
+func (c *Compiler) compileError(line int, msg string) {
+	panic(compileError{
+		line: line,
+		msg:  msg,
+	})
+}
+
+func (c *Compiler) beginFunction(kind functionKind, decl FunctionStmt) {
+	fc := &functionCompiler{
+		enclosing: c.current,
+		function: &vmFunction{
+			name:          decl.Name.Lexeme,
+			arity:         len(decl.Params),
+			decl:          decl,
+			isInitializer: kind == kindInitializer,
+		},
+		kind: kind,
+	}
+	// slot 0 holds the receiver in methods, and the function itself
+	// otherwise, where it can't be named
+	receiver := ""
+	if kind == kindMethod || kind == kindInitializer {
+		receiver = "this"
+	}
+	fc.locals = append(fc.locals, local{name: receiver})
+	c.current = fc
+}
+
+func (c *Compiler) endFunction() *vmFunction {
+	c.emitReturn()
+	fn := c.current.function
+	fn.upvalueCount = len(c.current.upvalues)
+	c.current = c.current.enclosing
+	return fn
+}
+
+// function compiles a function declaration, and emits the code to create
+// a closure for it.
+func (c *Compiler) function(kind functionKind, decl FunctionStmt) {
+	c.beginFunction(kind, decl)
+	c.beginScope()
+	for _, param := range decl.Params {
+		c.declareLocal(param)
+		c.markInitialized()
+	}
+	c.block(decl.Body)
+	fc := c.current
+	fn := c.endFunction()
+
+	c.line = decl.Name.Line
+	c.emitOpU16(OP_CLOSURE, c.makeConstant(fn))
+	for _, upvalue := range fc.upvalues {
+		isLocal := byte(0)
+		if upvalue.isLocal {
+			isLocal = 1
+		}
+		c.emitBytes(isLocal, upvalue.index)
+	}
+}
+
+func (c *Compiler) chunk() *Chunk {
+	return &c.current.function.chunk
+}
+
+func (c *Compiler) emitByte(b byte) {
+	c.chunk().write(b, c.line)
+}
+
+func (c *Compiler) emitBytes(bytes ...byte) {
+	for _, b := range bytes {
+		c.emitByte(b)
+	}
+}
+
+func (c *Compiler) emitOp(op OpCode) {
+	c.emitByte(byte(op))
+}
+
+func (c *Compiler) emitOpU16(op OpCode, operand int) {
+	c.emitBytes(byte(op), byte(operand>>8), byte(operand))
+}
+
+func (c *Compiler) emitReturn() {
+	if c.current.kind == kindInitializer {
+		c.emitBytes(byte(OP_GET_LOCAL), 0)
+	} else {
+		c.emitOp(OP_NIL)
+	}
+	c.emitOp(OP_RETURN)
+}
+
+func (c *Compiler) makeConstant(value interface{}) int {
+	index := c.chunk().addConstant(value)
+	if index > 0xffff {
+		c.compileError(c.line, "Too many constants in one chunk.")
+	}
+	return index
+}
+
+// emitJump emits a jump with a placeholder offset, returning the offset
+// of the placeholder for patchJump.
+func (c *Compiler) emitJump(op OpCode) int {
+	c.emitOpU16(op, 0xffff)
+	return len(c.chunk().Code) - 2
+}
+
+func (c *Compiler) patchJump(offset int) {
+	jump := len(c.chunk().Code) - offset - 2
+	if jump > 0xffff {
+		c.compileError(c.line, "Too much code to jump over.")
+	}
+	c.chunk().Code[offset] = byte(jump >> 8)
+	c.chunk().Code[offset+1] = byte(jump)
+}
+
+func (c *Compiler) emitLoop(loopStart int) {
+	offset := len(c.chunk().Code) - loopStart + 3
+	if offset > 0xffff {
+		c.compileError(c.line, "Loop body too large.")
+	}
+	c.emitOpU16(OP_LOOP, offset)
+}
+
+func (c *Compiler) beginScope() {
+	c.current.scopeDepth++
+}
+
+func (c *Compiler) endScope() {
+	fc := c.current
+	fc.scopeDepth--
+	for len(fc.locals) > 0 && fc.locals[len(fc.locals)-1].depth > fc.scopeDepth {
+		if fc.locals[len(fc.locals)-1].isCaptured {
+			c.emitOp(OP_CLOSE_UPVALUE)
+		} else {
+			c.emitOp(OP_POP)
+		}
+		fc.locals = fc.locals[:len(fc.locals)-1]
+	}
+}
+
+// declareLocal adds a local variable in the current scope. Its value is
+// whatever is on top of the stack when it's marked initialized.
+func (c *Compiler) declareLocal(name Token) {
+	if len(c.current.locals) > 0xff {
+		c.compileError(name.Line, "Too many local variables in function.")
+	}
+	c.current.locals = append(c.current.locals, local{name: name.Lexeme, depth: -1})
+}
+
+func (c *Compiler) markInitialized() {
+	if c.current.scopeDepth == 0 {
+		return
+	}
+	c.current.locals[len(c.current.locals)-1].depth = c.current.scopeDepth
+}
+
+// declareVariable declares a variable about to be defined by
+// defineVariable, which is a global at the top level of the script.
+func (c *Compiler) declareVariable(name Token) {
+	if c.current.scopeDepth > 0 {
+		c.declareLocal(name)
+	}
+}
+
+func (c *Compiler) defineVariable(name Token) {
+	if c.current.scopeDepth > 0 {
+		c.markInitialized()
+		return
+	}
+	c.line = name.Line
+	c.emitOpU16(OP_DEFINE_GLOBAL, c.makeConstant(name.Lexeme))
+}
+
+func resolveLocal(fc *functionCompiler, name string) int {
+	for idx := len(fc.locals) - 1; idx >= 0; idx-- {
+		if fc.locals[idx].name == name && fc.locals[idx].depth != -1 {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (c *Compiler) resolveUpvalue(fc *functionCompiler, name Token) int {
+	if fc.enclosing == nil {
+		return -1
+	}
+	if local := resolveLocal(fc.enclosing, name.Lexeme); local != -1 {
+		fc.enclosing.locals[local].isCaptured = true
+		return c.addUpvalue(fc, byte(local), true, name)
+	}
+	if upvalue := c.resolveUpvalue(fc.enclosing, name); upvalue != -1 {
+		return c.addUpvalue(fc, byte(upvalue), false, name)
+	}
+	return -1
+}
+
+func (c *Compiler) addUpvalue(fc *functionCompiler, index byte, isLocal bool, name Token) int {
+	for idx, upvalue := range fc.upvalues {
+		if upvalue.index == index && upvalue.isLocal == isLocal {
+			return idx
+		}
+	}
+	if len(fc.upvalues) > 0xff {
+		c.compileError(name.Line, "Too many closure variables in function.")
+	}
+	fc.upvalues = append(fc.upvalues, upvalueRef{index: index, isLocal: isLocal})
+	return len(fc.upvalues) - 1
+}
+
+// variable emits code to read a variable, or to assign the value on top
+// of the stack to it.
+func (c *Compiler) variable(name Token, assign bool) {
+	c.line = name.Line
+	op := OP_GET_LOCAL
+	arg := resolveLocal(c.current, name.Lexeme)
+	if arg == -1 {
+		op = OP_GET_UPVALUE
+		arg = c.resolveUpvalue(c.current, name)
+	}
+	if arg == -1 {
+		op = OP_GET_GLOBAL
+		if assign {
+			op = OP_SET_GLOBAL
+		}
+		c.emitOpU16(op, c.makeConstant(name.Lexeme))
+		return
+	}
+	if assign {
+		// each SET opcode directly follows its GET
+		op++
+	}
+	c.emitBytes(byte(op), byte(arg))
+}
+
+func (c *Compiler) expression(expr Expr) {
+	expr.Accept(c)
+}
+
+func (c *Compiler) VisitAssign(expr Expr) interface{} {
+	ae := expr.(Assign)
+	c.expression(ae.Value)
+	c.variable(ae.Name, true)
+	return nil
+}
+
+var binaryOpCodes = map[TokenType]OpCode{
+	BANG_EQUAL:    OP_NOT_EQUAL,
+	EQUAL_EQUAL:   OP_EQUAL,
+	GREATER:       OP_GREATER,
+	GREATER_EQUAL: OP_GREATER_EQUAL,
+	LESS:          OP_LESS,
+	LESS_EQUAL:    OP_LESS_EQUAL,
+	IS:            OP_IS,
+	PLUS:          OP_ADD,
+	MINUS:         OP_SUBTRACT,
+	STAR:          OP_MULTIPLY,
+	SLASH:         OP_DIVIDE,
+}
+
+func (c *Compiler) VisitBinary(expr Expr) interface{} {
+	be := expr.(Binary)
+	c.expression(be.Left)
+	c.expression(be.Right)
+	c.line = be.Operator.Line
+	c.emitOp(binaryOpCodes[be.Operator.Type])
+	return nil
+}
+
+func (c *Compiler) VisitCall(expr Expr) interface{} {
+	ce := expr.(Call)
+	if len(ce.Args) > 0xff {
+		c.compileError(ce.Paren.Line, "Can't have more than 255 arguments.")
+	}
+
+	// method calls are compiled to a single invoke instruction, which
+	// saves creating a bound method just to call it
+	if get, ok := ce.Callee.(Get); ok && get.Name.Type != PRIVATE_IDENTIFIER && ce.Named == nil {
+		c.expression(get.Object)
+		c.arguments(ce.Args)
+		c.line = ce.Paren.Line
+		c.emitOpU16(OP_INVOKE, c.makeConstant(get.Name.Lexeme))
+		c.emitByte(byte(len(ce.Args)))
+		return nil
+	}
+	if super, ok := ce.Callee.(Super); ok && ce.Named == nil {
+		c.variable(Token{Lexeme: "this", Line: super.Keyword.Line}, false)
+		c.arguments(ce.Args)
+		c.variable(super.Keyword, false)
+		c.line = ce.Paren.Line
+		c.emitOpU16(OP_SUPER_INVOKE, c.makeConstant(super.Method.Lexeme))
+		c.emitByte(byte(len(ce.Args)))
+		return nil
+	}
+
+	c.expression(ce.Callee)
+	c.arguments(ce.Args)
+	if ce.Named != nil {
+		c.namedArguments(ce.Named)
+		c.line = ce.Paren.Line
+		c.emitBytes(byte(OP_CALL_NAMED), byte(len(ce.Args)))
+		c.emitU16(c.makeConstant(namedArgNames(ce.Named)))
+		return nil
+	}
+	c.line = ce.Paren.Line
+	c.emitBytes(byte(OP_CALL), byte(len(ce.Args)))
+	return nil
+}
+
+func (c *Compiler) arguments(args []Expr) {
+	for _, arg := range args {
+		c.expression(arg)
+	}
+}
+
+func (c *Compiler) namedArguments(named []NamedArg) {
+	if len(named) > 0xff {
+		c.compileError(named[0].Name.Line, "Can't have more than 255 arguments.")
+	}
+	for _, arg := range named {
+		c.expression(arg.Value)
+	}
+}
+
+func namedArgNames(named []NamedArg) []Token {
+	names := make([]Token, len(named))
+	for idx, arg := range named {
+		names[idx] = arg.Name
+	}
+	return names
+}
+
+func (c *Compiler) emitU16(operand int) {
+	c.emitBytes(byte(operand>>8), byte(operand))
+}
+
+func (c *Compiler) VisitGet(expr Expr) interface{} {
+	ge := expr.(Get)
+	if ge.Name.Type == PRIVATE_IDENTIFIER {
+		c.privateReceiver(ge.Object, ge.Name)
+		c.line = ge.Name.Line
+		c.emitOpU16(OP_GET_PRIVATE, c.makeConstant(ge.Name.Lexeme))
+		return nil
+	}
+	c.expression(ge.Object)
+	c.line = ge.Name.Line
+	c.emitOpU16(OP_GET_PROPERTY, c.makeConstant(ge.Name.Lexeme))
+	return nil
+}
+
+// privateReceiver compiles the object of a private member access, which
+// has to be "this".
+func (c *Compiler) privateReceiver(obj Expr, name Token) {
+	if _, ok := obj.(This); !ok {
+		c.compileError(name.Line, fmt.Sprintf("Private member %q can only be accessed through 'this'.", name.Lexeme))
+	}
+	c.expression(obj)
+}
+
+func (c *Compiler) VisitGrouping(expr Expr) interface{} {
+	c.expression(expr.(Grouping).Expression)
+	return nil
+}
+
+func (c *Compiler) VisitLiteral(expr Expr) interface{} {
+	switch value := expr.(Literal).Value; value {
+	case nil:
+		c.emitOp(OP_NIL)
+	case true:
+		c.emitOp(OP_TRUE)
+	case false:
+		c.emitOp(OP_FALSE)
+	default:
+		c.emitOpU16(OP_CONSTANT, c.makeConstant(value))
+	}
+	return nil
+}
+
+func (c *Compiler) VisitLogical(expr Expr) interface{} {
+	le := expr.(Logical)
+	c.expression(le.Left)
+	c.line = le.Operator.Line
+	if le.Operator.Type == OR {
+		elseJump := c.emitJump(OP_JUMP_IF_FALSE)
+		endJump := c.emitJump(OP_JUMP)
+		c.patchJump(elseJump)
+		c.emitOp(OP_POP)
+		c.expression(le.Right)
+		c.patchJump(endJump)
+		return nil
+	}
+	endJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+	c.expression(le.Right)
+	c.patchJump(endJump)
+	return nil
+}
+
+func (c *Compiler) VisitSet(expr Expr) interface{} {
+	se := expr.(Set)
+	if se.Name.Type == PRIVATE_IDENTIFIER {
+		c.privateReceiver(se.Object, se.Name)
+		c.expression(se.Value)
+		c.line = se.Name.Line
+		c.emitOpU16(OP_SET_PRIVATE, c.makeConstant(se.Name.Lexeme))
+		return nil
+	}
+	c.expression(se.Object)
+	c.expression(se.Value)
+	c.line = se.Name.Line
+	c.emitOpU16(OP_SET_PROPERTY, c.makeConstant(se.Name.Lexeme))
+	return nil
+}
+
+func (c *Compiler) VisitSuper(expr Expr) interface{} {
+	se := expr.(Super)
+	c.variable(Token{Lexeme: "this", Line: se.Keyword.Line}, false)
+	c.variable(se.Keyword, false)
+	c.line = se.Method.Line
+	c.emitOpU16(OP_GET_SUPER, c.makeConstant(se.Method.Lexeme))
+	return nil
+}
+
+func (c *Compiler) VisitThis(expr Expr) interface{} {
+	c.variable(expr.(This).Keyword, false)
+	return nil
+}
+
+func (c *Compiler) VisityUnary(expr Expr) interface{} {
+	ue := expr.(Unary)
+	c.expression(ue.Right)
+	c.line = ue.Operator.Line
+	if ue.Operator.Type == MINUS {
+		c.emitOp(OP_NEGATE)
+	} else {
+		c.emitOp(OP_NOT)
+	}
+	return nil
+}
+
+func (c *Compiler) VisitVariable(expr Expr) interface{} {
+	c.variable(expr.(Variable).Name, false)
+	return nil
+}
+
+func (c *Compiler) VisitAssertStmt(stmt Stmt) interface{} {
+	as := stmt.(AssertStmt)
+	c.line = as.Keyword.Line
+	skipJump := c.emitJump(OP_ASSERT)
+	c.expression(as.Condition)
+	failJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+	passJump := c.emitJump(OP_JUMP)
+
+	c.patchJump(failJump)
+	c.emitOp(OP_POP)
+	if as.Message != nil {
+		c.expression(as.Message)
+	} else {
+		c.emitOp(OP_NIL)
+	}
+	c.line = as.Keyword.Line
+	c.emitOpU16(OP_ASSERT_FAIL, c.makeConstant(assertTemplate{source: as.Source, hasMessage: as.Message != nil}))
+
+	c.patchJump(passJump)
+	c.patchJump(skipJump)
+	return nil
+}
+
+func (c *Compiler) VisitBlockStmt(stmt Stmt) interface{} {
+	c.beginScope()
+	c.block(stmt.(BlockStmt).Statements)
+	c.endScope()
+	return nil
+}
+
+func (c *Compiler) block(stmts []Stmt) {
+	for _, stmt := range stmts {
+		c.statement(stmt)
+	}
+}
+
+// statement compiles stmt, starting with an instruction which counts it
+// against the statement budget, so that the VM counts the same statements
+// as the Interpreter.
+func (c *Compiler) statement(stmt Stmt) {
+	c.emitOp(OP_STATEMENT)
+	stmt.Accept(c)
+}
+
+func (c *Compiler) VisitClassStmt(stmt Stmt) interface{} {
+	cs := stmt.(ClassStmt)
+	c.line = cs.Name.Line
+	template := &classTemplate{name: cs.Name.Lexeme, isData: cs.IsData, doc: cs.Doc}
+	for _, field := range cs.Fields {
+		template.fields = append(template.fields, field.Lexeme)
+	}
+	c.declareVariable(cs.Name)
+	c.emitOpU16(OP_CLASS, c.makeConstant(template))
+	c.defineVariable(cs.Name)
+
+	if cs.Superclass != nil {
+		c.variable(cs.Superclass.Name, false)
+		c.beginScope()
+		c.declareLocal(Token{Lexeme: "super", Line: cs.Name.Line})
+		c.markInitialized()
+		c.variable(cs.Name, false)
+		c.line = cs.Name.Line
+		c.emitOp(OP_INHERIT)
+	}
+
+	c.variable(cs.Name, false)
+	for _, method := range cs.Methods {
+		kind := kindMethod
+		if method.Name.Lexeme == "init" {
+			kind = kindInitializer
+		}
+		c.function(kind, method)
+		c.emitOpU16(OP_METHOD, c.makeConstant(method.Name.Lexeme))
+	}
+	for _, iface := range cs.Interfaces {
+		c.variable(iface.Name, false)
+		c.emitOpU16(OP_IMPLEMENTS, c.makeConstant(implementsCheck{
+			interfaceName: iface.Name.Lexeme,
+			classLine:     cs.Name.Line,
+		}))
+	}
+	c.emitOp(OP_POP)
+
+	if cs.Superclass != nil {
+		c.endScope()
+	}
+	return nil
+}
+
+func (c *Compiler) VisitExpressionStmt(stmt Stmt) interface{} {
+	c.expression(stmt.(ExprStmt).Expression)
+	c.emitOp(OP_POP)
+	return nil
+}
+
+func (c *Compiler) VisitFunctionStmt(stmt Stmt) interface{} {
+	fs := stmt.(FunctionStmt)
+	c.declareVariable(fs.Name)
+	// functions can refer to themselves, so are initialized straight away
+	c.markInitialized()
+	c.function(kindFunction, fs)
+	c.defineVariable(fs.Name)
+	return nil
+}
+
+func (c *Compiler) VisitIfStmt(stmt Stmt) interface{} {
+	is := stmt.(IfStmt)
+	c.expression(is.Condition)
+	thenJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+	c.statement(is.Then)
+	elseJump := c.emitJump(OP_JUMP)
+	c.patchJump(thenJump)
+	c.emitOp(OP_POP)
+	if is.Else != nil {
+		c.statement(is.Else)
+	}
+	c.patchJump(elseJump)
+	return nil
+}
+
+func (c *Compiler) VisitInterfaceStmt(stmt Stmt) interface{} {
+	is := stmt.(InterfaceStmt)
+	c.line = is.Name.Line
+	c.declareVariable(is.Name)
+	c.emitOpU16(OP_CONSTANT, c.makeConstant(&Interface{
+		Name:    is.Name.Lexeme,
+		Methods: is.Methods,
+	}))
+	c.defineVariable(is.Name)
+	return nil
+}
+
+func (c *Compiler) VisitPrintStmt(stmt Stmt) interface{} {
+	c.expression(stmt.(PrintStmt).Expression)
+	c.emitOp(OP_PRINT)
+	return nil
+}
+
+func (c *Compiler) VisitReturnStmt(stmt Stmt) interface{} {
+	rs := stmt.(ReturnStmt)
+	c.line = rs.Keyword.Line
+	if rs.Value == nil {
+		c.emitReturn()
+		return nil
+	}
+	c.expression(rs.Value)
+	c.emitOp(OP_RETURN)
+	return nil
+}
+
+func (c *Compiler) VisitSelectStmt(stmt Stmt) interface{} {
+	ss := stmt.(SelectStmt)
+	template := selectTemplate{hasDefault: ss.Default != nil}
+	for _, sc := range ss.Cases {
+		c.expression(sc.Channel)
+		if sc.Send {
+			c.expression(sc.Value)
+		}
+		template.cases = append(template.cases, selectCaseTemplate{send: sc.Send, line: sc.Keyword.Line})
+	}
+	if len(ss.Cases) >= selectDefaultCase {
+		c.compileError(ss.Keyword.Line, "Too many cases in select.")
+	}
+	// OP_SELECT leaves the received value (or nil) and the index of the
+	// chosen case on the stack, then each OP_SELECT_CASE either jumps to
+	// the next one, or pops the index and runs its case
+	c.line = ss.Keyword.Line
+	c.emitOpU16(OP_SELECT, c.makeConstant(template))
+
+	var endJumps []int
+	selectCase := func(index byte, name *Token, body []Stmt) {
+		c.emitBytes(byte(OP_SELECT_CASE), index)
+		nextCase := c.emitJumpOperand()
+		c.beginScope()
+		if name != nil {
+			c.declareLocal(*name)
+			c.markInitialized()
+		} else {
+			c.emitOp(OP_POP)
+		}
+		c.block(body)
+		c.endScope()
+		endJumps = append(endJumps, c.emitJump(OP_JUMP))
+		c.patchJump(nextCase)
+	}
+	for idx, sc := range ss.Cases {
+		selectCase(byte(idx), sc.Name, sc.Body)
+	}
+	if ss.Default != nil {
+		selectCase(selectDefaultCase, nil, ss.Default)
+	}
+	for _, jump := range endJumps {
+		c.patchJump(jump)
+	}
+	return nil
+}
+
+// emitJumpOperand emits a placeholder jump offset for patchJump, as the
+// operand of the instruction just emitted.
+func (c *Compiler) emitJumpOperand() int {
+	c.emitU16(0xffff)
+	return len(c.chunk().Code) - 2
+}
+
+func (c *Compiler) VisitSpawnStmt(stmt Stmt) interface{} {
+	call := stmt.(SpawnStmt).Call
+	if len(call.Args) > 0xff {
+		c.compileError(call.Paren.Line, "Can't have more than 255 arguments.")
+	}
+	c.expression(call.Callee)
+	c.arguments(call.Args)
+	c.namedArguments(call.Named)
+	c.line = call.Paren.Line
+	c.emitBytes(byte(OP_SPAWN), byte(len(call.Args)))
+	c.emitU16(c.makeConstant(namedArgNames(call.Named)))
+	return nil
+}
+
+func (c *Compiler) VisitVarStmt(stmt Stmt) interface{} {
+	vs := stmt.(VariableStmt)
+	c.declareVariable(vs.Name)
+	if vs.Initializer != nil {
+		c.expression(vs.Initializer)
+	} else {
+		c.line = vs.Name.Line
+		c.emitOp(OP_NIL)
+	}
+	c.defineVariable(vs.Name)
+	return nil
+}
+
+func (c *Compiler) VisitWhileStmt(stmt Stmt) interface{} {
+	ws := stmt.(WhileStmt)
+	loopStart := len(c.chunk().Code)
+	c.expression(ws.Condition)
+	exitJump := c.emitJump(OP_JUMP_IF_FALSE)
+	c.emitOp(OP_POP)
+	c.statement(ws.Body)
+	c.emitLoop(loopStart)
+	c.patchJump(exitJump)
+	c.emitOp(OP_POP)
+	return nil
+}
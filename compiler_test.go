@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompiler_Compile(t *testing.T) {
+	testCases := map[string]struct {
+		in          string
+		expected    []OpCode // the script's instructions, in order
+		errExpected bool
+		expectedErr string
+	}{
+		"arithmetic": {
+			in:       "print 1 + 2 * 3;",
+			expected: []OpCode{OP_STATEMENT, OP_CONSTANT, OP_CONSTANT, OP_CONSTANT, OP_MULTIPLY, OP_ADD, OP_PRINT, OP_NIL, OP_RETURN},
+		},
+		"globals": {
+			in:       "var a = 1; a = a;",
+			expected: []OpCode{OP_STATEMENT, OP_CONSTANT, OP_DEFINE_GLOBAL, OP_STATEMENT, OP_GET_GLOBAL, OP_SET_GLOBAL, OP_POP, OP_NIL, OP_RETURN},
+		},
+		"locals are stack slots": {
+			in:       "{ var a = 1; print a; }",
+			expected: []OpCode{OP_STATEMENT, OP_STATEMENT, OP_CONSTANT, OP_STATEMENT, OP_GET_LOCAL, OP_PRINT, OP_POP, OP_NIL, OP_RETURN},
+		},
+		"captured locals are closed": {
+			in: "{ var a = 1; fun f() { return a; } print f; }",
+			expected: []OpCode{
+				OP_STATEMENT, OP_STATEMENT, OP_CONSTANT, OP_STATEMENT, OP_CLOSURE,
+				OP_STATEMENT, OP_GET_LOCAL, OP_PRINT, OP_POP, OP_CLOSE_UPVALUE, OP_NIL, OP_RETURN,
+			},
+		},
+		"method calls are invoked": {
+			in:       "class A { m() {} } A().m();",
+			expected: []OpCode{OP_STATEMENT, OP_CLASS, OP_DEFINE_GLOBAL, OP_GET_GLOBAL, OP_CLOSURE, OP_METHOD, OP_POP, OP_STATEMENT, OP_GET_GLOBAL, OP_CALL, OP_INVOKE, OP_POP, OP_NIL, OP_RETURN},
+		},
+		"private members only through this": {
+			in:          "class A { m(other) { return other.#x; } }",
+			errExpected: true,
+			expectedErr: "compile error on line 1: Private member \"#x\" can only be accessed through 'this'.",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tokens, scanErr := (&Scanner{}).ScanTokens(tc.in)
+			if scanErr != nil {
+				t.Fatalf("scanning error in test input: %s", scanErr)
+			}
+			stmts, parseErr := (&Parser{Tokens: tokens}).Parse()
+			if parseErr != nil {
+				t.Fatalf("parsing error in test input: %s", parseErr)
+			}
+			script, err := (&Compiler{}).Compile(stmts)
+			if tc.errExpected {
+				if err == nil {
+					t.Fatal("expected error, didn't get one")
+				}
+				if !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Errorf("expected error containing %q, got %q", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			listing := script.chunk.Disassemble(script.String())
+			var actual []OpCode
+			for _, line := range strings.Split(listing, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) < 3 || !strings.HasPrefix(fields[2], "OP_") {
+					continue
+				}
+				for op, opName := range opCodeNames {
+					if opName == fields[2] {
+						actual = append(actual, OpCode(op))
+					}
+				}
+			}
+			if len(actual) != len(tc.expected) {
+				t.Fatalf("expected %v, got %v\n%s", tc.expected, actual, listing)
+			}
+			for idx := range actual {
+				if actual[idx] != tc.expected[idx] {
+					t.Fatalf("expected %v, got %v\n%s", tc.expected, actual, listing)
+				}
+			}
+		})
+	}
+}
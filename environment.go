@@ -1,11 +1,21 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
+// environment holds the variables of one scope. The global environment
+// looks them up by name, in envMap; local environments keep them in
+// values, in the order they're declared, which is how the Resolver
+// assigns their slots. Environments are shared by every task which closes
+// over them, so access is guarded by mu.
 type environment struct {
+	mu          sync.RWMutex
 	envMap      map[string]interface{}
+	values      []interface{}
 	enclosing   *environment
-	interpreter *Interpreter
+	interpreter *Interpreter // for reporting undefined globals
 }
 
 func (e *environment) ensureInit() {
@@ -14,39 +24,45 @@ func (e *environment) ensureInit() {
 	}
 }
 
+// define defines a global variable.
 func (e *environment) define(name string, value interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	e.ensureInit()
 	e.envMap[name] = value
 }
 
 func (e *environment) assign(name Token, value interface{}) {
+	e.mu.Lock()
 	e.ensureInit()
 	_, found := e.envMap[name.Lexeme]
-
 	if found {
 		e.envMap[name.Lexeme] = value
-		return
 	}
+	e.mu.Unlock()
 
-	if e.enclosing != nil {
-		e.enclosing.assign(name, value)
-		return
+	if !found {
+		e.interpreter.runtimeError(name.Line, fmt.Sprintf("Undefined (global) variable %q in assignment.", name.Lexeme))
 	}
-
-	e.interpreter.runtimeError(name.Line, fmt.Sprintf("Undefined (global) variable %q in assignment.", name.Lexeme))
 }
 
 func (e *environment) get(tok Token) interface{} {
+	e.mu.RLock()
 	value, found := e.envMap[tok.Lexeme]
+	e.mu.RUnlock()
 	if !found {
-		if e.enclosing != nil {
-			return e.enclosing.get(tok)
-		}
 		e.interpreter.runtimeError(tok.Line, fmt.Sprintf("Undefined (global) variable %q.", tok.Lexeme))
 	}
 	return value
 }
 
+// add defines a local variable in the next slot.
+func (e *environment) add(value interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.values = append(e.values, value)
+}
+
 func (e *environment) ancestor(distance int) *environment {
 	env := e
 	for i := 0; i < distance; i++ {
@@ -55,21 +71,16 @@ func (e *environment) ancestor(distance int) *environment {
 	return env
 }
 
-func (e *environment) assignAt(distance int, name Token, value interface{}) {
+func (e *environment) assignAt(distance, slot int, value interface{}) {
 	env := e.ancestor(distance)
-	env.ensureInit()
-	_, found := env.envMap[name.Lexeme]
-	if !found {
-		e.interpreter.runtimeError(name.Line, fmt.Sprintf("Undefined (local) variable %q in assignment.", name.Lexeme))
-	}
-	env.envMap[name.Lexeme] = value
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.values[slot] = value
 }
 
-func (e *environment) getAt(distance int, tok Token) interface{} {
+func (e *environment) getAt(distance, slot int) interface{} {
 	env := e.ancestor(distance)
-	value, found := env.envMap[tok.Lexeme]
-	if !found {
-		e.interpreter.runtimeError(tok.Line, fmt.Sprintf("Undefined (local) variable %q", tok.Lexeme))
-	}
-	return value
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	return env.values[slot]
 }
@@ -24,8 +24,9 @@ type ExprVisitor interface {
 }
 
 type Assign struct {
-	Name  Token
-	Value Expr
+	Name    Token
+	Value   Expr
+	Binding *Binding
 }
 
 func (a Assign) Accept(v ExprVisitor) interface{} {
@@ -50,6 +51,12 @@ type Call struct {
 	Callee Expr
 	Paren  Token
 	Args   []Expr
+	Named  []NamedArg // "name: value" arguments, which follow any others
+}
+
+type NamedArg struct {
+	Name  Token
+	Value Expr
 }
 
 func (c Call) Accept(v ExprVisitor) interface{} {
@@ -63,6 +70,7 @@ func (c Call) String() string {
 type Get struct {
 	Object Expr
 	Name   Token
+	Cache  *MethodCache
 }
 
 func (g Get) Accept(v ExprVisitor) interface{} {
@@ -116,6 +124,7 @@ func (l Logical) Accept(v ExprVisitor) interface{} {
 type Super struct {
 	Keyword Token
 	Method  Token
+	Binding *Binding
 }
 
 func (s Super) Accept(v ExprVisitor) interface{} {
@@ -124,6 +133,7 @@ func (s Super) Accept(v ExprVisitor) interface{} {
 
 type This struct {
 	Keyword Token
+	Binding *Binding
 }
 
 func (t This) Accept(v ExprVisitor) interface{} {
@@ -140,30 +150,8 @@ func (u Unary) Accept(v ExprVisitor) interface{} {
 }
 
 type Variable struct {
-	Name Token
-	// Needed due to a quirk of our resolver implementation...
-	// We are storing scope-distance lookups in a hash table,
-	// with the keys being the variable expressions themselves.
-	// In Golang, structs are hashable for this purpose, but
-	// they are hashed based on their contents... not just the
-	// value of a pointer to the object itself (asin E.g. Java).
-	// This allows different expressions to collide with one another
-	// if their contents are the same.
-	//
-	// This surfaces particularly in the syntactic-sugar of for-loops,
-	// which end up expanding to a while-loop with a special inner
-	// block to contain the incrementor. So in this declaration:
-	//   for ; i < 3; i = i + 1 { ... }
-	// The "i < 3" and the "i = i + 1" expressions are in different
-	// blocks, and should have difference scope-distance values.
-	// But because they are declared on the same line, both
-	// references to "i" will end up with the same internal
-	// representation, and will collide... The resolver will resolve
-	// both of them, but the latter will overwrite the former.
-	//
-	// This field lets us inject a unique identifier for each one
-	// to ensure they don't collide.
-	Unique int
+	Name    Token
+	Binding *Binding
 }
 
 func (v Variable) Accept(visitor ExprVisitor) interface{} {
@@ -171,5 +159,16 @@ func (v Variable) Accept(visitor ExprVisitor) interface{} {
 }
 
 func (v Variable) String() string {
-	return fmt.Sprintf("var(%v)[%d]", v.Name, v.Unique)
+	return fmt.Sprintf("var(%v)", v.Name)
+}
+
+// Binding records where the Resolver found the variable referred to by a
+// Variable, Assign, This or Super expression. The parser gives each
+// reference its own Binding for the Resolver to fill in; it's a pointer
+// so that every copy of the (value-typed) node sees the result.
+// References the Resolver didn't find in any scope are to globals.
+type Binding struct {
+	Local bool
+	Depth int // how many environments out from the one it's used in
+	Slot  int // index of the variable in that environment
 }
@@ -2,62 +2,86 @@ package main
 
 import "fmt"
 
+// A method's environment holds "this" and, in the slot after it, the
+// class that declared the method, which determines the private members
+// it can see, followed by its parameters. The Resolver reserves the
+// slot, so scripts can't read it.
+const (
+	thisSlot  = 0
+	ownerSlot = 1
+)
+
 type Function struct {
 	Declaration   FunctionStmt
 	Closure       *environment
-	isInitializer bool // allows us to return "this" from a re-call to init()
+	isInitializer bool   // allows us to return "this" from a re-call to init()
+	owner         *Class // the class declaring this method, nil for plain functions
+	// "this" for a method bound to an instance; it's only put in an
+	// environment when the method is called
+	receiver *Instance
 }
 
 func (f Function) bindMethodToInstance(inst *Instance) Function {
-	env := &environment{enclosing: f.Closure}
-	env.define("this", inst)
-	return Function{
-		Declaration:   f.Declaration,
-		Closure:       env,
-		isInitializer: f.isInitializer,
-	}
+	f.receiver = inst
+	return f
+}
+
+func (f Function) bind(inst *Instance) Callable {
+	return f.bindMethodToInstance(inst)
+}
+
+func (f Function) declaration() FunctionStmt {
+	return f.Declaration
 }
 
 func (f Function) Arity() int {
 	return len(f.Declaration.Params)
 }
 
-func (f Function) Call(i *Interpreter, params []interface{}) (returnVal interface{}) {
-	newEnv := &environment{
-		// note that call semantics mean we can't see variables
-		// in the caller's scope, only globals
-		enclosing:   f.Closure,
-		interpreter: i,
-	}
-	for i, param := range params {
-		newEnv.define(f.Declaration.Params[i].Lexeme, param)
+func (f Function) Call(i *Interpreter, params []interface{}) interface{} {
+	// if this panics, Interpret resets the depth
+	i.callDepth++
+	if i.callDepth > i.maxCallDepth() {
+		i.stackOverflow(i.callLine, f.Declaration.Name.Lexeme)
 	}
-
-	// We unwind the interpreter's internal call stack when it
-	// hits a "return" statement by throwing an exception/panicking.
-	// To harvest the returned value, we pack it into the panic()
-	// parameter and catch it here.
-	//
-	// This seems janky.
-	defer func() {
-		r := recover()
-		if r == nil {
-			return
+	for {
+		var values []interface{}
+		if f.owner != nil {
+			values = append(make([]interface{}, 0, 2+len(params)), f.receiver, f.owner)
+		} else {
+			values = make([]interface{}, 0, len(params))
 		}
-		returned, ok := r.(returnable)
-		if !ok {
-			panic(r)
+		values = append(values, params...)
+		i.allocate(i.callLine, environmentSize+valueSize*len(values))
+		newEnv := &environment{
+			// note that call semantics mean we can't see variables
+			// in the caller's scope, only globals
+			enclosing: f.Closure,
+			values:    values,
 		}
-		returnVal = returned.Value
-	}()
 
-	i.executeBlock(f.Declaration.Body, newEnv)
+		c := i.executeBlock(f.Declaration.Body, newEnv)
+		if c != nil && c.kind == completionError {
+			// Callable can't return errors, so this carries it out of
+			// the caller's expression, as far as the caller's statement
+			panic(c)
+		}
+		if c != nil && c.kind == completionTailCall {
+			// the body returned the result of calling another function,
+			// so call it in place of this one
+			f, params = c.function, c.args
+			continue
+		}
 
-	if f.isInitializer {
-		return f.Closure.getAt(0, Token{Lexeme: "this"})
+		i.callDepth--
+		if f.isInitializer {
+			return f.receiver
+		}
+		if c != nil {
+			return c.value
+		}
+		return nil
 	}
-
-	return nil
 }
 
 func (f Function) String() string {
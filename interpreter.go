@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"reflect"
+	"math/big"
+	"runtime/debug"
+	"sync/atomic"
 )
 
 type runtimeError struct {
@@ -15,8 +19,152 @@ func (rte runtimeError) error() error {
 	return fmt.Errorf("runtime error on line %d: %s", rte.line, rte.msg)
 }
 
-type returnable struct {
-	Value interface{}
+// recoveredError converts a value recovered from a panic into the error
+// to report. Anything other than a runtimeError is a bug in glox rather
+// than in the script, so it's reported along with where it happened.
+func recoveredError(r interface{}) error {
+	switch r := r.(type) {
+	case runtimeError:
+		return r.error()
+	case interruption:
+		return r.err
+	case *completion:
+		// an error completion, carried out of a call by Function.Call
+		return r.err
+	case internalError:
+		return fmt.Errorf("internal error: %v\n%s", r.value, r.stack)
+	}
+	return fmt.Errorf("internal error: %v\n%s", r, debug.Stack())
+}
+
+// internalError wraps a panic other than a runtime error or interruption,
+// along with the stack where it happened, so that it can be passed on by
+// execute without losing track of its origin.
+type internalError struct {
+	value interface{}
+	stack []byte
+}
+
+var (
+	// ErrCancelled is returned by InterpretContext when its context is
+	// done before the program finishes.
+	ErrCancelled = errors.New("execution cancelled")
+	// ErrBudgetExhausted is returned when a program uses up the
+	// Interpreter's StatementBudget.
+	ErrBudgetExhausted = errors.New("budget exhausted")
+)
+
+// interruption is panicked with to stop a program part way through, for
+// one of the reasons above. Unlike a runtimeError it isn't the script's
+// fault, so it's reported without a line number.
+type interruption struct {
+	err error
+}
+
+// limits are checked as a program runs, to stop it early. They're shared
+// by the Interpreters of all of the program's tasks, so spawning more
+// tasks doesn't get around them.
+type limits struct {
+	done      <-chan struct{} // nil if the program can't be cancelled
+	budget    int64           // 0 if unlimited
+	used      int64           // accessed atomically
+	quota     int64           // 0 if unlimited
+	allocated int64           // accessed atomically
+}
+
+func newLimits(ctx context.Context, i *Interpreter) *limits {
+	return &limits{done: ctx.Done(), budget: i.StatementBudget, quota: i.MemoryQuota}
+}
+
+// step uses one unit of the budget, and stops the program if that
+// exhausts it or the program has been cancelled.
+func (l *limits) step() {
+	if used := atomic.AddInt64(&l.used, 1); l.budget > 0 && used > l.budget {
+		panic(interruption{ErrBudgetExhausted})
+	}
+	select {
+	case <-l.done:
+		panic(interruption{ErrCancelled})
+	default:
+	}
+}
+
+// Approximate sizes, in bytes, of what a program allocates, for the
+// memory quota. They're meant to be in proportion to what the Go runtime
+// allocates, rather than exact.
+const (
+	stringSize      = 16 // plus the length of the string
+	instanceSize    = 64
+	fieldSize       = 48 // for each field set on an instance
+	environmentSize = 64 // for a function call
+	valueSize       = 16 // for each variable, or element of a collection
+	setMemberSize   = 64
+)
+
+// allocate charges size bytes to the memory quota, raising a runtime
+// error if that exceeds it.
+func (i *Interpreter) allocate(line int, size int) {
+	l := i.limits
+	if l == nil {
+		// not running a program, e.g. when the Optimizer folds constants
+		return
+	}
+	if allocated := atomic.AddInt64(&l.allocated, int64(size)); l.quota > 0 && allocated > l.quota {
+		i.runtimeError(line, fmt.Sprintf("Memory quota of %d bytes exceeded.", l.quota))
+	}
+}
+
+// MemoryUsed returns roughly how many bytes the program being run (or
+// last run) has allocated so far, as counted against MemoryQuota. It can
+// be called while the program runs.
+func (i *Interpreter) MemoryUsed() int64 {
+	if l := i.limits; l != nil {
+		return atomic.LoadInt64(&l.allocated)
+	}
+	return 0
+}
+
+// StatementsExecuted returns how many statements the program being run
+// (or last run) has executed so far, as counted against StatementBudget.
+// It can be called while the program runs.
+func (i *Interpreter) StatementsExecuted() int64 {
+	if l := i.limits; l != nil {
+		return atomic.LoadInt64(&l.used)
+	}
+	return 0
+}
+
+type completionKind int
+
+const (
+	completionReturn completionKind = iota
+	completionTailCall
+	completionError
+)
+
+// completion is how executing a statement reports that control doesn't
+// simply continue with the next statement: because of a "return", or a
+// runtime error or interruption. Statements which complete normally
+// return nil. A statement which detects an error itself returns an
+// errorCompletion; one raised while evaluating an expression unwinds with
+// a panic only as far as execute, which turns it into a completion.
+// Callable has no way to return errors, so Function.Call panics with an
+// error completion for execute in the caller to pick up again.
+//
+// A "return" whose value is a call to a Lox function completes with
+// completionTailCall, leaving the call to be made by the Function.Call
+// that's running, once it has unwound, so that tail calls don't grow the
+// Go stack.
+type completion struct {
+	kind     completionKind
+	value    interface{}   // for completionReturn
+	function Function      // for completionTailCall
+	args     []interface{} // for completionTailCall
+	err      error         // for completionError
+}
+
+func errorCompletion(line int, msg string) *completion {
+	return &completion{kind: completionError, err: runtimeError{line: line, msg: msg}.error()}
 }
 
 type Callable interface {
@@ -24,37 +172,98 @@ type Callable interface {
 	Call(interpreter *Interpreter, args []interface{}) interface{}
 }
 
-type Interpreter struct {
-	Stdout        io.Writer
-	localDistance map[Expr]int // <-- this is so dumb
-	globals       *environment
-	env           *environment
-	initialized   bool
+// namedCallable is implemented by callables which also accept
+// "name: value" arguments, like the copy() method of data classes.
+type namedCallable interface {
+	Callable
+	CallNamed(interpreter *Interpreter, args []interface{}, named map[string]interface{}) interface{}
+}
+
+// propertyGetter is implemented by runtime values which support the '.'
+// operator, i.e. class instances and native types like Channel.
+type propertyGetter interface {
+	Get(name Token) (interface{}, error)
 }
 
-func (i *Interpreter) Interpret(stmts []Stmt) (returnErr error) {
+// Interpreter executes statements for a single task. Tasks started with
+// "spawn" each get their own Interpreter (see fork()), sharing only the
+// globals, scheduler and limits with the task that started them.
+type Interpreter struct {
+	Stdout io.Writer
+	// DisableAssertions turns assert statements into no-ops, without
+	// evaluating their conditions.
+	DisableAssertions bool
+	// MaxCallDepth is how deeply calls to Lox functions can nest before
+	// they raise a "Stack overflow." runtime error. Zero means
+	// DefaultMaxCallDepth.
+	MaxCallDepth int
+	// StatementBudget limits how many statements a program can execute,
+	// counting every iteration of a loop's body, before it's stopped with
+	// ErrBudgetExhausted. The VM counts the same statements. Zero means
+	// unlimited.
+	StatementBudget int64
+	// MemoryQuota limits roughly how many bytes a program can allocate
+	// in strings, instances, variables and collections, in total, before
+	// it raises a runtime error. Memory isn't credited back when it's
+	// freed. Zero means unlimited. See also MemoryUsed.
+	MemoryQuota int64
+	limits      *limits
+	globals     *environment
+	env         *environment
+	tasks       *scheduler
+	callLine    int // line of the call currently being made, for builtins
+	callDepth   int // number of Lox function calls in progress
+	initialized bool
+	vm          *VM // set when the task's code is run by a VM, see NewVM
+}
+
+// Interpret runs stmts in the main task. It doesn't return until any
+// tasks spawned along the way have finished; a runtime error in one of
+// those is returned if the main task didn't raise one of its own.
+func (i *Interpreter) Interpret(stmts []Stmt) error {
+	return i.InterpretContext(context.Background(), stmts)
+}
+
+// InterpretContext is like Interpret, but stops the program, including
+// any tasks it has spawned, with ErrCancelled once ctx is done. Either
+// way, the Interpreter can be used again afterwards.
+func (i *Interpreter) InterpretContext(ctx context.Context, stmts []Stmt) (returnErr error) {
+	i.ensureInit()
+	i.limits = newLimits(ctx, i)
+	i.tasks.done = i.limits.done
+
+	i.tasks.begin()
 	defer func() {
 		if r := recover(); r != nil {
-			returnErr = r.(runtimeError).error()
+			returnErr = recoveredError(r)
+			// the error may have been raised part way into a block,
+			// and any number of calls deep
+			i.env = i.globals
+			i.callDepth = 0
+		}
+		i.tasks.finish()
+		if err := i.tasks.wait(); returnErr == nil {
+			returnErr = err
 		}
 	}()
 
-	if i.env == nil {
-		i.init()
-	}
-
 	for _, stmt := range stmts {
-		i.execute(stmt)
+		if c := i.execute(stmt); c != nil {
+			if c.kind == completionError {
+				i.env = i.globals
+				i.callDepth = 0
+				return c.err
+			}
+			if c.kind == completionTailCall {
+				c.function.Call(i, c.args)
+			}
+			break // a top-level "return;" ends the script
+		}
 	}
 
 	return nil
 }
 
-func (i *Interpreter) Resolve(expr Expr, distance int) {
-	i.ensureInit()
-	i.localDistance[expr] = distance
-}
-
 func (i *Interpreter) ensureInit() {
 	if !i.initialized {
 		i.init()
@@ -65,10 +274,55 @@ func (i *Interpreter) init() {
 	i.env = &environment{interpreter: i}
 	i.globals = i.env
 	i.globals.define("clock", ClockBuiltin{})
-	i.localDistance = make(map[Expr]int)
+	i.globals.define("channel", ChannelBuiltin{})
+	i.globals.define("bigint", BigIntBuiltin{})
+	i.globals.define("decimal", DecimalBuiltin{})
+	i.globals.define("number", NumberBuiltin{})
+	i.globals.define("set", SetBuiltin{})
+	i.globals.define("stringBuilder", StringBuilderBuiltin{})
+	i.globals.define("help", helpBuiltin)
+	for _, builtin := range reflectionBuiltins {
+		i.globals.define(builtin.name, builtin)
+	}
+	i.tasks = &scheduler{}
 	i.initialized = true
 }
 
+// fork returns an Interpreter for a new task, starting out in the same
+// environment as i.
+func (i *Interpreter) fork() *Interpreter {
+	return &Interpreter{
+		Stdout:            i.Stdout,
+		DisableAssertions: i.DisableAssertions,
+		MaxCallDepth:      i.MaxCallDepth,
+		limits:            i.limits,
+		globals:           i.globals,
+		env:               i.env,
+		tasks:             i.tasks,
+		initialized:       true,
+	}
+}
+
+// DefaultMaxCallDepth is the default for Interpreter.MaxCallDepth. It's
+// well short of the depth at which the tree-walking interpreter would
+// exhaust the Go stack.
+const DefaultMaxCallDepth = 10000
+
+func (i *Interpreter) maxCallDepth() int {
+	if i.MaxCallDepth > 0 {
+		return i.MaxCallDepth
+	}
+	return DefaultMaxCallDepth
+}
+
+// stackOverflow raises the error for a call to the named function which
+// would nest calls more than MaxCallDepth deep.
+func (i *Interpreter) stackOverflow(line int, name string) {
+	i.runtimeError(line, fmt.Sprintf(
+		"Stack overflow. Calling %q exceeded the limit of %d nested calls.", name, i.maxCallDepth(),
+	))
+}
+
 func (i *Interpreter) runtimeError(line int, msg string) {
 	panic(runtimeError{
 		line: line,
@@ -80,28 +334,52 @@ func (i *Interpreter) evaluate(expr Expr) interface{} {
 	return expr.Accept(i)
 }
 
-func (i *Interpreter) execute(stmt Stmt) {
-	stmt.Accept(i)
+func (i *Interpreter) execute(stmt Stmt) (c *completion) {
+	defer func() {
+		if r := recover(); r != nil {
+			c = i.recoverCompletion(r)
+		}
+	}()
+	i.limits.step()
+	c, _ = stmt.Accept(i).(*completion)
+	return c
 }
 
-func (i *Interpreter) executeBlock(stmts []Stmt, newEnv *environment) {
+// recoverCompletion turns an error which was raised by panicking into an
+// error completion. Any other panic is a bug in glox, and carries on
+// unwinding, with the stack where it happened.
+func (i *Interpreter) recoverCompletion(r interface{}) *completion {
+	switch r := r.(type) {
+	case *completion:
+		return r
+	case runtimeError, interruption:
+		return &completion{kind: completionError, err: recoveredError(r)}
+	case internalError:
+		panic(r)
+	}
+	panic(internalError{value: r, stack: debug.Stack()})
+}
+
+// executeBlock executes stmts in newEnv, stopping at the first which
+// doesn't complete normally.
+func (i *Interpreter) executeBlock(stmts []Stmt, newEnv *environment) *completion {
 	prevEnv := i.env
-	defer func() {
-		i.env = prevEnv
-	}()
 	i.env = newEnv
-
 	for _, stmt := range stmts {
-		i.execute(stmt)
+		if c := i.execute(stmt); c != nil {
+			i.env = prevEnv
+			return c
+		}
 	}
+	i.env = prevEnv
+	return nil
 }
 
 func (i *Interpreter) VisitAssign(expr Expr) interface{} {
 	assignExpr := expr.(Assign)
 	value := i.evaluate(assignExpr.Value)
-	distance, found := i.localDistance[assignExpr]
-	if found {
-		i.env.assignAt(distance, assignExpr.Name, value)
+	if b := assignExpr.Binding; b != nil && b.Local {
+		i.env.assignAt(b.Depth, b.Slot, value)
 	} else {
 		i.globals.assign(assignExpr.Name, value)
 	}
@@ -112,58 +390,64 @@ func (i *Interpreter) VisitBinary(expr Expr) interface{} {
 	b := expr.(Binary)
 	left := i.evaluate(b.Left)
 	right := i.evaluate(b.Right)
-	switch b.Operator.Type {
-	case MINUS:
-		i.checkNumberOperands(b.Operator, left, right)
-		return left.(float64) - right.(float64)
-	case SLASH:
-		i.checkNumberOperands(b.Operator, left, right)
-		return left.(float64) / right.(float64)
-	case STAR:
-		i.checkNumberOperands(b.Operator, left, right)
-		return left.(float64) * right.(float64)
+	return i.binaryOp(b.Operator, left, right)
+}
+
+// binaryOp applies a binary operator to its evaluated operands. It's
+// shared with the VM, so that both backends raise the same errors.
+func (i *Interpreter) binaryOp(op Token, left, right interface{}) interface{} {
+	switch op.Type {
+	case MINUS, SLASH, STAR:
+		i.checkNumberOperands(op, left, right)
+		return i.arithmetic(op, left, right)
 	case PLUS:
 		switch leftTyped := left.(type) {
-		case float64:
-			i.checkNumberOperands(b.Operator, left, right)
-			return leftTyped + right.(float64)
+		case int64, float64, *big.Int, Decimal:
+			i.checkNumberOperands(op, left, right)
+			return i.arithmetic(op, left, right)
 		case string:
-			i.checkStringOperands(b.Operator, left, right)
+			i.checkStringOperands(op, left, right)
+			i.allocate(op.Line, stringSize+len(leftTyped)+len(right.(string)))
 			return leftTyped + right.(string)
 		default:
 			i.runtimeError(
-				b.Operator.Line,
+				op.Line,
 				fmt.Sprintf("'+' can operate on numbers or strings, found %T", left),
 			)
 		}
-	case GREATER:
-		i.checkNumberOperands(b.Operator, left, right)
-		return left.(float64) > right.(float64)
-	case GREATER_EQUAL:
-		i.checkNumberOperands(b.Operator, left, right)
-		return left.(float64) >= right.(float64)
-	case LESS:
-		i.checkNumberOperands(b.Operator, left, right)
-		return left.(float64) < right.(float64)
-	case LESS_EQUAL:
-		i.checkNumberOperands(b.Operator, left, right)
-		return left.(float64) <= right.(float64)
+	case GREATER, GREATER_EQUAL, LESS, LESS_EQUAL:
+		i.checkNumberOperands(op, left, right)
+		return compareNumbers(op.Type, left, right)
+	case IS:
+		switch typed := right.(type) {
+		case *Interface:
+			return typed.isSatisfiedBy(left)
+		case *Class:
+			return typed.isInstance(left)
+		}
+		i.runtimeError(op.Line, "Right operand of 'is' must be a class or an interface.")
 	case BANG_EQUAL:
-		return !reflect.DeepEqual(left, right)
+		return !isEqual(left, right)
 	case EQUAL_EQUAL:
-		return reflect.DeepEqual(left, right)
+		return isEqual(left, right)
 	}
 
-	panic("VisitBinary hit intended-unreachable code")
+	panic("binaryOp hit intended-unreachable code")
+}
+
+func (i *Interpreter) arithmetic(op Token, left, right interface{}) interface{} {
+	result, err := arithmetic(op.Type, left, right)
+	if err != nil {
+		i.runtimeError(op.Line, err.Error())
+	}
+	return result
 }
 
 func (i *Interpreter) checkNumberOperands(op Token, left, right interface{}) {
-	_, leftOk := left.(float64)
-	_, rightOk := right.(float64)
-	if !leftOk || !rightOk {
+	if !isNumber(left) || !isNumber(right) {
 		i.runtimeError(
 			op.Line,
-			fmt.Sprintf("%q, operands %q and %q must be numbers", op.Lexeme, left, right),
+			fmt.Sprintf("%q, operands %#v and %#v must be numbers", op.Lexeme, left, right),
 		)
 	}
 }
@@ -174,40 +458,129 @@ func (i *Interpreter) checkStringOperands(op Token, left, right interface{}) {
 	if !leftOk || !rightOk {
 		i.runtimeError(
 			op.Line,
-			fmt.Sprintf("%q, operands %q and %q must be strings", op.Lexeme, left, right),
+			fmt.Sprintf("%q, operands %#v and %#v must be strings", op.Lexeme, left, right),
 		)
 	}
 }
 
 func (i *Interpreter) VisitCall(expr Expr) interface{} {
 	callExpr := expr.(Call)
-	callee := i.evaluate(callExpr.Callee)
-	var args []interface{}
+	function, method, args, named := i.prepareCall(callExpr)
+	i.callLine = callExpr.Paren.Line
+	if function == nil {
+		return method.Call(i, args)
+	}
+	return i.call(function, args, named)
+}
+
+// prepareCall evaluates the callee and arguments of a call, and checks
+// that the callee can be called with them. A method called straight
+// from an instance, as in "obj.m()", is returned as method, with a nil
+// function: boxing the bound method as a Callable would allocate it,
+// which is wasted when it's only going to be called.
+func (i *Interpreter) prepareCall(callExpr Call) (function Callable, method Function, args []interface{}, named map[string]interface{}) {
+	var callee interface{}
+	isMethod := false
+	if ge, ok := callExpr.Callee.(Get); ok && ge.Name.Type != PRIVATE_IDENTIFIER {
+		obj := i.evaluate(ge.Object)
+		if method, isMethod = i.instanceMethod(obj, ge); !isMethod {
+			callee = i.getProperty(obj, ge)
+		}
+	} else {
+		callee = i.evaluate(callExpr.Callee)
+	}
 	for _, argExpr := range callExpr.Args {
 		args = append(args, i.evaluate(argExpr))
 	}
+	for _, arg := range callExpr.Named {
+		if named == nil {
+			named = make(map[string]interface{})
+		}
+		if _, dup := named[arg.Name.Lexeme]; dup {
+			i.runtimeError(arg.Name.Line, fmt.Sprintf("Duplicate named argument %q.", arg.Name.Lexeme))
+		}
+		named[arg.Name.Lexeme] = i.evaluate(arg.Value)
+	}
+	if isMethod {
+		if named != nil {
+			i.runtimeError(callExpr.Paren.Line, fmt.Sprintf("%v doesn't accept named arguments.", method))
+		}
+		i.checkArity(method.Arity(), len(args), callExpr.Paren.Line)
+		return nil, method, args, nil
+	}
+	return i.checkCall(callee, args, named, callExpr.Paren.Line), Function{}, args, named
+}
+
+// checkCall checks that callee can be called with the given arguments,
+// and returns it as a Callable.
+func (i *Interpreter) checkCall(callee interface{}, args []interface{}, named map[string]interface{}, line int) Callable {
 	function, ok := callee.(Callable)
 	if !ok {
-		i.runtimeError(callExpr.Paren.Line, "Can only call functions and classes.")
+		i.runtimeError(line, "Can only call functions and classes.")
+	}
+	if _, ok := function.(namedCallable); named != nil && !ok {
+		i.runtimeError(line, fmt.Sprintf("%v doesn't accept named arguments.", function))
 	}
-	if len(args) != function.Arity() {
+	i.checkArity(function.Arity(), len(args), line)
+	return function
+}
+
+func (i *Interpreter) checkArity(arity int, argc int, line int) {
+	if arity != variadic && argc != arity {
 		i.runtimeError(
-			callExpr.Paren.Line,
-			fmt.Sprintf("Expected %d args but got %d.", function.Arity(), len(args)),
+			line,
+			fmt.Sprintf("Expected %d args but got %d.", arity, argc),
 		)
 	}
+}
 
+func (i *Interpreter) call(function Callable, args []interface{}, named map[string]interface{}) interface{} {
+	if named != nil {
+		return function.(namedCallable).CallNamed(i, args, named)
+	}
 	return function.Call(i, args)
 }
 
 func (i *Interpreter) VisitGet(expr Expr) interface{} {
 	ge := expr.(Get)
+	if ge.Name.Type == PRIVATE_IDENTIFIER {
+		instance, owner := i.privateAccess(ge.Object, ge.Name)
+		val, err := instance.getPrivate(owner, ge.Name)
+		if err != nil {
+			i.runtimeError(ge.Name.Line, err.Error())
+		}
+		return val
+	}
 	obj := i.evaluate(ge.Object)
+	if method, ok := i.instanceMethod(obj, ge); ok {
+		return method
+	}
+	return i.getProperty(obj, ge)
+}
+
+// instanceMethod returns the method of obj which ge refers to, bound to
+// obj, if obj is an instance with a method (and no field) of that name.
+// Unlike Instance.Get, it uses the expression's inline cache.
+func (i *Interpreter) instanceMethod(obj interface{}, ge Get) (Function, bool) {
 	instance, ok := obj.(*Instance)
+	if !ok || instance.hasField(ge.Name.Lexeme) {
+		return Function{}, false
+	}
+	method, found := ge.Cache.findMethod(instance.Class, ge.Name.Lexeme)
+	function, ok := method.(Function)
+	if !found || !ok {
+		return Function{}, false
+	}
+	return function.bindMethodToInstance(instance), true
+}
+
+// getProperty gets any other property, which ge refers to, of obj.
+func (i *Interpreter) getProperty(obj interface{}, ge Get) interface{} {
+	getter, ok := obj.(propertyGetter)
 	if !ok {
 		i.runtimeError(ge.Name.Line, "Only class instances have properties.")
 	}
-	val, err := instance.Get(ge.Name)
+	val, err := getter.Get(ge.Name)
 	if err != nil {
 		i.runtimeError(ge.Name.Line, err.Error())
 	}
@@ -246,40 +619,74 @@ func (i *Interpreter) VisitLogical(expr Expr) interface{} {
 
 func (i *Interpreter) VisitSet(expr Expr) interface{} {
 	se := expr.(Set)
+	if se.Name.Type == PRIVATE_IDENTIFIER {
+		instance, owner := i.privateAccess(se.Object, se.Name)
+		value := i.evaluate(se.Value)
+		if instance.setPrivate(owner, se.Name, value) {
+			i.allocate(se.Name.Line, fieldSize)
+		}
+		return value
+	}
 	obj := i.evaluate(se.Object)
 	instance, ok := obj.(*Instance)
 	if !ok {
 		i.runtimeError(se.Name.Line, "Only class instances have fields.")
 	}
 	value := i.evaluate(se.Value)
-	instance.Set(se.Name, value)
+	added, err := instance.Set(se.Name, value)
+	if err != nil {
+		i.runtimeError(se.Name.Line, err.Error())
+	}
+	if added {
+		i.allocate(se.Name.Line, fieldSize)
+	}
 	return value
 }
 
+// privateAccess checks that a private member is being accessed through
+// "this", and returns the instance along with the class declaring the
+// method we're in, whose private members are the only ones visible.
+func (i *Interpreter) privateAccess(obj Expr, name Token) (*Instance, *Class) {
+	this, ok := obj.(This)
+	if !ok {
+		i.runtimeError(name.Line, fmt.Sprintf("Private member %q can only be accessed through 'this'.", name.Lexeme))
+	}
+	instance := i.env.getAt(this.Binding.Depth, thisSlot).(*Instance)
+	owner := i.env.getAt(this.Binding.Depth, ownerSlot).(*Class)
+	return instance, owner
+}
+
 func (i *Interpreter) VisitSuper(expr Expr) interface{} {
 	se := expr.(Super)
-	distance := i.localDistance[se]
-	superclass := i.env.getAt(distance, se.Keyword).(Class)
+	superclass := i.env.getAt(se.Binding.Depth, se.Binding.Slot).(*Class)
 	method, found := superclass.findMethod(se.Method.Lexeme)
 	if !found {
 		i.runtimeError(se.Method.Line, fmt.Sprintf("Undefined property %q.", se.Method.Lexeme))
 	}
-	instance := i.env.getAt(distance-1, Token{Lexeme: "this"}).(*Instance)
-	return method.bindMethodToInstance(instance)
+	// "this" is always in the environment just inside "super"
+	instance := i.env.getAt(se.Binding.Depth-1, thisSlot).(*Instance)
+	return method.bind(instance)
 }
 
 func (i *Interpreter) VisitThis(expr Expr) interface{} {
-	return i.lookupVariable(expr)
+	te := expr.(This)
+	return i.lookupVariable(te.Keyword, te.Binding)
 }
 
 func (i *Interpreter) VisityUnary(expr Expr) interface{} {
 	u := expr.(Unary)
 	right := i.evaluate(u.Right)
+	return i.unaryOp(u.Operator, right)
+}
 
-	switch u.Operator.Type {
+// unaryOp applies a unary operator to its evaluated operand.
+func (i *Interpreter) unaryOp(op Token, right interface{}) interface{} {
+	switch op.Type {
 	case MINUS:
-		rightNum := right.(float64)
-		return -rightNum
+		if !isNumber(right) {
+			i.runtimeError(op.Line, fmt.Sprintf("%q, operand %#v must be a number", op.Lexeme, right))
+		}
+		return negate(right)
 	case BANG:
 		switch val := right.(type) {
 		case nil:
@@ -291,65 +698,78 @@ func (i *Interpreter) VisityUnary(expr Expr) interface{} {
 		}
 	}
 
-	panic("Interpreter hit intended-unreachable code in VisitUnary")
+	panic("Interpreter hit intended-unreachable code in unaryOp")
 }
 
 func (i *Interpreter) VisitVariable(expr Expr) interface{} {
-	return i.lookupVariable(expr)
+	ve := expr.(Variable)
+	return i.lookupVariable(ve.Name, ve.Binding)
 }
 
-func (i *Interpreter) lookupVariable(expr Expr) interface{} {
-	var name Token
-	switch typedExpr := expr.(type) {
-	case Variable:
-		name = typedExpr.Name
-	case This:
-		name = typedExpr.Keyword
-	default:
-		panic("hit intended-unreachable code")
+func (i *Interpreter) lookupVariable(name Token, b *Binding) interface{} {
+	if b != nil && b.Local {
+		return i.env.getAt(b.Depth, b.Slot)
 	}
+	return i.globals.get(name)
+}
 
-	distance, found := i.localDistance[expr]
-	if found {
-		return i.env.getAt(distance, name)
-	} else {
-		return i.globals.get(name)
+// define defines a variable in the current scope: by name at the top
+// level, otherwise in the slot the Resolver assigned it.
+func (i *Interpreter) define(name Token, value interface{}) {
+	if i.env == i.globals {
+		i.globals.define(name.Lexeme, value)
+		return
 	}
+	i.allocate(name.Line, valueSize)
+	i.env.add(value)
 }
 
-func (i *Interpreter) VisitBlockStmt(stmt Stmt) {
+func (i *Interpreter) VisitBlockStmt(stmt Stmt) interface{} {
 	newEnv := &environment{
 		// note that a BlockStmt is only used for non-call
 		// operations like if/while/for, and for these the
 		// enclosing scope *should* be visible
-		enclosing:   i.env,
-		interpreter: i,
+		enclosing: i.env,
 	}
 	blockStmt := stmt.(BlockStmt)
-	i.executeBlock(blockStmt.Statements, newEnv)
+	return i.executeBlock(blockStmt.Statements, newEnv)
 }
 
-func (i *Interpreter) VisitClassStmt(stmt Stmt) {
+func (i *Interpreter) VisitClassStmt(stmt Stmt) interface{} {
 	cs := stmt.(ClassStmt)
 
-	var superclass Class
+	var superclass *Class
 	if cs.Superclass != nil {
 		superclassMaybe := i.evaluate(cs.Superclass)
 		var ok bool
-		superclass, ok = superclassMaybe.(Class)
+		superclass, ok = superclassMaybe.(*Class)
 		if !ok {
-			i.runtimeError(cs.Name.Line, "Superclass must be a class.")
+			return errorCompletion(cs.Name.Line, "Superclass must be a class.")
 		}
 	}
 
-	i.env.define(cs.Name.Lexeme, nil)
-
 	if cs.Superclass != nil {
-		i.env = &environment{enclosing: i.env}
-		i.env.define("super", superclass)
+		i.env = &environment{
+			enclosing: i.env,
+			values:    []interface{}{superclass},
+		}
 	}
 
-	methods := make(map[string]Function)
+	var fields []string
+	for _, field := range cs.Fields {
+		fields = append(fields, field.Lexeme)
+	}
+
+	class := &Class{
+		Name:    cs.Name.Lexeme,
+		Methods: make(map[string]Method),
+		IsData:  cs.IsData,
+		Fields:  fields,
+		Doc:     cs.Doc,
+	}
+	if superclass != nil {
+		class.inherit(superclass)
+	}
 	for _, methodStmt := range cs.Methods {
 		var isInit bool
 		if methodStmt.Name.Lexeme == "init" {
@@ -359,72 +779,190 @@ func (i *Interpreter) VisitClassStmt(stmt Stmt) {
 			Declaration:   methodStmt,
 			Closure:       i.env,
 			isInitializer: isInit,
+			owner:         class,
 		}
-		methods[methodStmt.Name.Lexeme] = method
-	}
-
-	class := Class{
-		Name:       cs.Name.Lexeme,
-		Methods:    methods,
-		Superclass: &superclass,
+		class.addMethod(methodStmt.Name.Lexeme, method)
 	}
 	if cs.Superclass != nil {
 		i.env = i.env.enclosing
 	}
-	i.env.assign(cs.Name, class)
+
+	for _, ifaceExpr := range cs.Interfaces {
+		iface, ok := i.evaluate(ifaceExpr).(*Interface)
+		if !ok {
+			return errorCompletion(ifaceExpr.Name.Line, fmt.Sprintf("%q is not an interface.", ifaceExpr.Name.Lexeme))
+		}
+		if missing := iface.missingMethod(class); missing != "" {
+			return errorCompletion(
+				cs.Name.Line,
+				fmt.Sprintf("Class %s doesn't implement %s: %s.", class.Name, iface.Name, missing),
+			)
+		}
+	}
+
+	// nothing else is declared in the class's scope in the meantime, so
+	// defining it last still puts it in the slot the Resolver expects
+	i.define(cs.Name, class)
+	return nil
 }
 
-func (i *Interpreter) VisitExpressionStmt(stmt Stmt) {
+func (i *Interpreter) VisitExpressionStmt(stmt Stmt) interface{} {
 	i.evaluate(stmt.(ExprStmt).Expression)
+	return nil
 }
 
-func (i *Interpreter) VisitFunctionStmt(stmt Stmt) {
+func (i *Interpreter) VisitFunctionStmt(stmt Stmt) interface{} {
 	funStmt := stmt.(FunctionStmt)
 	fun := Function{
 		Declaration:   funStmt,
 		Closure:       i.env,
 		isInitializer: false,
 	}
-	i.env.define(funStmt.Name.Lexeme, fun)
+	i.define(funStmt.Name, fun)
+	return nil
 }
 
-func (i *Interpreter) VisitIfStmt(stmt Stmt) {
+func (i *Interpreter) VisitIfStmt(stmt Stmt) interface{} {
 	ifStmt := stmt.(IfStmt)
 	if i._isTruthy(i.evaluate(ifStmt.Condition)) {
-		i.execute(ifStmt.Then)
+		return i.execute(ifStmt.Then)
 	} else if ifStmt.Else != nil {
-		i.execute(ifStmt.Else)
+		return i.execute(ifStmt.Else)
+	}
+	return nil
+}
+
+func (i *Interpreter) VisitInterfaceStmt(stmt Stmt) interface{} {
+	is := stmt.(InterfaceStmt)
+	i.define(is.Name, &Interface{
+		Name:    is.Name.Lexeme,
+		Methods: is.Methods,
+	})
+	return nil
+}
+
+func (i *Interpreter) VisitAssertStmt(stmt Stmt) interface{} {
+	as := stmt.(AssertStmt)
+	if i.DisableAssertions || i._isTruthy(i.evaluate(as.Condition)) {
+		return nil
+	}
+	var message interface{}
+	if as.Message != nil {
+		message = i.evaluate(as.Message)
+	}
+	return &completion{
+		kind: completionError,
+		err:  assertionError(as.Keyword.Line, as.Source, as.Message != nil, message).error(),
 	}
 }
 
-func (i *Interpreter) VisitPrintStmt(stmt Stmt) {
-	value := i.evaluate(stmt.(PrintStmt).Expression)
+func assertionError(line int, source string, hasMessage bool, message interface{}) runtimeError {
+	msg := fmt.Sprintf("Assertion failed: %s", source)
+	if hasMessage {
+		msg += fmt.Sprintf(": %v", message)
+	}
+	return runtimeError{line: line, msg: msg}
+}
+
+func (i *Interpreter) VisitPrintStmt(stmt Stmt) interface{} {
+	i.print(i.evaluate(stmt.(PrintStmt).Expression))
+	return nil
+}
+
+func (i *Interpreter) print(value interface{}) {
+	i.tasks.outMu.Lock()
+	defer i.tasks.outMu.Unlock()
 	_, _ = fmt.Fprintln(i.Stdout, value)
 }
 
-func (i *Interpreter) VisitReturnStmt(stmt Stmt) {
+func (i *Interpreter) VisitReturnStmt(stmt Stmt) interface{} {
 	returnStmt := stmt.(ReturnStmt)
+	if call, ok := returnStmt.Value.(Call); ok {
+		return i.tailCall(call)
+	}
 	var value interface{}
 	if returnStmt.Value != nil {
 		value = i.evaluate(returnStmt.Value)
 	}
-	panic(returnable{Value: value})
+	return &completion{kind: completionReturn, value: value}
+}
+
+// tailCall evaluates the call in a "return" statement. Calls to Lox
+// functions are returned as completionTailCall, to be made by the caller.
+func (i *Interpreter) tailCall(callExpr Call) *completion {
+	function, method, args, named := i.prepareCall(callExpr)
+	i.callLine = callExpr.Paren.Line
+	if function == nil {
+		return &completion{kind: completionTailCall, function: method, args: args}
+	}
+	if f, ok := function.(Function); ok && named == nil {
+		return &completion{kind: completionTailCall, function: f, args: args}
+	}
+	return &completion{kind: completionReturn, value: i.call(function, args, named)}
 }
 
-func (i *Interpreter) VisitVarStmt(stmt Stmt) {
+func (i *Interpreter) VisitSelectStmt(stmt Stmt) interface{} {
+	ss := stmt.(SelectStmt)
+	ops := make([]chanOp, len(ss.Cases))
+	for idx, sc := range ss.Cases {
+		ch, ok := i.evaluate(sc.Channel).(*Channel)
+		if !ok {
+			return errorCompletion(sc.Keyword.Line, "Can only select on channels.")
+		}
+		ops[idx] = chanOp{ch: ch, send: sc.Send}
+		if sc.Send {
+			ops[idx].value = i.evaluate(sc.Value)
+		}
+	}
+
+	chosen, value, err := i.tasks.perform(ops, ss.Default == nil)
+	if err != nil {
+		return errorCompletion(ss.Keyword.Line, err.Error())
+	}
+
+	newEnv := &environment{enclosing: i.env}
+	if chosen < 0 {
+		return i.executeBlock(ss.Default, newEnv)
+	}
+	sc := ss.Cases[chosen]
+	if sc.Name != nil {
+		newEnv.add(value)
+	}
+	return i.executeBlock(sc.Body, newEnv)
+}
+
+func (i *Interpreter) VisitSpawnStmt(stmt Stmt) interface{} {
+	ss := stmt.(SpawnStmt)
+	function, method, args, named := i.prepareCall(ss.Call)
+	if function == nil {
+		function = method
+	}
+	task := i.fork()
+	i.tasks.spawn(func() {
+		task.callLine = ss.Call.Paren.Line
+		task.call(function, args, named)
+	})
+	return nil
+}
+
+func (i *Interpreter) VisitVarStmt(stmt Stmt) interface{} {
 	var value interface{}
 	vs := stmt.(VariableStmt)
 	if vs.Initializer != nil {
 		value = i.evaluate(vs.Initializer)
 	}
-	i.env.define(vs.Name.Lexeme, value)
+	i.define(vs.Name, value)
+	return nil
 }
 
-func (i *Interpreter) VisitWhileStmt(stmt Stmt) {
+func (i *Interpreter) VisitWhileStmt(stmt Stmt) interface{} {
 	whileStmt := stmt.(WhileStmt)
 	for i._isTruthy(i.evaluate(whileStmt.Condition)) {
-		i.execute(whileStmt.Body)
+		if c := i.execute(whileStmt.Body); c != nil {
+			return c
+		}
 	}
+	return nil
 }
 
 func (i *Interpreter) _isTruthy(obj interface{}) bool {
@@ -2,10 +2,52 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
+// testBackends runs resolved statements with each of the execution
+// backends, which should behave identically.
+var testBackends = []struct {
+	name             string
+	interpret        func(i *Interpreter, stmts []Stmt) error
+	interpretContext func(i *Interpreter, ctx context.Context, stmts []Stmt) error
+}{
+	{
+		"tree",
+		(*Interpreter).Interpret,
+		func(i *Interpreter, ctx context.Context, stmts []Stmt) error { return i.InterpretContext(ctx, stmts) },
+	},
+	{
+		"vm",
+		func(i *Interpreter, stmts []Stmt) error { return NewVM(i).Interpret(stmts) },
+		func(i *Interpreter, ctx context.Context, stmts []Stmt) error {
+			return NewVM(i).InterpretContext(ctx, stmts)
+		},
+	},
+}
+
+// parseProgram scans, parses and resolves src, failing the test if
+// it isn't a valid program.
+func parseProgram(t *testing.T, src string) []Stmt {
+	t.Helper()
+	tokens, scanErr := (&Scanner{}).ScanTokens(src)
+	if scanErr != nil {
+		t.Fatalf("scanning error in test input: %s", scanErr)
+	}
+	stmts, parseErr := (&Parser{Tokens: tokens}).Parse()
+	if parseErr != nil {
+		t.Fatalf("parsing error in test input: %s", parseErr)
+	}
+	if resolveErr := (&Resolver{}).Resolve(stmts); resolveErr != nil {
+		t.Fatalf("resolution error in test input: %s", resolveErr)
+	}
+	return stmts
+}
+
 func TestInterpreter_Interpret_stmts(t *testing.T) {
 	stmtTestCases := map[string]struct {
 		in          []Stmt
@@ -113,6 +155,62 @@ func TestInterpreter_Interpret_stmts(t *testing.T) {
 			}}},
 			expected: "false\n",
 		},
+		"integer addition stays integral": {
+			in: []Stmt{PrintStmt{Binary{
+				Operator: Token{Type: PLUS},
+				Left:     Literal{Value: int64(9007199254740992)},
+				Right:    Literal{Value: int64(1)},
+			}}},
+			expected: "9007199254740993\n",
+		},
+		"integer overflow promotes to float": {
+			in: []Stmt{PrintStmt{Binary{
+				Operator: Token{Type: STAR},
+				Left:     Literal{Value: int64(9223372036854775807)},
+				Right:    Literal{Value: int64(2)},
+			}}},
+			expected: "1.8446744073709552e+19\n",
+		},
+		"mixed integer and float arithmetic promotes to float": {
+			in: []Stmt{PrintStmt{Binary{
+				Operator: Token{Type: PLUS},
+				Left:     Literal{Value: int64(1)},
+				Right:    Literal{Value: 0.5},
+			}}},
+			expected: "1.5\n",
+		},
+		"inexact integer division promotes to float": {
+			in: []Stmt{PrintStmt{Binary{
+				Operator: Token{Type: SLASH},
+				Left:     Literal{Value: int64(7)},
+				Right:    Literal{Value: int64(2)},
+			}}},
+			expected: "3.5\n",
+		},
+		"equality: integer and float": {
+			in: []Stmt{PrintStmt{Binary{
+				Operator: Token{Type: EQUAL_EQUAL},
+				Left:     Literal{Value: int64(1)},
+				Right:    Literal{Value: 1.0},
+			}}},
+			expected: "true\n",
+		},
+		"comparison: integer and float": {
+			in: []Stmt{PrintStmt{Binary{
+				Operator: Token{Type: LESS},
+				Left:     Literal{Value: int64(1)},
+				Right:    Literal{Value: 1.5},
+			}}},
+			expected: "true\n",
+		},
+		"err: unary minus on string": {
+			in: []Stmt{PrintStmt{Unary{
+				Operator: Token{Type: MINUS},
+				Right:    Literal{Value: "one"},
+			}}},
+			errExpected: true,
+			expectedErr: "must be a number",
+		},
 		"var assignment and reassignment": {
 			in: []Stmt{
 				VariableStmt{
@@ -141,22 +239,250 @@ func TestInterpreter_Interpret_stmts(t *testing.T) {
 	}
 
 	for name, tc := range stmtTestCases {
-		t.Run(name, func(t *testing.T) {
+		for _, backend := range testBackends {
+			t.Run(name+"/"+backend.name, func(t *testing.T) {
+				out := &bytes.Buffer{}
+				i := &Interpreter{Stdout: out}
+				err := backend.interpret(i, tc.in)
+				actual := out.String()
+				if tc.errExpected && err == nil {
+					t.Error("err expected, didn't get one")
+				} else if !tc.errExpected && err != nil {
+					t.Errorf("unexpected error: %s", err)
+				} else if err != nil && !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Errorf("expected error containing %q, got %q", tc.expectedErr, err)
+				} else if actual != tc.expected {
+					t.Errorf("expected %q, got %q", tc.expected, actual)
+				}
+			})
+		}
+	}
+}
+
+func TestInterpreter_Interpret_disableAssertions(t *testing.T) {
+	stmts := parseProgram(t, `assert false, "unreachable"; print "ok";`)
+	for _, backend := range testBackends {
+		out := &bytes.Buffer{}
+		i := &Interpreter{Stdout: out, DisableAssertions: true}
+		if err := backend.interpret(i, stmts); err != nil {
+			t.Errorf("%s: unexpected error: %s", backend.name, err)
+		}
+		if out.String() != "ok\n" {
+			t.Errorf("%s: expected %q, got %q", backend.name, "ok\n", out.String())
+		}
+	}
+}
+
+func TestInterpreter_Interpret_internalError(t *testing.T) {
+	// an operator the parser never produces
+	stmts := []Stmt{PrintStmt{Binary{
+		Operator: Token{Type: COMMA},
+		Left:     Literal{Value: 1.0},
+		Right:    Literal{Value: 1.0},
+	}}}
+	err := (&Interpreter{Stdout: &bytes.Buffer{}}).Interpret(stmts)
+	if err == nil {
+		t.Fatal("expected error, didn't get one")
+	}
+	if !strings.Contains(err.Error(), "internal error: binaryOp hit intended-unreachable code") {
+		t.Errorf("expected an internal error, got %q", err)
+	}
+	// it's passed on by execute, but still shows where it happened
+	if !strings.Contains(err.Error(), "(*Interpreter).binaryOp") {
+		t.Errorf("expected the stack where it happened, got %q", err)
+	}
+}
+
+func TestInterpreter_Interpret_stackOverflow(t *testing.T) {
+	scripts := []string{
+		"fun down(n) {\n  return 1 + down(n + 1);\n}\nprint down(0);",
+		"fun sum(n) { if (n == 0) return 0; return n + sum(n - 1); }\nprint sum(50);",
+	}
+	var programs [][]Stmt
+	for _, src := range scripts {
+		programs = append(programs, parseProgram(t, src))
+	}
+
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
 			out := &bytes.Buffer{}
-			i := &Interpreter{Stdout: out}
-			err := i.Interpret(tc.in)
-			actual := out.String()
-			if tc.errExpected && err == nil {
-				t.Error("err expected, didn't get one")
-			} else if !tc.errExpected && err != nil {
-				t.Errorf("unexpected error: %s", err)
-			} else if err != nil && !strings.Contains(err.Error(), tc.expectedErr) {
-				t.Errorf("expected error containing %q, got %q", tc.expectedErr, err)
-			} else if actual != tc.expected {
-				t.Errorf("expected %q, got %q", tc.expected, actual)
+			i := &Interpreter{Stdout: out, MaxCallDepth: 100}
+			err := backend.interpret(i, programs[0])
+			if err == nil {
+				t.Fatal("expected error, didn't get one")
+			}
+			expectedErr := `line 2: Stack overflow. Calling "down" exceeded the limit of 100 nested calls.`
+			if !strings.Contains(err.Error(), expectedErr) {
+				t.Errorf("expected error containing %q, got %q", expectedErr, err)
+			}
+
+			// the interpreter can still make calls up to the limit
+			if err := backend.interpret(i, programs[1]); err != nil {
+				t.Fatalf("unexpected error after stack overflow: %s", err)
+			}
+			if out.String() != "1275\n" {
+				t.Errorf("expected output %q, got %q", "1275\n", out.String())
+			}
+		})
+	}
+}
+
+func TestInterpreter_Interpret_methodCallAllocs(t *testing.T) {
+	i := &Interpreter{Stdout: &bytes.Buffer{}}
+	if err := i.Interpret(parseProgram(t, "class C { m(a) { return a; } } var o = C(); fun f(a) { return a; }")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	allocs := func(src string) float64 {
+		stmts := parseProgram(t, src)
+		return testing.AllocsPerRun(100, func() {
+			if err := i.Interpret(stmts); err != nil {
+				t.Fatalf("unexpected error: %s", err)
 			}
 		})
 	}
+
+	// calling a method shouldn't allocate anything more than calling a
+	// function does, such as the method bound to o
+	function, method := allocs("f(nil);"), allocs("o.m(nil);")
+	if method > function {
+		t.Errorf("expected o.m() to allocate no more than f(), got %v allocations against %v", method, function)
+	}
+}
+
+func TestInterpreter_InterpretContext_limits(t *testing.T) {
+	testCases := map[string]struct {
+		in      string
+		budget  int64
+		timeout time.Duration
+		err     error
+	}{
+		"infinite loop is cancelled": {
+			in:      "var i = 0; while (true) i = i + 1;",
+			timeout: 50 * time.Millisecond,
+			err:     ErrCancelled,
+		},
+		"infinite loop in a spawned task is cancelled": {
+			in:      "fun spin() { while (true) {} } spawn spin();",
+			timeout: 50 * time.Millisecond,
+			err:     ErrCancelled,
+		},
+		"task waiting on a channel is cancelled": {
+			in: `
+fun spin() { while (true) {} }
+var ch = channel(0);
+spawn spin();
+ch.receive();
+`,
+			timeout: 50 * time.Millisecond,
+			err:     ErrCancelled,
+		},
+		"task waiting in select is cancelled": {
+			in: `
+fun spin() { while (true) {} }
+var ch = channel(0);
+spawn spin();
+select {
+  case var msg = ch.receive() { print msg; }
+}
+`,
+			timeout: 50 * time.Millisecond,
+			err:     ErrCancelled,
+		},
+		"infinite loop exhausts the budget": {
+			in:     "for (var i = 0; true; i = i + 1) {}",
+			budget: 1000,
+			err:    ErrBudgetExhausted,
+		},
+		"spawned tasks share the budget": {
+			in: `
+fun spin() { while (true) {} }
+for (var i = 0; i < 10; i = i + 1) spawn spin();
+`,
+			budget: 1000,
+			err:    ErrBudgetExhausted,
+		},
+		"program within its budget": {
+			in:     "var total = 0; for (var i = 0; i < 10; i = i + 1) total = total + i; print total;",
+			budget: 1000,
+		},
+	}
+
+	for name, tc := range testCases {
+		for _, backend := range testBackends {
+			t.Run(name+"/"+backend.name, func(t *testing.T) {
+				stmts := parseProgram(t, tc.in)
+				ctx := context.Background()
+				if tc.timeout > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+					defer cancel()
+				}
+				out := &bytes.Buffer{}
+				i := &Interpreter{Stdout: out, StatementBudget: tc.budget}
+				err := backend.interpretContext(i, ctx, stmts)
+				if !errors.Is(err, tc.err) {
+					t.Errorf("expected error %v, got %v", tc.err, err)
+				}
+
+				// the interpreter is left usable, with a fresh budget
+				out.Reset()
+				if err := backend.interpret(i, []Stmt{PrintStmt{Literal{Value: "again"}}}); err != nil {
+					t.Fatalf("unexpected error on reuse: %s", err)
+				}
+				if out.String() != "again\n" {
+					t.Errorf("expected output %q on reuse, got %q", "again\n", out.String())
+				}
+			})
+		}
+	}
+}
+
+func TestInterpreter_Interpret_memoryQuota(t *testing.T) {
+	testCases := map[string]struct {
+		in          string
+		expectedErr string
+	}{
+		"string concatenation": {
+			in:          "var s = \"\";\nwhile (true) s = s + \"abcdefgh\";",
+			expectedErr: "runtime error on line 2: Memory quota of 100000 bytes exceeded.",
+		},
+		"instances and fields": {
+			in: `
+class Node { init(next) { this.next = next; } }
+var list = nil;
+while (true) list = Node(list);
+`,
+			expectedErr: "Memory quota of 100000 bytes exceeded.",
+		},
+		"set members": {
+			in:          "var s = set(); var n = 0; while (true) { s.add(n); n = n + 1; }",
+			expectedErr: "Memory quota of 100000 bytes exceeded.",
+		},
+		"string builders": {
+			in:          "var sb = stringBuilder(); while (true) sb.append(\"abcdefgh\");",
+			expectedErr: "Memory quota of 100000 bytes exceeded.",
+		},
+		"within the quota": {
+			in: "var s = \"\"; for (var i = 0; i < 10; i = i + 1) s = s + \"x\"; print s;",
+		},
+	}
+
+	for name, tc := range testCases {
+		for _, backend := range testBackends {
+			t.Run(name+"/"+backend.name, func(t *testing.T) {
+				i := &Interpreter{Stdout: &bytes.Buffer{}, MemoryQuota: 100000}
+				err := backend.interpret(i, parseProgram(t, tc.in))
+				if tc.expectedErr == "" && err != nil {
+					t.Errorf("unexpected error: %s", err)
+				} else if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+					t.Errorf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				if used := i.MemoryUsed(); used <= 0 {
+					t.Errorf("expected memory to be used, got %d", used)
+				}
+			})
+		}
+	}
 }
 
 func TestInterpreter_Interpret_script(t *testing.T) {
@@ -317,36 +643,757 @@ print k.str();
 `,
 			expected: "bread, donut\n",
 		},
+		"integer literals and loop counters": {
+			in: `
+var big = 9007199254740993;
+print big;
+print big + 1;
+print 6 / 3;
+print 1.0 * 3;
+`,
+			expected: "9007199254740993\n9007199254740994\n2\n3\n",
+		},
+		"bigint arithmetic is exact": {
+			in: `
+print 123456789012345678901234567890n * 2;
+print 9223372036854775807n + 1;
+print 6n / 2n;
+print 7n / 2n;
+print 1n == 1;
+`,
+			expected: "246913578024691357802469135780\n9223372036854775808\n3\n3.5\ntrue\n",
+		},
+		"decimal arithmetic is exact": {
+			in: `
+print 0.1d + 0.2d == 0.3d;
+print 1.10d + 2;
+print 10.00d / 4;
+print 1d / 3d;
+print 1.5d > 1.25d;
+`,
+			expected: "true\n3.10\n2.50\n0.3333333333333333\ntrue\n",
+		},
+		"explicit numeric conversions": {
+			in: `
+print decimal(0.1) == 0.1d;
+print number(5n) + 1;
+print number(1.25d) * 2;
+print bigint(3.9);
+print bigint("99999999999999999999999") + 1;
+`,
+			expected: "true\n6\n2.5\n3\n100000000000000000000000\n",
+		},
+		"err: mixing floats and bignums": {
+			in:          "print 1n + 1.0;",
+			errExpected: true,
+			expectedErr: "Can't mix floats with bigints or decimals",
+		},
+		"err: bignum division by zero": {
+			in:          "print 1.5d / 0;",
+			errExpected: true,
+			expectedErr: "Division by zero",
+		},
+		"data class generates init, equality and printing": {
+			in: `
+data class Point(x, y) {
+  sum() { return this.x + this.y; }
+}
+var p = Point(1, 2);
+print p;
+print p.sum();
+print p == Point(1, 2);
+print p == Point(2, 1);
+print p.toString();
+`,
+			expected: "Point(x=1, y=2)\n3\ntrue\nfalse\nPoint(x=1, y=2)\n",
+		},
+		"data class copy with named arguments": {
+			in: `
+data class Point(x, y) {}
+var p = Point(1, 2);
+var q = p.copy(y: 5);
+print q;
+print p;
+`,
+			expected: "Point(x=1, y=5)\nPoint(x=1, y=2)\n",
+		},
+		"data is still an identifier": {
+			in:       "var data = 3; print data;",
+			expected: "3\n",
+		},
+		"err: data class fields can't change": {
+			in: `
+data class P(x, y) {}
+var p = P(1, 2);
+var s = set(p);
+p.extra = 3;
+print p.extra;
+p.x = 5;
+`,
+			errExpected: true,
+			expectedErr: "runtime error on line 7: Can't change field \"x\" of a P; copy() it instead.",
+			expected:    "3\n",
+		},
+		"err: data class fields can't be deleted": {
+			in:          "data class P(x) {} deleteField(P(1), \"x\");",
+			errExpected: true,
+			expectedErr: "Can't change field \"x\" of a P",
+		},
+		"err: data class copy of unknown field": {
+			in:          "data class Point(x, y) {} Point(1, 2).copy(z: 1);",
+			errExpected: true,
+			expectedErr: "Point has no field",
+		},
+		"err: named arguments to a regular function": {
+			in:          "fun f(a) { return a; } f(a: 1);",
+			errExpected: true,
+			expectedErr: "doesn't accept named arguments",
+		},
+		"private members are accessible through this": {
+			in: `
+class Account {
+  init(balance) { this.#balance = balance; }
+  deposit(n) { this.#balance = this.#balance + n; return this.#describe(); }
+  #describe() { return "balance is " + "updated"; }
+  balance() { return this.#balance; }
+}
+var a = Account(10);
+print a.deposit(5);
+print a.balance();
+`,
+			expected: "balance is updated\n15\n",
+		},
+		"err: private field read from outside the class": {
+			in:          "class A { init() { this.#x = 1; } } var a = A(); print a.#x;",
+			errExpected: true,
+			expectedErr: "can only be accessed through 'this'",
+		},
+		"err: private field written from outside the class": {
+			in:          "class A {} var a = A(); a.#x = 1;",
+			errExpected: true,
+			expectedErr: "can only be accessed through 'this'",
+		},
+		"err: private fields aren't visible to subclasses": {
+			in: `
+class A { init() { this.#x = 1; } }
+class B < A { peek() { return this.#x; } }
+print B().peek();
+`,
+			errExpected: true,
+			expectedErr: "Undefined property \"#x\"",
+		},
+		"class implementing an interface, including inherited methods": {
+			in: `
+interface Shape { area(); scale(factor); }
+class Base { area() { return 0; } }
+class Point < Base implements Shape {
+  scale(factor) { return factor; }
+}
+print Point() is Shape;
+`,
+			expected: "true\n",
+		},
+		"is checks interfaces structurally": {
+			in: `
+interface Named { name(); }
+class Dog { name() { return "dog"; } }
+class Rock {}
+print Dog() is Named;
+print Rock() is Named;
+print "dog" is Named;
+`,
+			expected: "true\nfalse\nfalse\n",
+		},
+		"local interface implemented by a subclass": {
+			in: `
+fun outer() {
+  interface Named { name(); }
+  class Base { name() { return "base"; } }
+  class Derived < Base implements Named {
+    name() { return "derived " + super.name(); }
+  }
+  print Derived().name();
+  print Derived() is Named;
+}
+outer();
+`,
+			expected: "derived base\ntrue\n",
+		},
+		"is checks class membership through superclasses": {
+			in: `
+class Animal {}
+class Dog < Animal {}
+print Dog() is Animal;
+print Animal() is Dog;
+print nil is Dog;
+`,
+			expected: "true\nfalse\nfalse\n",
+		},
+		"err: is with something other than a class or interface": {
+			in:          "print 1 is 2;",
+			errExpected: true,
+			expectedErr: "Right operand of 'is' must be a class or an interface",
+		},
+		"typeOf names every kind of value": {
+			in: `
+class A {}
+interface I {}
+fun f() {}
+print typeOf(nil) + " " + typeOf(true) + " " + typeOf(1) + " " + typeOf(1.5);
+print typeOf("s") + " " + typeOf(f) + " " + typeOf(clock) + " " + typeOf(A);
+print typeOf(A()) + " " + typeOf(I) + " " + typeOf(channel(0)) + " " + typeOf(fields(A()));
+`,
+			expected: "nil bool number number\nstring function function class\ninstance interface channel list\n",
+		},
+		"reflection over fields and methods": {
+			in: `
+class Base { describe() { return "base"; } }
+class Point < Base {
+  init(x, y) { this.x = x; this.y = y; this.#secret = 1; }
+  norm() { return this.x + this.y + this.#secret; }
+}
+var p = Point(1, 2);
+print classOf(p);
+print superclassOf(Point);
+print superclassOf(Base);
+print fields(p);
+print methods(Point);
+var names = fields(p);
+for (var i = 0; i < names.length(); i = i + 1) {
+  print getField(p, names.get(i));
+}
+print hasField(p, "x");
+setField(p, "z", 3);
+print p.z;
+print deleteField(p, "x");
+print hasField(p, "x");
+print deleteField(p, "x");
+print p.norm;
+`,
+			expected: "Point\nBase\n<nil>\n[x, y]\n[describe, init, norm]\n1\n2\ntrue\n3\ntrue\nfalse\nfalse\n<fn norm>\n",
+		},
+		"err: getField on a missing field": {
+			in:          "class A {} getField(A(), \"x\");",
+			errExpected: true,
+			expectedErr: "Undefined field \"x\"",
+		},
+		"err: reflection can't reach private members": {
+			in:          "class A {} setField(A(), \"#x\", 1);",
+			errExpected: true,
+			expectedErr: "Private members can't be accessed by name",
+		},
+		"err: fields of a non-instance": {
+			in:          "print fields(1);",
+			errExpected: true,
+			expectedErr: "fields() expects an instance, got number",
+		},
+		"passing assertions do nothing": {
+			in:       "var x = 2; assert x > 1; assert x == 2, \"x is two\"; print x;",
+			expected: "2\n",
+		},
+		"err: failed assertion reports its source": {
+			in:          "var items = 1;\nassert items >= 2 and !(items == -1);",
+			errExpected: true,
+			expectedErr: "runtime error on line 2: Assertion failed: items >= 2 and !(items == -1)",
+		},
+		"err: failed assertion includes its message": {
+			in:          "fun size(n) { return n; }\nassert size(0) > 0, \"size is \" + \"zero\";",
+			errExpected: true,
+			expectedErr: "Assertion failed: size(0) > 0: size is zero",
+		},
+		"help shows signatures and doc comments": {
+			in: `
+/// Adds two numbers.
+/// Both must be numbers.
+fun add(a: number, b): number { return a + b; }
+fun undocumented() {}
+class Base {}
+/// A point in the plane.
+class Point < Base {
+  /// Makes a point.
+  init(x, y) { this.x = x; this.y = y; }
+  norm() { return this.x + /// ignored
+    this.y; }
+}
+help(add);
+help(undocumented);
+help(Point);
+`,
+			expected: "fun add(a: number, b): number\nAdds two numbers.\nBoth must be numbers.\n" +
+				"fun undocumented()\n" +
+				"class Point < Base\nA point in the plane.\n  init(x, y)\n    Makes a point.\n  norm()\n",
+		},
+		"err: help on a value without documentation": {
+			in:          "help(1);",
+			errExpected: true,
+			expectedErr: "help() expects a function or class, got number",
+		},
+		"set membership and printing": {
+			in: `
+var s = set(3, 1, 3, "a");
+print s;
+print s.size();
+print s.add(1.0);
+print s.add(2);
+print s.contains(3.0) and s.contains(3n) and s.contains(3.00d);
+print s.remove("a");
+print s.remove("a");
+print s;
+print set();
+`,
+			expected: "{3, 1, a}\n3\nfalse\ntrue\ntrue\ntrue\nfalse\n{3, 1, 2}\n{}\n",
+		},
+		"set members use value equality for data classes only": {
+			in: `
+data class Point(x, y) {}
+class Box {}
+var b = Box();
+var s = set(Point(1, 2), Point(1, 2.0), b, Box());
+print s.size();
+print s.contains(Point(1, 2)) and s.contains(b);
+print s.contains(Box());
+print s.contains(0.5) == s.contains(0.5d);
+`,
+			expected: "3\ntrue\nfalse\ntrue\n",
+		},
+		"== matches set membership": {
+			in: `
+data class P(v) {}
+class Box {}
+var b1 = Box();
+var b2 = Box();
+b1.x = 1;
+b2.x = 1;
+print b1 == b2;
+print b1 == b1;
+print set(b1).contains(b2);
+print P(b1) == P(b2);
+var s = set(P(b1));
+print s.add(P(b2));
+print s.size();
+print s.contains(P(b1));
+fun f() {}
+print f == f;
+print set(f).contains(f);
+print P(1) == P(1.0) and set(P(1)).contains(P(1.0));
+print P("1") == P(1) or set(P("1")).contains(P(1));
+`,
+			expected: "false\ntrue\nfalse\nfalse\ntrue\n2\ntrue\ntrue\ntrue\ntrue\nfalse\n",
+		},
+		"NaN is never a member": {
+			in: `
+var n = 0.0 / 0;
+var s = set(n, n);
+print n == n;
+print s.size();
+print s.contains(n);
+print s.remove(n);
+print set(1 / 0).contains(1 / 0);
+`,
+			expected: "false\n2\nfalse\nfalse\ntrue\n",
+		},
+		"methods are equal when bound to the same instance": {
+			in: `
+class C { m() {} }
+var a = C();
+var b = C();
+print a.m == a.m;
+print a.m == b.m;
+print set(a.m).contains(a.m);
+`,
+			expected: "true\nfalse\ntrue\n",
+		},
+		"err: sets can't be set members": {
+			in:          "set(set(1));",
+			errExpected: true,
+			expectedErr: "Can't use a set as a set member.",
+		},
+		"set algebra": {
+			in: `
+var a = set(1, 2, 3);
+var b = set(3, 4);
+print a.union(b);
+print a.intersection(b);
+print a.difference(b);
+print set(1, 2).isSubsetOf(a);
+print b.isSubsetOf(a);
+print set(1, 2) == set(2, 1);
+print typeOf(a);
+`,
+			expected: "{1, 2, 3, 4}\n{3}\n{1, 2}\ntrue\nfalse\ntrue\nset\n",
+		},
+		"set iteration": {
+			in: `
+var s = set("x", "y");
+fun show(v) { print v; }
+s.forEach(show);
+var values = s.values();
+for (var i = 0; i < values.length(); i = i + 1) {
+  s.add(values.get(i) + "!");
+}
+print s;
+`,
+			expected: "x\ny\n{x, y, x!, y!}\n",
+		},
+		"string builder": {
+			in: `
+var sb = stringBuilder();
+for (var i = 0; i < 3; i = i + 1) sb.append(i).append(",");
+sb.append(nil).append(true).append("é");
+print sb.toString();
+print sb.length();
+print sb;
+print typeOf(sb);
+`,
+			expected: "0,1,2,<nil>trueé\n16\n0,1,2,<nil>trueé\nstringBuilder\n",
+		},
+		"string builder shared between tasks": {
+			in: `
+fun write(sb, done) {
+  for (var i = 0; i < 100; i = i + 1) sb.append("x");
+  done.send(true);
+}
+var sb = stringBuilder();
+var done = channel(0);
+spawn write(sb, done);
+spawn write(sb, done);
+done.receive();
+done.receive();
+print sb.length();
+`,
+			expected: "200\n",
+		},
+		"err: unknown string builder method": {
+			in:          "stringBuilder().add(1);",
+			errExpected: true,
+			expectedErr: "Undefined property \"add\".",
+		},
+		"err: unhashable set member": {
+			in:          "set(clock).add(set().add);",
+			errExpected: true,
+			expectedErr: "Can't use <native fn add> as a set member",
+		},
+		"err: class missing an interface method": {
+			in:          "interface Shape { area(); } class Blob implements Shape {}",
+			errExpected: true,
+			expectedErr: "Class Blob doesn't implement Shape: missing method area()",
+		},
+		"err: interface method with the wrong arity": {
+			in:          "interface Shape { area(); } class Blob implements Shape { area(units) { return units; } }",
+			errExpected: true,
+			expectedErr: "method area() takes 1 parameters, but 0 are required",
+		},
+		"err: implementing something other than an interface": {
+			in:          "class A {} class B implements A {}",
+			errExpected: true,
+			expectedErr: "\"A\" is not an interface",
+		},
+		"spawned task sends on unbuffered channel": {
+			in: `
+fun produce(ch, n) {
+  for (var i = 0; i < n; i = i + 1) {
+    ch.send(i);
+  }
+  ch.close();
+}
+var ch = channel(0);
+spawn produce(ch, 3);
+print ch.receive();
+print ch.receive();
+print ch.receive();
+print ch.receive();
+`,
+			expected: "0\n1\n2\n<nil>\n",
+		},
+		// run with -race: the spawned task updates n while main grows its stack
+		"spawned closure shares a captured local": {
+			in: `
+fun deep(n) { if (n > 0) deep(n - 1); }
+fun main() {
+  var n = 0;
+  var done = channel(0);
+  fun bump() {
+    for (var i = 0; i < 100; i = i + 1) n = n + 1;
+    done.send(true);
+  }
+  spawn bump();
+  deep(200);
+  done.receive();
+  n = n + 1;
+  print n;
+}
+main();
+`,
+			expected: "101\n",
+		},
+		"local function calls itself": {
+			in: `
+{
+  fun countdown(n) {
+    if (n > 0) countdown(n - 1); else print "done";
+  }
+  countdown(3);
+}
+`,
+			expected: "done\n",
+		},
+		"buffered channel doesn't block until full": {
+			in: `
+var ch = channel(2);
+ch.send("a");
+ch.send("b");
+print ch.receive();
+print ch.receive();
+`,
+			expected: "a\nb\n",
+		},
+		"tasks have separate environments": {
+			in: `
+fun count(name, ch) {
+  var total = 0;
+  for (var i = 0; i < 100; i = i + 1) {
+    total = total + 1;
+  }
+  ch.send(name + " done");
+}
+var ch = channel(0);
+spawn count("a", ch);
+spawn count("b", ch);
+var first = ch.receive();
+var second = ch.receive();
+print first != second;
+`,
+			expected: "true\n",
+		},
+		"select chooses the ready case": {
+			in: `
+var idle = channel(0);
+var ready = channel(1);
+ready.send("hello");
+select {
+  case var msg = idle.receive() { print "idle " + msg; }
+  case var msg = ready.receive() { print "ready " + msg; }
+}
+`,
+			expected: "ready hello\n",
+		},
+		"select falls through to default": {
+			in: `
+var ch = channel(0);
+select {
+  case ch.send(1) { print "sent"; }
+  default { print "would block"; }
+}
+`,
+			expected: "would block\n",
+		},
+		"select waits for a spawned sender": {
+			in: `
+fun later(ch) { ch.send("late"); }
+var ch = channel(0);
+spawn later(ch);
+select {
+  case var msg = ch.receive() { print msg; }
+}
+`,
+			expected: "late\n",
+		},
+		"locals in nested scopes get their own slots": {
+			in: `
+fun outer() {
+  var a = "a";
+  {
+    var b = "b";
+    interface Named { name(); }
+    class Base { name() { return a; } }
+    class Derived < Base implements Named {
+      name() { return super.name() + b; }
+    }
+    print Derived().name();
+  }
+  var c = "c";
+  print a + c;
+}
+outer();
+`,
+			expected: "ab\nac\n",
+		},
+		"return at the top level ends the script": {
+			in:       "print 1; return; print 2;",
+			expected: "1\n",
+		},
+		"return from nested loops and blocks": {
+			in: `
+fun find(product) {
+  for (var i = 0; i < 10; i = i + 1) {
+    {
+      var j = 0;
+      while (j < 10) {
+        if (i * j == product) return i + j;
+        j = j + 1;
+      }
+    }
+  }
+  return nil;
+}
+print find(12);
+print find(1000);
+`,
+			expected: "8\n<nil>\n",
+		},
+		"err: errors complete out of nested blocks and calls": {
+			in: `
+fun check(n) {
+  while (true) {
+    { assert n < 3, "too big"; }
+    return n;
+  }
+}
+fun show(n) { print check(n); }
+print check(1) + check(2);
+set(1, 5).forEach(show);
+`,
+			expected:    "3\n1\n",
+			errExpected: true,
+			expectedErr: "runtime error on line 4: Assertion failed: n < 3: too big",
+		},
+		"tail recursion runs in constant stack": {
+			in: `
+fun count(n, total) {
+  if (n == 0) return total;
+  return count(n - 1, total + 1);
+}
+print count(1000000, 0);
+`,
+			expected: "1000000\n",
+		},
+		"mutual tail recursion runs in constant stack": {
+			in: `
+fun isEven(n) { if (n == 0) return true; return isOdd(n - 1); }
+fun isOdd(n) { if (n == 0) return false; return isEven(n - 1); }
+print isEven(1000000);
+print isOdd(7);
+`,
+			expected: "true\ntrue\n",
+		},
+		"tail calls to methods and closures": {
+			in: `
+class Countdown {
+  init(label) { this.label = label; }
+  run(n) {
+    if (n == 0) return this.label;
+    return this.run(n - 1);
+  }
+}
+fun make() {
+  var calls = 0;
+  fun step(n) {
+    calls = calls + 1;
+    if (n == 0) return calls;
+    return step(n - 1);
+  }
+  return step;
+}
+print Countdown("liftoff").run(1000000);
+print make()(10);
+print clock() > 0;
+`,
+			expected: "liftoff\n11\ntrue\n",
+		},
+		"call sites which see several classes": {
+			in: `
+class Shape { name() { return "shape"; } describe() { return "a " + this.name(); } }
+class Square < Shape { name() { return "square"; } }
+class Circle < Shape {}
+class Labelled < Square {}
+fun describe(s) { return s.describe(); }
+print describe(Shape());
+print describe(Square());
+print describe(Circle());
+print describe(Labelled());
+print describe(Square());
+`,
+			expected: "a shape\na square\na shape\na square\na square\n",
+		},
+		"fields shadow methods called straight away": {
+			in: `
+class A { m() { return "method"; } }
+fun field() { return "field"; }
+var a = A();
+print a.m();
+a.m = field;
+print a.m();
+`,
+			expected: "method\nfield\n",
+		},
+		"method called with the wrong number of args": {
+			in:          "class A { m(x) { return x; } }\nA().m();",
+			errExpected: true,
+			expectedErr: "runtime error on line 2: Expected 1 args but got 0.",
+		},
+		"unbounded recursion is a stack overflow": {
+			in:          "class Node { depth() { return 1 + this.depth(); } }\nprint Node().depth();",
+			errExpected: true,
+			expectedErr: `runtime error on line 1: Stack overflow. Calling "depth" exceeded the limit of 10000`,
+		},
+		"deadlock is a runtime error": {
+			in:          "var ch = channel(0); ch.receive();",
+			errExpected: true,
+			expectedErr: "Deadlock",
+		},
+		"deadlock in spawned task is reported": {
+			in: `
+fun stuck(ch) { ch.send(1); }
+spawn stuck(channel(0));
+`,
+			errExpected: true,
+			expectedErr: "Deadlock",
+		},
+		"send on closed channel": {
+			in:          "var ch = channel(1); ch.close(); ch.send(1);",
+			errExpected: true,
+			expectedErr: "Send on closed channel",
+		},
+		"runtime error in spawned task is reported": {
+			in: `
+fun bad() { return 1 + "one"; }
+spawn bad();
+`,
+			errExpected: true,
+			expectedErr: "must be numbers",
+		},
 	}
 	for name, tc := range testCases {
-		t.Run(name, func(t *testing.T) {
-			tokens := (&Scanner{}).ScanTokens(tc.in)
-			stmts, parseErr := (&Parser{Tokens: tokens}).Parse()
-			if parseErr != nil {
-				t.Fatalf("parsing error in test input: %s", parseErr)
-			}
-			out := &bytes.Buffer{}
-			interpreter := &Interpreter{Stdout: out}
-			interpreter.init()
-			resolver := &Resolver{interpreter: interpreter}
-			resolveErr := resolver.Resolve(stmts)
-			if resolveErr != nil {
-				t.Fatalf("resolution error in test input: %s", resolveErr)
-			}
-			//for key, val := range interpreter.localDistance {
-			//	fmt.Printf("%q: %d\n", key, val)
-			//}
-			err := interpreter.Interpret(stmts)
-			actual := out.String()
-			if tc.errExpected && err == nil {
-				t.Error("expected error, didn't get one")
-			} else if !tc.errExpected && err != nil {
-				t.Errorf("unexpected error: %s", err)
-			} else if err != nil && !strings.Contains(err.Error(), tc.expectedErr) {
-				t.Errorf("expected error containing %q, got %q", tc.expectedErr, err)
-			} else if actual != tc.expected {
-				t.Errorf("expected %q, got %q", tc.expected, actual)
-			}
-		})
+		for _, backend := range testBackends {
+			t.Run(name+"/"+backend.name, func(t *testing.T) {
+				tokens, scanErr := (&Scanner{}).ScanTokens(tc.in)
+				if scanErr != nil {
+					t.Fatalf("scanning error in test input: %s", scanErr)
+				}
+				stmts, parseErr := (&Parser{Tokens: tokens}).Parse()
+				if parseErr != nil {
+					t.Fatalf("parsing error in test input: %s", parseErr)
+				}
+				out := &bytes.Buffer{}
+				interpreter := &Interpreter{Stdout: out}
+				interpreter.init()
+				resolver := &Resolver{}
+				resolveErr := resolver.Resolve(stmts)
+				if resolveErr != nil {
+					t.Fatalf("resolution error in test input: %s", resolveErr)
+				}
+				err := backend.interpret(interpreter, stmts)
+				actual := out.String()
+				if tc.errExpected && err == nil {
+					t.Error("expected error, didn't get one")
+				} else if !tc.errExpected && err != nil {
+					t.Errorf("unexpected error: %s", err)
+				} else if err != nil && !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Errorf("expected error containing %q, got %q", tc.expectedErr, err)
+				} else if actual != tc.expected {
+					t.Errorf("expected %q, got %q", tc.expected, actual)
+				}
+			})
+		}
 	}
 }
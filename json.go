@@ -0,0 +1,783 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// Syntax trees can be saved as JSON, for tools written in other languages,
+// and loaded back to be run. Each Expr and Stmt is an object whose "node"
+// names its type, with a key for each of its fields:
+//
+//	{"node": "Binary",
+//	 "left": {"node": "Literal", "value": {"int": "1"}},
+//	 "operator": {"type": "PLUS", "lexeme": "+", "literal": null, "line": 1},
+//	 "right": {"node": "Variable", "name": {...}}}
+//
+// Optional children and lists which are missing are null; loading a tree
+// with any other child null is an error. Numbers are
+// objects keyed by their kind ("int", "float", "bigint" or "decimal"),
+// with the number as a string so that nothing is lost.
+//
+// What the Resolver works out isn't saved, so a tree which has been
+// loaded has to be resolved again before it's run, as if it had just
+// been parsed.
+
+// UnmarshalStmts loads a program saved by marshalling its statements.
+func UnmarshalStmts(data []byte) ([]Stmt, error) {
+	var stmts []stmtJSON
+	if err := json.Unmarshal(data, &stmts); err != nil {
+		return nil, err
+	}
+	for idx, stmt := range stmts {
+		if stmt.Stmt == nil {
+			return nil, fmt.Errorf("statement %d is null", idx)
+		}
+	}
+	return fromStmtsJSON(stmts), nil
+}
+
+// exprNodes and stmtNodes are the types of node, by their "node" name.
+var (
+	exprNodes = nodeTypes(Assign{}, Binary{}, Call{}, Get{}, Grouping{}, Literal{},
+		Logical{}, Set{}, Super{}, This{}, Unary{}, Variable{})
+	stmtNodes = nodeTypes(AssertStmt{}, BlockStmt{}, ClassStmt{}, ExprStmt{}, FunctionStmt{},
+		IfStmt{}, InterfaceStmt{}, PrintStmt{}, ReturnStmt{}, SelectStmt{}, SpawnStmt{},
+		VariableStmt{}, WhileStmt{})
+)
+
+func nodeTypes(nodes ...interface{}) map[string]reflect.Type {
+	types := make(map[string]reflect.Type, len(nodes))
+	for _, node := range nodes {
+		t := reflect.TypeOf(node)
+		types[t.Name()] = t
+	}
+	return types
+}
+
+// unmarshalNode decodes a node of whichever of types its "node" names,
+// returning nil for null.
+func unmarshalNode(data []byte, types map[string]reflect.Type, kind string) (interface{}, error) {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil, nil
+	}
+	var head struct {
+		Node string `json:"node"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+	t, found := types[head.Node]
+	if !found {
+		return nil, fmt.Errorf("unknown %s node %q", kind, head.Node)
+	}
+	node := reflect.New(t)
+	if err := json.Unmarshal(data, node.Interface()); err != nil {
+		return nil, err
+	}
+	return node.Elem().Interface(), nil
+}
+
+// exprJSON and stmtJSON hold the children of nodes while they're encoded
+// or decoded, since only a concrete type can be decoded into.
+type exprJSON struct{ Expr }
+
+func (e exprJSON) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Expr)
+}
+
+func (e *exprJSON) UnmarshalJSON(data []byte) error {
+	node, err := unmarshalNode(data, exprNodes, "expression")
+	if node != nil {
+		e.Expr = node.(Expr)
+	}
+	return err
+}
+
+type stmtJSON struct{ Stmt }
+
+func (s stmtJSON) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Stmt)
+}
+
+func (s *stmtJSON) UnmarshalJSON(data []byte) error {
+	node, err := unmarshalNode(data, stmtNodes, "statement")
+	if node != nil {
+		s.Stmt = node.(Stmt)
+	}
+	return err
+}
+
+// requireChildren returns err if it isn't nil, and otherwise an error
+// naming the first of node's required children that's missing: fields
+// alternates the names of children with the children themselves, which
+// are either nodes or lists whose elements are required.
+func requireChildren(err error, node string, fields ...interface{}) error {
+	if err != nil {
+		return err
+	}
+	for idx := 0; idx < len(fields); idx += 2 {
+		name := fields[idx]
+		switch child := fields[idx+1].(type) {
+		case nil:
+			return fmt.Errorf("%s node is missing its %q", node, name)
+		case []exprJSON:
+			for n, expr := range child {
+				if expr.Expr == nil {
+					return fmt.Errorf("%s node is missing element %d of its %q", node, n, name)
+				}
+			}
+		case []stmtJSON:
+			for n, stmt := range child {
+				if stmt.Stmt == nil {
+					return fmt.Errorf("%s node is missing element %d of its %q", node, n, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// The conversions between lists keep nil lists nil, since for some (like
+// SelectStmt.Default) that's different to an empty one.
+
+func exprsJSON(exprs []Expr) []exprJSON {
+	if exprs == nil {
+		return nil
+	}
+	wrapped := make([]exprJSON, len(exprs))
+	for idx, expr := range exprs {
+		wrapped[idx] = exprJSON{expr}
+	}
+	return wrapped
+}
+
+func fromExprsJSON(wrapped []exprJSON) []Expr {
+	if wrapped == nil {
+		return nil
+	}
+	exprs := make([]Expr, len(wrapped))
+	for idx, expr := range wrapped {
+		exprs[idx] = expr.Expr
+	}
+	return exprs
+}
+
+func stmtsJSON(stmts []Stmt) []stmtJSON {
+	if stmts == nil {
+		return nil
+	}
+	wrapped := make([]stmtJSON, len(stmts))
+	for idx, stmt := range stmts {
+		wrapped[idx] = stmtJSON{stmt}
+	}
+	return wrapped
+}
+
+func fromStmtsJSON(wrapped []stmtJSON) []Stmt {
+	if wrapped == nil {
+		return nil
+	}
+	stmts := make([]Stmt, len(wrapped))
+	for idx, stmt := range wrapped {
+		stmts[idx] = stmt.Stmt
+	}
+	return stmts
+}
+
+// valueJSON holds the value of a Literal, or the literal of a Token.
+type valueJSON struct {
+	value interface{}
+}
+
+func (v valueJSON) MarshalJSON() ([]byte, error) {
+	switch value := v.value.(type) {
+	case nil, bool, string:
+		return json.Marshal(value)
+	case int64:
+		return json.Marshal(map[string]string{"int": strconv.FormatInt(value, 10)})
+	case float64:
+		return json.Marshal(map[string]string{"float": strconv.FormatFloat(value, 'g', -1, 64)})
+	case *big.Int:
+		return json.Marshal(map[string]string{"bigint": value.String()})
+	case Decimal:
+		return json.Marshal(map[string]string{"decimal": value.String()})
+	}
+	return nil, fmt.Errorf("can't save a literal %s", typeName(v.value))
+}
+
+func (v *valueJSON) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &v.value); err != nil {
+		return err
+	}
+	switch v.value.(type) {
+	case nil, bool, string:
+		return nil
+	}
+	// a bare JSON number could be any kind of number
+	if number, ok := v.value.(map[string]interface{}); ok && len(number) == 1 {
+		for kind, text := range number {
+			if text, ok := text.(string); ok {
+				return v.parseNumber(kind, text)
+			}
+		}
+	}
+	return fmt.Errorf("invalid literal %s", data)
+}
+
+func (v *valueJSON) parseNumber(kind, text string) (err error) {
+	switch kind {
+	case "int":
+		v.value, err = strconv.ParseInt(text, 10, 64)
+	case "float":
+		v.value, err = strconv.ParseFloat(text, 64)
+	case "bigint":
+		n, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			return fmt.Errorf("invalid bigint %q", text)
+		}
+		v.value = n
+	case "decimal":
+		v.value, err = parseDecimal(text)
+	default:
+		return fmt.Errorf("unknown kind of number %q", kind)
+	}
+	return err
+}
+
+var printableToTokenType = func() map[string]TokenType {
+	types := make(map[string]TokenType, len(tokenTypeToPrintable))
+	for typ, name := range tokenTypeToPrintable {
+		types[name] = typ
+	}
+	return types
+}()
+
+type tokenJSON struct {
+	Type    string    `json:"type"`
+	Lexeme  string    `json:"lexeme"`
+	Literal valueJSON `json:"literal"`
+	Line    int       `json:"line"`
+}
+
+func (t Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tokenJSON{tokenTypeToPrintable[t.Type], t.Lexeme, valueJSON{t.Literal}, t.Line})
+}
+
+func (t *Token) UnmarshalJSON(data []byte) error {
+	var j tokenJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	typ, found := printableToTokenType[j.Type]
+	if !found {
+		return fmt.Errorf("unknown token type %q", j.Type)
+	}
+	*t = Token{Type: typ, Lexeme: j.Lexeme, Literal: j.Literal.value, Line: j.Line}
+	return nil
+}
+
+// Expressions
+
+type assignJSON struct {
+	Node  string   `json:"node"`
+	Name  Token    `json:"name"`
+	Value exprJSON `json:"value"`
+}
+
+func (a Assign) MarshalJSON() ([]byte, error) {
+	return json.Marshal(assignJSON{"Assign", a.Name, exprJSON{a.Value}})
+}
+
+func (a *Assign) UnmarshalJSON(data []byte) error {
+	var j assignJSON
+	err := json.Unmarshal(data, &j)
+	*a = Assign{Name: j.Name, Value: j.Value.Expr, Binding: &Binding{}}
+	return requireChildren(err, "Assign", "value", a.Value)
+}
+
+type binaryJSON struct {
+	Node     string   `json:"node"`
+	Left     exprJSON `json:"left"`
+	Operator Token    `json:"operator"`
+	Right    exprJSON `json:"right"`
+}
+
+func (b Binary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(binaryJSON{"Binary", exprJSON{b.Left}, b.Operator, exprJSON{b.Right}})
+}
+
+func (b *Binary) UnmarshalJSON(data []byte) error {
+	var j binaryJSON
+	err := json.Unmarshal(data, &j)
+	*b = Binary{Left: j.Left.Expr, Operator: j.Operator, Right: j.Right.Expr}
+	return requireChildren(err, "Binary", "left", b.Left, "right", b.Right)
+}
+
+type callJSON struct {
+	Node   string     `json:"node"`
+	Callee exprJSON   `json:"callee"`
+	Paren  Token      `json:"paren"`
+	Args   []exprJSON `json:"args"`
+	Named  []NamedArg `json:"named"`
+}
+
+func (c Call) MarshalJSON() ([]byte, error) {
+	return json.Marshal(callJSON{"Call", exprJSON{c.Callee}, c.Paren, exprsJSON(c.Args), c.Named})
+}
+
+func (c *Call) UnmarshalJSON(data []byte) error {
+	var j callJSON
+	err := json.Unmarshal(data, &j)
+	*c = Call{Callee: j.Callee.Expr, Paren: j.Paren, Args: fromExprsJSON(j.Args), Named: j.Named}
+	return requireChildren(err, "Call", "callee", c.Callee, "args", j.Args)
+}
+
+type namedArgJSON struct {
+	Name  Token    `json:"name"`
+	Value exprJSON `json:"value"`
+}
+
+func (na NamedArg) MarshalJSON() ([]byte, error) {
+	return json.Marshal(namedArgJSON{na.Name, exprJSON{na.Value}})
+}
+
+func (na *NamedArg) UnmarshalJSON(data []byte) error {
+	var j namedArgJSON
+	err := json.Unmarshal(data, &j)
+	*na = NamedArg{Name: j.Name, Value: j.Value.Expr}
+	return requireChildren(err, "NamedArg", "value", na.Value)
+}
+
+type getJSON struct {
+	Node   string   `json:"node"`
+	Object exprJSON `json:"object"`
+	Name   Token    `json:"name"`
+}
+
+func (g Get) MarshalJSON() ([]byte, error) {
+	return json.Marshal(getJSON{"Get", exprJSON{g.Object}, g.Name})
+}
+
+func (g *Get) UnmarshalJSON(data []byte) error {
+	var j getJSON
+	err := json.Unmarshal(data, &j)
+	*g = Get{Object: j.Object.Expr, Name: j.Name, Cache: &MethodCache{}}
+	return requireChildren(err, "Get", "object", g.Object)
+}
+
+type groupingJSON struct {
+	Node       string   `json:"node"`
+	Expression exprJSON `json:"expression"`
+}
+
+func (g Grouping) MarshalJSON() ([]byte, error) {
+	return json.Marshal(groupingJSON{"Grouping", exprJSON{g.Expression}})
+}
+
+func (g *Grouping) UnmarshalJSON(data []byte) error {
+	var j groupingJSON
+	err := json.Unmarshal(data, &j)
+	*g = Grouping{Expression: j.Expression.Expr}
+	return requireChildren(err, "Grouping", "expression", g.Expression)
+}
+
+type literalJSON struct {
+	Node  string    `json:"node"`
+	Value valueJSON `json:"value"`
+}
+
+func (l Literal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(literalJSON{"Literal", valueJSON{l.Value}})
+}
+
+func (l *Literal) UnmarshalJSON(data []byte) error {
+	var j literalJSON
+	err := json.Unmarshal(data, &j)
+	*l = Literal{Value: j.Value.value}
+	return err
+}
+
+type logicalJSON binaryJSON
+
+func (l Logical) MarshalJSON() ([]byte, error) {
+	return json.Marshal(logicalJSON{"Logical", exprJSON{l.Left}, l.Operator, exprJSON{l.Right}})
+}
+
+func (l *Logical) UnmarshalJSON(data []byte) error {
+	var j logicalJSON
+	err := json.Unmarshal(data, &j)
+	*l = Logical{Left: j.Left.Expr, Operator: j.Operator, Right: j.Right.Expr}
+	return requireChildren(err, "Logical", "left", l.Left, "right", l.Right)
+}
+
+type setJSON struct {
+	Node   string   `json:"node"`
+	Object exprJSON `json:"object"`
+	Name   Token    `json:"name"`
+	Value  exprJSON `json:"value"`
+}
+
+func (s Set) MarshalJSON() ([]byte, error) {
+	return json.Marshal(setJSON{"Set", exprJSON{s.Object}, s.Name, exprJSON{s.Value}})
+}
+
+func (s *Set) UnmarshalJSON(data []byte) error {
+	var j setJSON
+	err := json.Unmarshal(data, &j)
+	*s = Set{Object: j.Object.Expr, Name: j.Name, Value: j.Value.Expr}
+	return requireChildren(err, "Set", "object", s.Object, "value", s.Value)
+}
+
+type superJSON struct {
+	Node    string `json:"node"`
+	Keyword Token  `json:"keyword"`
+	Method  Token  `json:"method"`
+}
+
+func (s Super) MarshalJSON() ([]byte, error) {
+	return json.Marshal(superJSON{"Super", s.Keyword, s.Method})
+}
+
+func (s *Super) UnmarshalJSON(data []byte) error {
+	var j superJSON
+	err := json.Unmarshal(data, &j)
+	*s = Super{Keyword: j.Keyword, Method: j.Method, Binding: &Binding{}}
+	return err
+}
+
+type thisJSON struct {
+	Node    string `json:"node"`
+	Keyword Token  `json:"keyword"`
+}
+
+func (t This) MarshalJSON() ([]byte, error) {
+	return json.Marshal(thisJSON{"This", t.Keyword})
+}
+
+func (t *This) UnmarshalJSON(data []byte) error {
+	var j thisJSON
+	err := json.Unmarshal(data, &j)
+	*t = This{Keyword: j.Keyword, Binding: &Binding{}}
+	return err
+}
+
+type unaryJSON struct {
+	Node     string   `json:"node"`
+	Operator Token    `json:"operator"`
+	Right    exprJSON `json:"right"`
+}
+
+func (u Unary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(unaryJSON{"Unary", u.Operator, exprJSON{u.Right}})
+}
+
+func (u *Unary) UnmarshalJSON(data []byte) error {
+	var j unaryJSON
+	err := json.Unmarshal(data, &j)
+	*u = Unary{Operator: j.Operator, Right: j.Right.Expr}
+	return requireChildren(err, "Unary", "right", u.Right)
+}
+
+type variableJSON struct {
+	Node string `json:"node"`
+	Name Token  `json:"name"`
+}
+
+func (v Variable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(variableJSON{"Variable", v.Name})
+}
+
+func (v *Variable) UnmarshalJSON(data []byte) error {
+	var j variableJSON
+	err := json.Unmarshal(data, &j)
+	*v = Variable{Name: j.Name, Binding: &Binding{}}
+	return err
+}
+
+// Statements
+
+type assertStmtJSON struct {
+	Node      string   `json:"node"`
+	Keyword   Token    `json:"keyword"`
+	Condition exprJSON `json:"condition"`
+	Message   exprJSON `json:"message"`
+	Source    string   `json:"source"`
+}
+
+func (as AssertStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(assertStmtJSON{"AssertStmt", as.Keyword, exprJSON{as.Condition}, exprJSON{as.Message}, as.Source})
+}
+
+func (as *AssertStmt) UnmarshalJSON(data []byte) error {
+	var j assertStmtJSON
+	err := json.Unmarshal(data, &j)
+	*as = AssertStmt{Keyword: j.Keyword, Condition: j.Condition.Expr, Message: j.Message.Expr, Source: j.Source}
+	return requireChildren(err, "AssertStmt", "condition", as.Condition)
+}
+
+type blockStmtJSON struct {
+	Node       string     `json:"node"`
+	Statements []stmtJSON `json:"statements"`
+}
+
+func (b BlockStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(blockStmtJSON{"BlockStmt", stmtsJSON(b.Statements)})
+}
+
+func (b *BlockStmt) UnmarshalJSON(data []byte) error {
+	var j blockStmtJSON
+	err := json.Unmarshal(data, &j)
+	*b = BlockStmt{Statements: fromStmtsJSON(j.Statements)}
+	return requireChildren(err, "BlockStmt", "statements", j.Statements)
+}
+
+type classStmtJSON struct {
+	Node       string         `json:"node"`
+	Name       Token          `json:"name"`
+	Superclass *Variable      `json:"superclass"`
+	Methods    []FunctionStmt `json:"methods"`
+	Interfaces []Variable     `json:"interfaces"`
+	IsData     bool           `json:"isData"`
+	Fields     []Token        `json:"fields"`
+	Doc        string         `json:"doc"`
+}
+
+func (cs ClassStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(classStmtJSON{"ClassStmt", cs.Name, cs.Superclass, cs.Methods, cs.Interfaces, cs.IsData, cs.Fields, cs.Doc})
+}
+
+func (cs *ClassStmt) UnmarshalJSON(data []byte) error {
+	var j classStmtJSON
+	err := json.Unmarshal(data, &j)
+	*cs = ClassStmt{
+		Name:       j.Name,
+		Superclass: j.Superclass,
+		Methods:    j.Methods,
+		Interfaces: j.Interfaces,
+		IsData:     j.IsData,
+		Fields:     j.Fields,
+		Doc:        j.Doc,
+	}
+	return err
+}
+
+type exprStmtJSON struct {
+	Node       string   `json:"node"`
+	Expression exprJSON `json:"expression"`
+}
+
+func (es ExprStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(exprStmtJSON{"ExprStmt", exprJSON{es.Expression}})
+}
+
+func (es *ExprStmt) UnmarshalJSON(data []byte) error {
+	var j exprStmtJSON
+	err := json.Unmarshal(data, &j)
+	*es = ExprStmt{Expression: j.Expression.Expr}
+	return requireChildren(err, "ExprStmt", "expression", es.Expression)
+}
+
+type functionStmtJSON struct {
+	Node       string     `json:"node"`
+	Name       Token      `json:"name"`
+	Params     []Token    `json:"params"`
+	ParamTypes []*Token   `json:"paramTypes"`
+	ReturnType *Token     `json:"returnType"`
+	Body       []stmtJSON `json:"body"`
+	Doc        string     `json:"doc"`
+}
+
+func (fs FunctionStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(functionStmtJSON{"FunctionStmt", fs.Name, fs.Params, fs.ParamTypes, fs.ReturnType, stmtsJSON(fs.Body), fs.Doc})
+}
+
+func (fs *FunctionStmt) UnmarshalJSON(data []byte) error {
+	var j functionStmtJSON
+	err := json.Unmarshal(data, &j)
+	*fs = FunctionStmt{
+		Name:       j.Name,
+		Params:     j.Params,
+		ParamTypes: j.ParamTypes,
+		ReturnType: j.ReturnType,
+		Body:       fromStmtsJSON(j.Body),
+		Doc:        j.Doc,
+	}
+	return requireChildren(err, "FunctionStmt", "body", j.Body)
+}
+
+type ifStmtJSON struct {
+	Node      string   `json:"node"`
+	Condition exprJSON `json:"condition"`
+	Then      stmtJSON `json:"then"`
+	Else      stmtJSON `json:"else"`
+}
+
+func (i IfStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ifStmtJSON{"IfStmt", exprJSON{i.Condition}, stmtJSON{i.Then}, stmtJSON{i.Else}})
+}
+
+func (i *IfStmt) UnmarshalJSON(data []byte) error {
+	var j ifStmtJSON
+	err := json.Unmarshal(data, &j)
+	*i = IfStmt{Condition: j.Condition.Expr, Then: j.Then.Stmt, Else: j.Else.Stmt}
+	return requireChildren(err, "IfStmt", "condition", i.Condition, "then", i.Then)
+}
+
+type interfaceStmtJSON struct {
+	Node    string            `json:"node"`
+	Name    Token             `json:"name"`
+	Methods []InterfaceMethod `json:"methods"`
+}
+
+func (is InterfaceStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(interfaceStmtJSON{"InterfaceStmt", is.Name, is.Methods})
+}
+
+func (is *InterfaceStmt) UnmarshalJSON(data []byte) error {
+	var j interfaceStmtJSON
+	err := json.Unmarshal(data, &j)
+	*is = InterfaceStmt{Name: j.Name, Methods: j.Methods}
+	return err
+}
+
+type printStmtJSON struct {
+	Node       string   `json:"node"`
+	Expression exprJSON `json:"expression"`
+}
+
+func (p PrintStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(printStmtJSON{"PrintStmt", exprJSON{p.Expression}})
+}
+
+func (p *PrintStmt) UnmarshalJSON(data []byte) error {
+	var j printStmtJSON
+	err := json.Unmarshal(data, &j)
+	*p = PrintStmt{Expression: j.Expression.Expr}
+	return requireChildren(err, "PrintStmt", "expression", p.Expression)
+}
+
+type returnStmtJSON struct {
+	Node    string   `json:"node"`
+	Keyword Token    `json:"keyword"`
+	Value   exprJSON `json:"value"`
+}
+
+func (r ReturnStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(returnStmtJSON{"ReturnStmt", r.Keyword, exprJSON{r.Value}})
+}
+
+func (r *ReturnStmt) UnmarshalJSON(data []byte) error {
+	var j returnStmtJSON
+	err := json.Unmarshal(data, &j)
+	*r = ReturnStmt{Keyword: j.Keyword, Value: j.Value.Expr}
+	return err
+}
+
+type selectStmtJSON struct {
+	Node    string       `json:"node"`
+	Keyword Token        `json:"keyword"`
+	Cases   []SelectCase `json:"cases"`
+	Default []stmtJSON   `json:"default"`
+}
+
+func (s SelectStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(selectStmtJSON{"SelectStmt", s.Keyword, s.Cases, stmtsJSON(s.Default)})
+}
+
+func (s *SelectStmt) UnmarshalJSON(data []byte) error {
+	var j selectStmtJSON
+	err := json.Unmarshal(data, &j)
+	*s = SelectStmt{Keyword: j.Keyword, Cases: j.Cases, Default: fromStmtsJSON(j.Default)}
+	return requireChildren(err, "SelectStmt", "default", j.Default)
+}
+
+type selectCaseJSON struct {
+	Keyword Token      `json:"keyword"`
+	Channel exprJSON   `json:"channel"`
+	Send    bool       `json:"send"`
+	Value   exprJSON   `json:"value"`
+	Name    *Token     `json:"name"`
+	Body    []stmtJSON `json:"body"`
+}
+
+func (sc SelectCase) MarshalJSON() ([]byte, error) {
+	return json.Marshal(selectCaseJSON{sc.Keyword, exprJSON{sc.Channel}, sc.Send, exprJSON{sc.Value}, sc.Name, stmtsJSON(sc.Body)})
+}
+
+func (sc *SelectCase) UnmarshalJSON(data []byte) error {
+	var j selectCaseJSON
+	err := json.Unmarshal(data, &j)
+	*sc = SelectCase{
+		Keyword: j.Keyword,
+		Channel: j.Channel.Expr,
+		Send:    j.Send,
+		Value:   j.Value.Expr,
+		Name:    j.Name,
+		Body:    fromStmtsJSON(j.Body),
+	}
+	if err == nil && sc.Send {
+		// only a send has a value
+		err = requireChildren(err, "SelectCase", "value", sc.Value)
+	}
+	return requireChildren(err, "SelectCase", "channel", sc.Channel, "body", j.Body)
+}
+
+type spawnStmtJSON struct {
+	Node    string `json:"node"`
+	Keyword Token  `json:"keyword"`
+	Call    *Call  `json:"call"`
+}
+
+func (s SpawnStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(spawnStmtJSON{"SpawnStmt", s.Keyword, &s.Call})
+}
+
+func (s *SpawnStmt) UnmarshalJSON(data []byte) error {
+	var j spawnStmtJSON
+	err := json.Unmarshal(data, &j)
+	if err == nil && j.Call == nil {
+		return requireChildren(err, "SpawnStmt", "call", nil)
+	}
+	*s = SpawnStmt{Keyword: j.Keyword, Call: *j.Call}
+	return err
+}
+
+type variableStmtJSON struct {
+	Node        string   `json:"node"`
+	Name        Token    `json:"name"`
+	Type        *Token   `json:"type"`
+	Initializer exprJSON `json:"initializer"`
+}
+
+func (vs VariableStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(variableStmtJSON{"VariableStmt", vs.Name, vs.Type, exprJSON{vs.Initializer}})
+}
+
+func (vs *VariableStmt) UnmarshalJSON(data []byte) error {
+	var j variableStmtJSON
+	err := json.Unmarshal(data, &j)
+	*vs = VariableStmt{Name: j.Name, Type: j.Type, Initializer: j.Initializer.Expr}
+	return err
+}
+
+type whileStmtJSON struct {
+	Node      string   `json:"node"`
+	Condition exprJSON `json:"condition"`
+	Body      stmtJSON `json:"body"`
+}
+
+func (w WhileStmt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(whileStmtJSON{"WhileStmt", exprJSON{w.Condition}, stmtJSON{w.Body}})
+}
+
+func (w *WhileStmt) UnmarshalJSON(data []byte) error {
+	var j whileStmtJSON
+	err := json.Unmarshal(data, &j)
+	*w = WhileStmt{Condition: j.Condition.Expr, Body: j.Body.Stmt}
+	return requireChildren(err, "WhileStmt", "condition", w.Condition, "body", w.Body)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalStmts(t *testing.T) {
+	testCases := map[string]struct {
+		in          string
+		expected    string
+		expectedErr string
+	}{
+		"expressions and literals": {
+			in: `
+var a = 1;
+var b: number = -(a + 2.5) * 3;
+print b;
+print 2n * 3n;
+print 1.10d;
+print nil == false or !true and "s" != "t";
+a = a + 1;
+print a;
+`,
+			expected: "-10.5\n6\n1.10\nfalse\n2\n",
+		},
+		"control flow": {
+			in: `
+fun count(n) {
+  for (var i = 0; i < n; i = i + 1) {
+    if (i == 1) { print "one"; } else print i;
+  }
+  return;
+}
+count(3);
+assert 1 < 2, "unreachable";
+assert 2 < 1, "two isn't less than one";
+`,
+			expected:    "0\none\n2\n",
+			expectedErr: "Assertion failed: 2 < 1: two isn't less than one",
+		},
+		"classes, interfaces and doc comments": {
+			in: `
+interface Shape { area(); scale(factor: number): number; }
+/// A shape with sides.
+class Polygon implements Shape {
+  init(sides) { this.sides = sides; }
+  area() { return 0; }
+  scale(factor) { return this.sides * factor; }
+}
+class Square < Polygon {
+  /// Makes a square.
+  init(side) { super.init(4); this.side = side; }
+  area() { return this.side * this.side; }
+}
+data class Point(x, y) {}
+var s = Square(3);
+print s.area();
+print s.scale(2);
+print Point(1, 2).copy(y: 5);
+help(Polygon);
+`,
+			expected: "9\n8\nPoint(x=1, y=5)\nclass Polygon\nA shape with sides.\n  area()\n  init(sides)\n  scale(factor)\n",
+		},
+		"tasks and select": {
+			in: `
+fun later(ch) { ch.send("late"); }
+var ch = channel(0);
+spawn later(ch);
+select {
+  case var msg = ch.receive() { print msg; }
+}
+select {
+  case ch.send(1) { print "sent"; }
+  default {}
+}
+print "done";
+`,
+			expected: "late\ndone\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		tokens, scanErr := (&Scanner{}).ScanTokens(tc.in)
+		if scanErr != nil {
+			t.Fatalf("scanning error in test input: %s", scanErr)
+		}
+		parsed, parseErr := (&Parser{Tokens: tokens}).Parse()
+		if parseErr != nil {
+			t.Fatalf("parsing error in test input: %s", parseErr)
+		}
+		saved, err := json.Marshal(parsed)
+		if err != nil {
+			t.Fatalf("unexpected error marshalling %s: %s", name, err)
+		}
+
+		for _, backend := range testBackends {
+			t.Run(name+"/"+backend.name, func(t *testing.T) {
+				stmts, err := UnmarshalStmts(saved)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if expected, actual := printAST(parsed), printAST(stmts); actual != expected {
+					t.Errorf("expected the loaded tree to be:\n%s\ngot:\n%s", expected, actual)
+				}
+				if resaved, err := json.Marshal(stmts); err != nil || !bytes.Equal(resaved, saved) {
+					t.Errorf("expected the loaded tree to save the same, got error %v and:\n%s", err, resaved)
+				}
+
+				if err := (&Resolver{}).Resolve(stmts); err != nil {
+					t.Fatalf("resolution error: %s", err)
+				}
+				if err := (&Checker{}).Check(stmts); err != nil {
+					t.Fatalf("type error: %s", err)
+				}
+				stdout := &bytes.Buffer{}
+				err = backend.interpret(&Interpreter{Stdout: stdout}, stmts)
+				if tc.expectedErr == "" && err != nil {
+					t.Errorf("unexpected error: %s", err)
+				} else if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+					t.Errorf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				if stdout.String() != tc.expected {
+					t.Errorf("expected output %q, got %q", tc.expected, stdout.String())
+				}
+			})
+		}
+	}
+}
+
+func TestUnmarshalStmts_optimized(t *testing.T) {
+	tokens, _ := (&Scanner{}).ScanTokens("print 1 + 2.5; print 2n * 2; print \"a\" + \"b\";")
+	stmts, _ := (&Parser{Tokens: tokens}).Parse()
+	if err := (&Resolver{}).Resolve(stmts); err != nil {
+		t.Fatal(err)
+	}
+	// folded literals have no token to get their values from
+	saved, err := json.Marshal((&Optimizer{}).Optimize(stmts))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	loaded, err := UnmarshalStmts(saved)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "(print 3.5)\n(print 4)\n(print \"ab\")\n"
+	if actual := printAST(loaded); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestUnmarshalStmts_errors(t *testing.T) {
+	testCases := map[string]struct {
+		in          string
+		expectedErr string
+	}{
+		"not a list": {
+			in:          `{"node": "PrintStmt"}`,
+			expectedErr: "cannot unmarshal object",
+		},
+		"unknown statement": {
+			in:          `[{"node": "GotoStmt"}]`,
+			expectedErr: `unknown statement node "GotoStmt"`,
+		},
+		"expression where a statement should be": {
+			in:          `[{"node": "Literal", "value": 1}]`,
+			expectedErr: `unknown statement node "Literal"`,
+		},
+		"unknown token type": {
+			in:          `[{"node": "PrintStmt", "expression": {"node": "Variable", "name": {"type": "NAME", "lexeme": "x"}}}]`,
+			expectedErr: `unknown token type "NAME"`,
+		},
+		"bare number": {
+			in:          `[{"node": "PrintStmt", "expression": {"node": "Literal", "value": 1}}]`,
+			expectedErr: "invalid literal 1",
+		},
+		"unknown kind of number": {
+			in:          `[{"node": "PrintStmt", "expression": {"node": "Literal", "value": {"complex": "1i"}}}]`,
+			expectedErr: `unknown kind of number "complex"`,
+		},
+		"invalid number": {
+			in:          `[{"node": "PrintStmt", "expression": {"node": "Literal", "value": {"int": "one"}}}]`,
+			expectedErr: `parsing "one": invalid syntax`,
+		},
+		"null statement": {
+			in:          `[null]`,
+			expectedErr: "statement 0 is null",
+		},
+		"null print expression": {
+			in:          `[{"node": "PrintStmt", "expression": null}]`,
+			expectedErr: `PrintStmt node is missing its "expression"`,
+		},
+		"if without then": {
+			in:          `[{"node": "IfStmt", "condition": {"node": "Literal", "value": true}, "then": null, "else": null}]`,
+			expectedErr: `IfStmt node is missing its "then"`,
+		},
+		"binary without right": {
+			in:          `[{"node": "ExprStmt", "expression": {"node": "Binary", "left": {"node": "Literal", "value": true}}}]`,
+			expectedErr: `Binary node is missing its "right"`,
+		},
+		"null argument": {
+			in:          `[{"node": "ExprStmt", "expression": {"node": "Call", "callee": {"node": "Literal", "value": true}, "args": [null]}}]`,
+			expectedErr: `Call node is missing element 0 of its "args"`,
+		},
+		"null statement in block": {
+			in:          `[{"node": "BlockStmt", "statements": [{"node": "ReturnStmt", "value": null}, null]}]`,
+			expectedErr: `BlockStmt node is missing element 1 of its "statements"`,
+		},
+		"spawn without call": {
+			in:          `[{"node": "SpawnStmt"}]`,
+			expectedErr: `SpawnStmt node is missing its "call"`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := UnmarshalStmts([]byte(tc.in))
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("expected error containing %q, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestToken_MarshalJSON(t *testing.T) {
+	testCases := map[string]struct {
+		in       Token
+		expected string
+	}{
+		"keyword": {
+			in:       Token{Type: PRINT, Lexeme: "print", Line: 3},
+			expected: `{"type":"PRINT","lexeme":"print","literal":null,"line":3}`,
+		},
+		"string": {
+			in:       Token{Type: STRING, Lexeme: `"hi"`, Literal: "hi", Line: 1},
+			expected: `{"type":"STRING","lexeme":"\"hi\"","literal":"hi","line":1}`,
+		},
+		"int": {
+			in:       Token{Type: NUMBER, Lexeme: "0xFF", Literal: int64(255), Line: 1},
+			expected: `{"type":"NUMBER","lexeme":"0xFF","literal":{"int":"255"},"line":1}`,
+		},
+		"float": {
+			in:       Token{Type: NUMBER, Lexeme: "1e20", Literal: 1e20, Line: 1},
+			expected: `{"type":"NUMBER","lexeme":"1e20","literal":{"float":"1e+20"},"line":1}`,
+		},
+		"bigint": {
+			in:       Token{Type: NUMBER, Lexeme: "99999999999999999999n", Literal: mustBigInt("99999999999999999999"), Line: 1},
+			expected: `{"type":"NUMBER","lexeme":"99999999999999999999n","literal":{"bigint":"99999999999999999999"},"line":1}`,
+		},
+		"decimal": {
+			in:       Token{Type: NUMBER, Lexeme: "-1.10d", Literal: Decimal{unscaled: big.NewInt(-110), scale: 2}, Line: 1},
+			expected: `{"type":"NUMBER","lexeme":"-1.10d","literal":{"decimal":"-1.10"},"line":1}`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			actual, err := json.Marshal(tc.in)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(actual) != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, actual)
+			}
+			var loaded Token
+			if err := json.Unmarshal(actual, &loaded); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(loaded, tc.in) {
+				t.Errorf("expected %#v to load back, got %#v", tc.in, loaded)
+			}
+		})
+	}
+}
+
+func mustBigInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid big.Int " + s)
+	}
+	return n
+}
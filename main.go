@@ -2,21 +2,67 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"time"
 )
 
 func main() {
-	if len(os.Args) > 2 {
-		fmt.Println("Usage: glox [script]")
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string, io.Writer) error{
+			"bench": benchCommand,
+			"parse": parseCommand,
+		}
+		if command, found := commands[os.Args[1]]; found {
+			if err := command(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	disableAssertions := flag.Bool("disable-assertions", false, "skip assert statements")
+	useVM := flag.Bool("vm", false, "run scripts with the bytecode VM instead of the tree-walking interpreter")
+	maxCallDepth := flag.Int("max-call-depth", DefaultMaxCallDepth, "how deeply function calls can nest before a stack overflow error")
+	budget := flag.Int64("budget", 0, "stop scripts after this many statements (0 for no limit)")
+	memoryQuota := flag.Int64("memory-quota", 0, "fail scripts which allocate roughly more than this many bytes (0 for no limit)")
+	timeout := flag.Duration("timeout", 0, "stop scripts which run for longer than this (0 for no limit)")
+	optimize := flag.Bool("optimize", false, "fold constants and remove dead branches before running")
+	dumpAST := flag.Bool("dump-ast", false, "print the (optimized) syntax tree before running it")
+	fromJSON := flag.Bool("ast", false, "the script is a syntax tree saved by \"glox parse -json\", rather than source")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: glox [flags] [script]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       glox bench [flags]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       glox parse [-json] script")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
 		os.Exit(64)
 	}
 
 	l := NewLox(os.Stdout)
-	if len(os.Args) == 2 {
-		l.runFile(os.Args[1])
+	l.interpreter.DisableAssertions = *disableAssertions
+	l.interpreter.MaxCallDepth = *maxCallDepth
+	l.interpreter.StatementBudget = *budget
+	l.interpreter.MemoryQuota = *memoryQuota
+	l.timeout = *timeout
+	if *useVM {
+		l.vm = NewVM(l.interpreter)
+	}
+	l.optimize = *optimize
+	l.dumpAST = *dumpAST
+	if flag.NArg() == 1 && *fromJSON {
+		l.runASTFile(flag.Arg(0))
+	} else if flag.NArg() == 1 {
+		l.runFile(flag.Arg(0))
 	} else {
 		l.runPrompt()
 	}
@@ -25,12 +71,18 @@ func main() {
 
 type Lox struct {
 	interpreter *Interpreter
+	vm          *VM // if set, runs code instead of interpreter
+	checker     *Checker
+	optimize    bool          // run the Optimizer after the Checker
+	dumpAST     bool          // print the tree that's going to be run
+	timeout     time.Duration // for each run, if non-zero
 	hadError    bool
 }
 
 func NewLox(stdout io.Writer) *Lox {
 	return &Lox{
 		interpreter: &Interpreter{Stdout: stdout},
+		checker:     &Checker{},
 	}
 }
 
@@ -45,6 +97,22 @@ func (l *Lox) runFile(path string) {
 	}
 }
 
+func (l *Lox) runASTFile(path string) {
+	fBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	stmts, err := UnmarshalStmts(fBytes)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		panic("error loading syntax tree")
+	}
+	l.runStmts(stmts)
+	if l.hadError {
+		panic("error interpreting file")
+	}
+}
+
 func (l *Lox) runPrompt() {
 	lineReader := bufio.NewScanner(os.Stdin)
 	for lineReader.Scan() {
@@ -57,19 +125,49 @@ func (l *Lox) runPrompt() {
 }
 
 func (l *Lox) run(src string) {
-	tokens := (&Scanner{}).ScanTokens(src)
+	tokens, err := (&Scanner{}).ScanTokens(src)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
 	stmts, err := (&Parser{Tokens: tokens}).Parse()
 	if err != nil {
 		fmt.Printf("ERROR: %s\n", err)
 		return
 	}
-	resolver := &Resolver{interpreter: l.interpreter}
-	err = resolver.Resolve(stmts)
+	l.runStmts(stmts)
+}
+
+// runStmts runs a program which has been parsed, or loaded from JSON.
+func (l *Lox) runStmts(stmts []Stmt) {
+	resolver := &Resolver{}
+	err := resolver.Resolve(stmts)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return
+	}
+	err = l.checker.Check(stmts)
 	if err != nil {
 		fmt.Printf("ERROR: %s\n", err)
 		return
 	}
-	err = l.interpreter.Interpret(stmts)
+	if l.optimize {
+		stmts = (&Optimizer{}).Optimize(stmts)
+	}
+	if l.dumpAST {
+		fmt.Fprint(os.Stderr, printAST(stmts))
+	}
+	ctx := context.Background()
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+	if l.vm != nil {
+		err = l.vm.InterpretContext(ctx, stmts)
+	} else {
+		err = l.interpreter.InterpretContext(ctx, stmts)
+	}
 	if err != nil {
 		fmt.Printf("ERROR: %s\n", err)
 		return
@@ -80,3 +178,39 @@ func (l *Lox) srcError(line int, message string) {
 	_, _ = fmt.Fprintf(os.Stderr, "[line %d] Error: %s\n", line, message)
 	l.hadError = true
 }
+
+// parseCommand implements "glox parse", which prints the syntax tree of a
+// script without running it.
+func parseCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the tree as JSON, which can be run with \"glox -ast\"")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: glox parse [-json] script")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("invalid arguments")
+	}
+
+	src, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	tokens, err := (&Scanner{}).ScanTokens(string(src))
+	if err != nil {
+		return err
+	}
+	stmts, err := (&Parser{Tokens: tokens}).Parse()
+	if err != nil {
+		return err
+	}
+	if !*asJSON {
+		_, err = io.WriteString(stdout, printAST(stmts))
+		return err
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(stmts)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// Lox has two numeric types: integers (int64), produced by literals
+// without a fractional part, and floats (float64). Arithmetic on two
+// integers stays integral as long as the result is exactly representable,
+// otherwise (on overflow, or inexact division) the result is promoted to
+// a float, as is any operation mixing the two types.
+//
+// There are also two exact types, bigints (*big.Int) and decimals, which
+// are handled in bignum.go.
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int64, float64, *big.Int, Decimal:
+		return true
+	}
+	return false
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	panic("toFloat called on a non-number")
+}
+
+// toInt converts v to an integer, if it's a number with no fractional part.
+func toInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case float64:
+		if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+			return int64(n), true
+		}
+	case *big.Int:
+		if n.IsInt64() {
+			return n.Int64(), true
+		}
+	case Decimal:
+		if r := n.rat(); r.IsInt() && r.Num().IsInt64() {
+			return r.Num().Int64(), true
+		}
+	}
+	return 0, false
+}
+
+// arithmetic applies one of the binary arithmetic operators to two numbers.
+func arithmetic(op TokenType, left, right interface{}) (interface{}, error) {
+	if isBigNumber(left) || isBigNumber(right) {
+		return bigArithmetic(op, left, right)
+	}
+
+	a, aIsInt := left.(int64)
+	b, bIsInt := right.(int64)
+	if aIsInt && bIsInt {
+		if result, ok := intArithmetic(op, a, b); ok {
+			return result, nil
+		}
+	}
+
+	x, y := toFloat(left), toFloat(right)
+	switch op {
+	case PLUS:
+		return x + y, nil
+	case MINUS:
+		return x - y, nil
+	case STAR:
+		return x * y, nil
+	case SLASH:
+		return x / y, nil
+	}
+	panic("arithmetic called with a non-arithmetic operator")
+}
+
+// intArithmetic returns false if the result can't be represented exactly
+// as an int64.
+func intArithmetic(op TokenType, a, b int64) (int64, bool) {
+	switch op {
+	case PLUS:
+		sum := a + b
+		if (a > 0 && b > 0 && sum < 0) || (a < 0 && b < 0 && sum >= 0) {
+			return 0, false
+		}
+		return sum, true
+	case MINUS:
+		diff := a - b
+		if (a >= 0 && b < 0 && diff < 0) || (a < 0 && b > 0 && diff >= 0) {
+			return 0, false
+		}
+		return diff, true
+	case STAR:
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		product := a * b
+		if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return 0, false
+		}
+		return product, true
+	case SLASH:
+		if b == 0 || a%b != 0 || (a == math.MinInt64 && b == -1) {
+			return 0, false
+		}
+		return a / b, true
+	}
+	return 0, false
+}
+
+// compareNumbers applies one of the comparison operators to two numbers.
+func compareNumbers(op TokenType, left, right interface{}) bool {
+	if isBigNumber(left) || isBigNumber(right) {
+		cmp, ok := compareExact(left, right)
+		if !ok {
+			return false
+		}
+		switch op {
+		case GREATER:
+			return cmp > 0
+		case GREATER_EQUAL:
+			return cmp >= 0
+		case LESS:
+			return cmp < 0
+		case LESS_EQUAL:
+			return cmp <= 0
+		}
+	}
+
+	a, aIsInt := left.(int64)
+	b, bIsInt := right.(int64)
+	if aIsInt && bIsInt {
+		switch op {
+		case GREATER:
+			return a > b
+		case GREATER_EQUAL:
+			return a >= b
+		case LESS:
+			return a < b
+		case LESS_EQUAL:
+			return a <= b
+		}
+	}
+
+	x, y := toFloat(left), toFloat(right)
+	switch op {
+	case GREATER:
+		return x > y
+	case GREATER_EQUAL:
+		return x >= y
+	case LESS:
+		return x < y
+	case LESS_EQUAL:
+		return x <= y
+	}
+	panic("compareNumbers called with a non-comparison operator")
+}
+
+func negate(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int64:
+		if n != math.MinInt64 {
+			return -n
+		}
+	case *big.Int:
+		return new(big.Int).Neg(n)
+	case Decimal:
+		return n.neg()
+	}
+	return -toFloat(v)
+}
+
+// isEqual implements Lox's "==". Numbers compare by value regardless of
+// whether they're integers or floats, so 1 == 1.0. Sets and data class
+// instances compare by their contents, functions by their declaration and
+// closure, methods by those and the instance they're bound to, and any
+// other object (instances, classes, lists, channels)
+// only equals itself.
+func isEqual(left, right interface{}) bool {
+	if isNumber(left) && isNumber(right) {
+		if isBigNumber(left) || isBigNumber(right) {
+			cmp, ok := compareExact(left, right)
+			return ok && cmp == 0
+		}
+		// compare as integers where we can, to avoid rounding large
+		// integers to the nearest float
+		a, aOk := toInt(left)
+		b, bOk := toInt(right)
+		if aOk && bOk {
+			return a == b
+		}
+		return toFloat(left) == toFloat(right)
+	}
+	if a, ok := left.(*HashSet); ok {
+		if b, ok := right.(*HashSet); ok {
+			return setEqual(a, b)
+		}
+	}
+	// data class instances are equal if their fields are
+	if a, ok := left.(*Instance); ok && a.Class.IsData {
+		if b, ok := right.(*Instance); ok {
+			return a.dataEqual(b)
+		}
+	}
+	if a, ok := left.(Function); ok {
+		if b, ok := right.(Function); ok {
+			return newFunctionKey(a) == newFunctionKey(b)
+		}
+	}
+	if a, ok := left.(*vmBoundMethod); ok {
+		if b, ok := right.(*vmBoundMethod); ok {
+			return *a == *b
+		}
+	}
+	if left == nil || right == nil || reflect.TypeOf(left) != reflect.TypeOf(right) {
+		return left == right
+	}
+	// values of the same type which can't be compared, like native
+	// functions, are never equal
+	return reflect.TypeOf(left).Comparable() && left == right
+}
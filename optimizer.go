@@ -0,0 +1,298 @@
+package main
+
+// Optimizer rewrites a resolved program into an equivalent, simpler one.
+// It folds operators applied to literals into a single literal, and
+// removes branches and loops whose conditions are literals. Anything
+// which would raise a runtime error is left for the interpreter to raise,
+// with its original line number.
+//
+// The rewritten nodes keep their Bindings, and the Optimizer never removes
+// a declaration from a scope it leaves in place, so the Resolver's slots
+// stay valid.
+type Optimizer struct {
+	// only used to apply operators, which doesn't touch its state
+	runtime Interpreter
+}
+
+// Optimize returns the optimized program.
+func (o *Optimizer) Optimize(stmts []Stmt) []Stmt {
+	return o.stmts(stmts)
+}
+
+func (o *Optimizer) stmts(stmts []Stmt) []Stmt {
+	var optimized []Stmt
+	for _, stmt := range stmts {
+		if stmt = o.stmt(stmt); stmt != nil {
+			optimized = append(optimized, stmt)
+		}
+	}
+	return optimized
+}
+
+// stmt optimizes a statement, returning nil if it can be removed.
+func (o *Optimizer) stmt(stmt Stmt) Stmt {
+	optimized, _ := stmt.Accept(o).(Stmt)
+	return optimized
+}
+
+// branch optimizes a statement which has to be kept in place, even if it
+// does nothing, e.g. the body of a loop.
+func (o *Optimizer) branch(stmt Stmt) Stmt {
+	if optimized := o.stmt(stmt); optimized != nil {
+		return optimized
+	}
+	return BlockStmt{}
+}
+
+func (o *Optimizer) expr(expr Expr) Expr {
+	if expr == nil {
+		return nil
+	}
+	return expr.Accept(o).(Expr)
+}
+
+func (o *Optimizer) exprs(exprs []Expr) []Expr {
+	optimized := make([]Expr, len(exprs))
+	for idx, expr := range exprs {
+		optimized[idx] = o.expr(expr)
+	}
+	return optimized
+}
+
+// constant applies an operator to literal operands, reporting false if
+// it fails, in which case the operator is left to fail at runtime.
+func (o *Optimizer) constant(apply func() interface{}) (value interface{}, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+	return apply(), true
+}
+
+func (o *Optimizer) function(fs FunctionStmt) FunctionStmt {
+	fs.Body = o.stmts(fs.Body)
+	return fs
+}
+
+func (o *Optimizer) call(ce Call) Call {
+	ce.Callee = o.expr(ce.Callee)
+	ce.Args = o.exprs(ce.Args)
+	if ce.Named != nil {
+		named := make([]NamedArg, len(ce.Named))
+		for idx, arg := range ce.Named {
+			named[idx] = NamedArg{Name: arg.Name, Value: o.expr(arg.Value)}
+		}
+		ce.Named = named
+	}
+	return ce
+}
+
+func (o *Optimizer) VisitAssign(expr Expr) interface{} {
+	ae := expr.(Assign)
+	ae.Value = o.expr(ae.Value)
+	return ae
+}
+
+func (o *Optimizer) VisitBinary(expr Expr) interface{} {
+	be := expr.(Binary)
+	be.Left = o.expr(be.Left)
+	be.Right = o.expr(be.Right)
+	left, leftOk := be.Left.(Literal)
+	right, rightOk := be.Right.(Literal)
+	if !leftOk || !rightOk {
+		return be
+	}
+	value, ok := o.constant(func() interface{} {
+		return o.runtime.binaryOp(be.Operator, left.Value, right.Value)
+	})
+	if !ok {
+		return be
+	}
+	return Literal{Value: value}
+}
+
+func (o *Optimizer) VisitCall(expr Expr) interface{} {
+	return o.call(expr.(Call))
+}
+
+func (o *Optimizer) VisitGet(expr Expr) interface{} {
+	ge := expr.(Get)
+	ge.Object = o.expr(ge.Object)
+	return ge
+}
+
+func (o *Optimizer) VisitGrouping(expr Expr) interface{} {
+	ge := expr.(Grouping)
+	ge.Expression = o.expr(ge.Expression)
+	if literal, ok := ge.Expression.(Literal); ok {
+		return literal
+	}
+	return ge
+}
+
+func (o *Optimizer) VisitLiteral(expr Expr) interface{} {
+	return expr
+}
+
+// VisitLogical simplifies "and" and "or" expressions whose left operand
+// is a literal, which decides whether the right one is evaluated.
+func (o *Optimizer) VisitLogical(expr Expr) interface{} {
+	le := expr.(Logical)
+	le.Left = o.expr(le.Left)
+	le.Right = o.expr(le.Right)
+	left, ok := le.Left.(Literal)
+	if !ok {
+		return le
+	}
+	if o.runtime._isTruthy(left.Value) == (le.Operator.Type == OR) {
+		return left
+	}
+	return le.Right
+}
+
+func (o *Optimizer) VisitSet(expr Expr) interface{} {
+	se := expr.(Set)
+	se.Object = o.expr(se.Object)
+	se.Value = o.expr(se.Value)
+	return se
+}
+
+func (o *Optimizer) VisitSuper(expr Expr) interface{} {
+	return expr
+}
+
+func (o *Optimizer) VisitThis(expr Expr) interface{} {
+	return expr
+}
+
+func (o *Optimizer) VisityUnary(expr Expr) interface{} {
+	ue := expr.(Unary)
+	ue.Right = o.expr(ue.Right)
+	right, ok := ue.Right.(Literal)
+	if !ok {
+		return ue
+	}
+	value, ok := o.constant(func() interface{} {
+		return o.runtime.unaryOp(ue.Operator, right.Value)
+	})
+	if !ok {
+		return ue
+	}
+	return Literal{Value: value}
+}
+
+func (o *Optimizer) VisitVariable(expr Expr) interface{} {
+	return expr
+}
+
+func (o *Optimizer) VisitAssertStmt(stmt Stmt) interface{} {
+	as := stmt.(AssertStmt)
+	as.Condition = o.expr(as.Condition)
+	as.Message = o.expr(as.Message)
+	return as
+}
+
+func (o *Optimizer) VisitBlockStmt(stmt Stmt) interface{} {
+	bs := stmt.(BlockStmt)
+	bs.Statements = o.stmts(bs.Statements)
+	return bs
+}
+
+func (o *Optimizer) VisitClassStmt(stmt Stmt) interface{} {
+	cs := stmt.(ClassStmt)
+	methods := make([]FunctionStmt, len(cs.Methods))
+	for idx, method := range cs.Methods {
+		methods[idx] = o.function(method)
+	}
+	cs.Methods = methods
+	return cs
+}
+
+func (o *Optimizer) VisitExpressionStmt(stmt Stmt) interface{} {
+	es := stmt.(ExprStmt)
+	es.Expression = o.expr(es.Expression)
+	return es
+}
+
+func (o *Optimizer) VisitFunctionStmt(stmt Stmt) interface{} {
+	return o.function(stmt.(FunctionStmt))
+}
+
+// VisitIfStmt replaces an if statement whose condition is a literal with
+// the branch it always takes. The branches can't be declarations, so
+// this doesn't change which variables are in scope.
+func (o *Optimizer) VisitIfStmt(stmt Stmt) interface{} {
+	is := stmt.(IfStmt)
+	is.Condition = o.expr(is.Condition)
+	condition, ok := is.Condition.(Literal)
+	if !ok {
+		is.Then = o.branch(is.Then)
+		if is.Else != nil {
+			is.Else = o.stmt(is.Else)
+		}
+		return is
+	}
+	if o.runtime._isTruthy(condition.Value) {
+		return o.stmt(is.Then)
+	}
+	if is.Else != nil {
+		return o.stmt(is.Else)
+	}
+	return nil
+}
+
+func (o *Optimizer) VisitInterfaceStmt(stmt Stmt) interface{} {
+	return stmt
+}
+
+func (o *Optimizer) VisitPrintStmt(stmt Stmt) interface{} {
+	ps := stmt.(PrintStmt)
+	ps.Expression = o.expr(ps.Expression)
+	return ps
+}
+
+func (o *Optimizer) VisitReturnStmt(stmt Stmt) interface{} {
+	rs := stmt.(ReturnStmt)
+	rs.Value = o.expr(rs.Value)
+	return rs
+}
+
+func (o *Optimizer) VisitSelectStmt(stmt Stmt) interface{} {
+	ss := stmt.(SelectStmt)
+	cases := make([]SelectCase, len(ss.Cases))
+	for idx, sc := range ss.Cases {
+		sc.Channel = o.expr(sc.Channel)
+		sc.Value = o.expr(sc.Value)
+		sc.Body = o.stmts(sc.Body)
+		cases[idx] = sc
+	}
+	ss.Cases = cases
+	if ss.Default != nil {
+		// an empty default clause still stops select from blocking
+		ss.Default = append([]Stmt{}, o.stmts(ss.Default)...)
+	}
+	return ss
+}
+
+func (o *Optimizer) VisitSpawnStmt(stmt Stmt) interface{} {
+	ss := stmt.(SpawnStmt)
+	ss.Call = o.call(ss.Call)
+	return ss
+}
+
+func (o *Optimizer) VisitVarStmt(stmt Stmt) interface{} {
+	vs := stmt.(VariableStmt)
+	vs.Initializer = o.expr(vs.Initializer)
+	return vs
+}
+
+func (o *Optimizer) VisitWhileStmt(stmt Stmt) interface{} {
+	ws := stmt.(WhileStmt)
+	ws.Condition = o.expr(ws.Condition)
+	if condition, ok := ws.Condition.(Literal); ok && !o.runtime._isTruthy(condition.Value) {
+		return nil
+	}
+	ws.Body = o.branch(ws.Body)
+	return ws
+}
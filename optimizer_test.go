@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOptimizer_Optimize(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		expected string
+	}{
+		"arithmetic on literals is folded": {
+			in:       "print (1 + 2) * -3;",
+			expected: "(print -9)\n",
+		},
+		"string concatenation is folded": {
+			in:       `print "a" + "b" + "c";`,
+			expected: "(print \"abc\")\n",
+		},
+		"comparisons and negation are folded": {
+			in:       "print !(1 < 2);",
+			expected: "(print false)\n",
+		},
+		"groupings around literals are removed": {
+			in:       "print ((1));",
+			expected: "(print 1)\n",
+		},
+		"operators on variables are left alone": {
+			in:       "var x = 1; print x + (2 * 3);",
+			expected: "(var x 1)\n(print (+ x 6))\n",
+		},
+		"operators which would fail are left to fail at runtime": {
+			in:       `print "a" - 1;`,
+			expected: "(print (- \"a\" 1))\n",
+		},
+		"if with a false condition is removed": {
+			in:       "if (false) print 1;\nprint 2;",
+			expected: "(print 2)\n",
+		},
+		"if with a constant condition becomes the branch it takes": {
+			in:       "if (1 > 2) print 1; else print 2;\nif (\"s\") print 3; else print 4;",
+			expected: "(print 2)\n(print 3)\n",
+		},
+		"while with a false condition is removed": {
+			in:       "var x = 1; while (false) print x; print x;",
+			expected: "(var x 1)\n(print x)\n",
+		},
+		"loop bodies which optimize away are kept": {
+			in:       "var x = 1; while (x < 3) if (nil) print x;",
+			expected: "(var x 1)\n(while (< x 3)\n  (block))\n",
+		},
+		"or with a truthy literal is the literal": {
+			in:       "var x = 1; print 2 or x;",
+			expected: "(var x 1)\n(print 2)\n",
+		},
+		"or with a falsy literal is the right operand": {
+			in:       "var x = 1; print nil or x;",
+			expected: "(var x 1)\n(print x)\n",
+		},
+		"and with a falsy literal is the literal": {
+			in:       "var x = 1; print false and x;",
+			expected: "(var x 1)\n(print false)\n",
+		},
+		"and with a truthy literal is the right operand": {
+			in:       "var x = 1; print true and x;",
+			expected: "(var x 1)\n(print x)\n",
+		},
+		"logical with a variable on the left is left alone": {
+			in:       "var x = 1; print x or 1 + 1;",
+			expected: "(var x 1)\n(print (or x 2))\n",
+		},
+		"function and method bodies are optimized": {
+			in: `
+fun f() { if (false) return 1; return 2 * 3; }
+class C { m() { return this; } n() { while (false) {} return -(1); } }
+`,
+			expected: "(fun f ()\n  (return 6))\n" +
+				"(class C\n  (fun m ()\n    (return this))\n  (fun n ()\n    (return -1)))\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tokens, scanErr := (&Scanner{}).ScanTokens(tc.in)
+			if scanErr != nil {
+				t.Fatalf("scanning error in test input: %s", scanErr)
+			}
+			stmts, parseErr := (&Parser{Tokens: tokens}).Parse()
+			if parseErr != nil {
+				t.Fatalf("parsing error in test input: %s", parseErr)
+			}
+			resolveErr := (&Resolver{}).Resolve(stmts)
+			if resolveErr != nil {
+				t.Fatalf("resolution error in test input: %s", resolveErr)
+			}
+			actual := printAST((&Optimizer{}).Optimize(stmts))
+			if actual != tc.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestOptimizer_Optimize_script(t *testing.T) {
+	testCases := map[string]struct {
+		in          string
+		expected    string
+		expectedErr string
+	}{
+		"optimized programs behave the same": {
+			in: `
+var total = 0;
+for (var i = 0; i < 2 + 3; i = i + 1) {
+    if (true and i > 1) total = total + i * (10 / 5);
+    while (false) total = -1;
+}
+{
+    var inner = "in" + "ner";
+    print inner;
+}
+print total;
+`,
+			expected: "inner\n18\n",
+		},
+		"runtime errors keep their lines": {
+			in:          "print 1;\nprint (2 + 3) - \"a\";",
+			expected:    "1\n",
+			expectedErr: "runtime error on line 2",
+		},
+	}
+
+	for name, tc := range testCases {
+		for _, backend := range testBackends {
+			t.Run(name+"/"+backend.name, func(t *testing.T) {
+				tokens, scanErr := (&Scanner{}).ScanTokens(tc.in)
+				if scanErr != nil {
+					t.Fatalf("scanning error in test input: %s", scanErr)
+				}
+				stmts, parseErr := (&Parser{Tokens: tokens}).Parse()
+				if parseErr != nil {
+					t.Fatalf("parsing error in test input: %s", parseErr)
+				}
+				resolveErr := (&Resolver{}).Resolve(stmts)
+				if resolveErr != nil {
+					t.Fatalf("resolution error in test input: %s", resolveErr)
+				}
+				stdout := &bytes.Buffer{}
+				err := backend.interpret(&Interpreter{Stdout: stdout}, (&Optimizer{}).Optimize(stmts))
+				if tc.expectedErr == "" && err != nil {
+					t.Errorf("unexpected error: %s", err)
+				} else if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+					t.Errorf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				if stdout.String() != tc.expected {
+					t.Errorf("expected output %q, got %q", tc.expected, stdout.String())
+				}
+			})
+		}
+	}
+}
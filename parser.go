@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type parseError struct {
 	line int
@@ -12,9 +15,11 @@ func (pe parseError) error() error {
 }
 
 type Parser struct {
-	Tokens                    []Token
-	current                   int
-	uniqueVarReferenceCounter int
+	Tokens  []Token
+	current int
+	// doc comments, keyed by the index in Tokens of the token which
+	// follows them
+	docs map[int]string
 }
 
 func (p *Parser) Parse() (returnStmts []Stmt, returnErr error) {
@@ -24,6 +29,7 @@ func (p *Parser) Parse() (returnStmts []Stmt, returnErr error) {
 		}
 	}()
 
+	p.Tokens, p.docs = extractDocComments(p.Tokens)
 	var statements []Stmt
 	for !p.isAtEnd() {
 		statements = append(statements, p.declaration())
@@ -31,6 +37,39 @@ func (p *Parser) Parse() (returnStmts []Stmt, returnErr error) {
 	return statements, nil
 }
 
+// extractDocComments removes DOC_COMMENT tokens, returning the remaining
+// tokens along with the text of each run of doc comments, keyed by the
+// index of the token following the run.
+func extractDocComments(tokens []Token) ([]Token, map[int]string) {
+	var kept []Token
+	var docs map[int]string
+	var pending []string
+	for _, tok := range tokens {
+		if tok.Type == DOC_COMMENT {
+			pending = append(pending, tok.Literal.(string))
+			continue
+		}
+		if pending != nil {
+			if docs == nil {
+				docs = make(map[int]string)
+			}
+			docs[len(kept)] = strings.Join(pending, "\n")
+			pending = nil
+		}
+		kept = append(kept, tok)
+	}
+	if docs == nil {
+		// nothing was removed
+		return tokens, nil
+	}
+	return kept, docs
+}
+
+// docComment returns the doc comment preceding the current token, if any.
+func (p *Parser) docComment() string {
+	return p.docs[p.current]
+}
+
 func (p *Parser) parseError(line int, msg string) {
 	panic(parseError{
 		line: line,
@@ -39,11 +78,22 @@ func (p *Parser) parseError(line int, msg string) {
 }
 
 func (p *Parser) declaration() Stmt {
+	doc := p.docComment()
 	if p.match(CLASS) {
-		return p.classDeclaration()
+		return p.classDeclaration(doc)
+	}
+	// "data" is only special right before "class", so it can still be
+	// used as an ordinary identifier
+	if p._check(IDENTIFIER) && p.peek().Lexeme == "data" && p._checkNext(CLASS) {
+		p.advance()
+		p.advance()
+		return p.dataClassDeclaration(doc)
 	}
 	if p.match(FUN) {
-		return p.funDeclaration("function")
+		return p.funDeclaration("function", doc)
+	}
+	if p.match(INTERFACE) {
+		return p.interfaceDeclaration()
 	}
 	if p.match(VAR) {
 		return p.varDeclaration()
@@ -51,70 +101,205 @@ func (p *Parser) declaration() Stmt {
 	return p.statement()
 }
 
-func (p *Parser) classDeclaration() Stmt {
+func (p *Parser) classDeclaration(doc string) Stmt {
 	name := p.consume(IDENTIFIER, "Expect class name.")
 
 	var superclass *Variable
 	if p.match(LESS) {
 		p.consume(IDENTIFIER, "Expect superclass name after '<'.")
-		superclass = &Variable{
-			Name:   p.previous(),
-			Unique: p.nextUniqueVarRef(),
-		}
+		superclass = &Variable{Name: p.previous(), Binding: &Binding{}}
 	}
 
+	interfaces := p.implementsClause()
+
 	p.consume(LEFT_BRACE, "Expect '{' before class body.")
+	methods := p.classBody()
+
+	return ClassStmt{
+		Name:       name,
+		Superclass: superclass,
+		Methods:    methods,
+		Interfaces: interfaces,
+		Doc:        doc,
+	}
+}
+
+// implementsClause parses an optional "implements A, B, ..." clause.
+func (p *Parser) implementsClause() []Variable {
+	var interfaces []Variable
+	if p.match(IMPLEMENTS) {
+		for {
+			p.consume(IDENTIFIER, "Expect interface name.")
+			interfaces = append(interfaces, Variable{Name: p.previous(), Binding: &Binding{}})
+			if !p.match(COMMA) {
+				break
+			}
+		}
+	}
+	return interfaces
+}
+
+func (p *Parser) interfaceDeclaration() Stmt {
+	name := p.consume(IDENTIFIER, "Expect interface name.")
+	p.consume(LEFT_BRACE, "Expect '{' before interface body.")
+
+	var methods []InterfaceMethod
+	for !p._check(RIGHT_BRACE) && !p.isAtEnd() {
+		methodName := p.consume(IDENTIFIER, "Expect method name.")
+		p.consume(LEFT_PAREN, "Expect '(' after method name.")
+		params, paramTypes := p.parameterList("parameter")
+		p.consume(RIGHT_PAREN, "Expect ')' after parameters.")
+		returnType := p.optionalTypeAnnotation()
+		p.consume(SEMICOLON, "Expect ';' after interface method.")
+		methods = append(methods, InterfaceMethod{
+			Name:       methodName,
+			Params:     params,
+			ParamTypes: paramTypes,
+			ReturnType: returnType,
+		})
+	}
+	p.consume(RIGHT_BRACE, "Expect '}' after interface body.")
+
+	return InterfaceStmt{
+		Name:    name,
+		Methods: methods,
+	}
+}
 
+func (p *Parser) classBody() []FunctionStmt {
 	var methods []FunctionStmt
 	for !p._check(RIGHT_BRACE) && p.current < len(p.Tokens) {
-		methods = append(methods, p.funDeclaration("method"))
+		methods = append(methods, p.funDeclaration("method", p.docComment()))
 	}
 
 	p.consume(RIGHT_BRACE, "Expect '}' after class body.")
+	return methods
+}
+
+// dataClassDeclaration parses the rest of a data class declaration:
+//
+//	data class Point(x, y) { ...methods... }
+//
+// The class gets a synthetic init() method assigning each field from
+// the parameter of the same name.
+func (p *Parser) dataClassDeclaration(doc string) Stmt {
+	name := p.consume(IDENTIFIER, "Expect class name.")
+	p.consume(LEFT_PAREN, "Expect '(' after data class name.")
+	fields, fieldTypes := p.parameterList("field")
+	p.consume(RIGHT_PAREN, "Expect ')' after data class fields.")
+
+	if p._check(LESS) {
+		p.parseError(name.Line, "Data classes can't have a superclass.")
+	}
+	interfaces := p.implementsClause()
+
+	p.consume(LEFT_BRACE, "Expect '{' before class body.")
+	methods := p.classBody()
+	for _, method := range methods {
+		if method.Name.Lexeme == "init" {
+			p.parseError(method.Name.Line, "Data classes can't declare an 'init' method.")
+		}
+	}
+
+	// this.field = field;
+	var initBody []Stmt
+	for _, field := range fields {
+		initBody = append(initBody, ExprStmt{Set{
+			Object: This{Keyword: Token{Type: THIS, Lexeme: "this", Line: field.Line}, Binding: &Binding{}},
+			Name:   field,
+			Value:  Variable{Name: field, Binding: &Binding{}},
+		}})
+	}
+	initializer := FunctionStmt{
+		Name:       Token{Type: IDENTIFIER, Lexeme: "init", Line: name.Line},
+		Params:     fields,
+		ParamTypes: fieldTypes,
+		Body:       initBody,
+	}
 
 	return ClassStmt{
 		Name:       name,
-		Superclass: superclass,
-		Methods:    methods,
+		Methods:    append([]FunctionStmt{initializer}, methods...),
+		Interfaces: interfaces,
+		IsData:     true,
+		Fields:     fields,
+		Doc:        doc,
 	}
 }
 
-func (p *Parser) funDeclaration(kind string) FunctionStmt {
+func (p *Parser) funDeclaration(kind, doc string) FunctionStmt {
 	// grab function name
-	name := p.consume(IDENTIFIER, fmt.Sprintf("Expect %s name.", kind))
+	var name Token
+	if kind == "method" && p.match(PRIVATE_IDENTIFIER) {
+		name = p.previous()
+	} else {
+		name = p.consume(IDENTIFIER, fmt.Sprintf("Expect %s name.", kind))
+	}
 
 	// grab function prototype
 	p.consume(LEFT_PAREN, fmt.Sprintf("Expect '(' after %s name.", kind))
+	params, paramTypes := p.parameterList("parameter")
+	p.consume(RIGHT_PAREN, "Expect ')' after parameters.")
+	returnType := p.optionalTypeAnnotation()
+
+	// grab function body
+	p.consume(LEFT_BRACE, fmt.Sprintf("Expect '{' before %s body.", kind))
+	body := p.block()
+
+	return FunctionStmt{
+		Name:       name,
+		Params:     params,
+		ParamTypes: paramTypes,
+		ReturnType: returnType,
+		Body:       body,
+		Doc:        doc,
+	}
+}
+
+// parameterList parses a possibly-empty, comma-separated list of names,
+// each with an optional type annotation, stopping before the closing ')'.
+// The returned types are nil if none of the names were annotated.
+func (p *Parser) parameterList(kind string) ([]Token, []*Token) {
 	var params []Token
+	var types []*Token
 	if !p._check(RIGHT_PAREN) {
 		for {
-			param := p.consume(IDENTIFIER, "Expect parameter name")
+			param := p.consume(IDENTIFIER, fmt.Sprintf("Expect %s name", kind))
 			params = append(params, param)
+			if typ := p.optionalTypeAnnotation(); typ != nil {
+				for len(types) < len(params)-1 {
+					types = append(types, nil)
+				}
+				types = append(types, typ)
+			}
 			if !p.match(COMMA) {
 				break
 			}
 		}
 	}
-	p.consume(RIGHT_PAREN, "Expect ')' after parameters.")
-
-	// grab function body
-	p.consume(LEFT_BRACE, fmt.Sprintf("Expect '{' before %s body.", kind))
-	body := p.block()
-
-	return FunctionStmt{
-		Name:   name,
-		Params: params,
-		Body:   body,
+	for types != nil && len(types) < len(params) {
+		types = append(types, nil)
 	}
+	return params, types
 }
 
-func (p *Parser) nextUniqueVarRef() int {
-	p.uniqueVarReferenceCounter++
-	return p.uniqueVarReferenceCounter - 1
+// optionalTypeAnnotation parses a ": type" annotation, if there is one.
+func (p *Parser) optionalTypeAnnotation() *Token {
+	if !p.match(COLON) {
+		return nil
+	}
+	// "nil" is a keyword, but also the name of a type
+	if p.match(NIL) {
+		typ := p.previous()
+		return &typ
+	}
+	typ := p.consume(IDENTIFIER, "Expect type name after ':'.")
+	return &typ
 }
 
 func (p *Parser) varDeclaration() Stmt {
 	name := p.consume(IDENTIFIER, "Expect variable name.")
+	typ := p.optionalTypeAnnotation()
 	var initialializer Expr
 	if p.match(EQUAL) {
 		initialializer = p.expression()
@@ -123,11 +308,15 @@ func (p *Parser) varDeclaration() Stmt {
 	p.consume(SEMICOLON, "Expect ';' after variable declaration.")
 	return VariableStmt{
 		Name:        name,
+		Type:        typ,
 		Initializer: initialializer,
 	}
 }
 
 func (p *Parser) statement() Stmt {
+	if p.match(ASSERT) {
+		return p.assertStatement()
+	}
 	if p.match(FOR) {
 		return p.forStatement()
 	}
@@ -140,6 +329,12 @@ func (p *Parser) statement() Stmt {
 	if p.match(RETURN) {
 		return p.returnStatement()
 	}
+	if p.match(SELECT) {
+		return p.selectStatement()
+	}
+	if p.match(SPAWN) {
+		return p.spawnStatement()
+	}
 	if p.match(WHILE) {
 		return p.whileStatement()
 	}
@@ -240,6 +435,24 @@ func (p *Parser) ifStatement() Stmt {
 	}
 }
 
+func (p *Parser) assertStatement() Stmt {
+	keyword := p.previous()
+	start := p.current
+	condition := p.expression()
+	source := sourceText(p.Tokens[start:p.current])
+	var message Expr
+	if p.match(COMMA) {
+		message = p.expression()
+	}
+	p.consume(SEMICOLON, "Expect ';' after assertion.")
+	return AssertStmt{
+		Keyword:   keyword,
+		Condition: condition,
+		Message:   message,
+		Source:    source,
+	}
+}
+
 func (p *Parser) printStatement() Stmt {
 	value := p.expression()
 	p.consume(SEMICOLON, "Expect ';' after value.")
@@ -260,6 +473,83 @@ func (p *Parser) returnStatement() Stmt {
 	}
 }
 
+func (p *Parser) selectStatement() Stmt {
+	keyword := p.previous()
+	p.consume(LEFT_BRACE, "Expect '{' after 'select'.")
+
+	var cases []SelectCase
+	var dflt []Stmt
+	for !p._check(RIGHT_BRACE) && !p.isAtEnd() {
+		if p.match(DEFAULT) {
+			if dflt != nil {
+				p.parseError(p.previous().Line, "Only one 'default' clause allowed in select.")
+			}
+			p.consume(LEFT_BRACE, "Expect '{' after 'default'.")
+			// an empty default clause is still a default clause
+			dflt = append([]Stmt{}, p.block()...)
+			continue
+		}
+		p.consume(CASE, "Expect 'case' or 'default' in select.")
+		cases = append(cases, p.selectCase())
+	}
+	p.consume(RIGHT_BRACE, "Expect '}' after select clauses.")
+
+	return SelectStmt{
+		Keyword: keyword,
+		Cases:   cases,
+		Default: dflt,
+	}
+}
+
+func (p *Parser) selectCase() SelectCase {
+	sc := SelectCase{Keyword: p.previous()}
+	if p.match(VAR) {
+		name := p.consume(IDENTIFIER, "Expect variable name after 'var'.")
+		sc.Name = &name
+		p.consume(EQUAL, "Expect '=' after variable name.")
+	}
+
+	// the operation must be a direct send()/receive() method call
+	call, isCall := p.expression().(Call)
+	var method Get
+	if isCall {
+		method, isCall = call.Callee.(Get)
+	}
+	switch {
+	case isCall && method.Name.Lexeme == "send" && sc.Name == nil:
+		if len(call.Args) != 1 {
+			p.parseError(call.Paren.Line, "Expect exactly one argument to send().")
+		}
+		sc.Send = true
+		sc.Value = call.Args[0]
+	case isCall && method.Name.Lexeme == "receive":
+		if len(call.Args) != 0 {
+			p.parseError(call.Paren.Line, "Expect no arguments to receive().")
+		}
+	default:
+		p.parseError(sc.Keyword.Line, "Expect a channel send() or receive() call in select case.")
+	}
+	sc.Channel = method.Object
+
+	p.consume(LEFT_BRACE, "Expect '{' before case body.")
+	sc.Body = p.block()
+	return sc
+}
+
+func (p *Parser) spawnStatement() Stmt {
+	keyword := p.previous()
+	call, ok := p.expression().(Call)
+	if !ok {
+		p.parseError(keyword.Line, "Expect function call after 'spawn'.")
+	}
+	p.consume(SEMICOLON, "Expect ';' after spawn statement.")
+
+	return SpawnStmt{
+		Keyword: keyword,
+		Call:    call,
+	}
+}
+
 func (p *Parser) whileStatement() Stmt {
 	p.consume(LEFT_PAREN, "Expect '(' after 'while'.")
 	condition := p.expression()
@@ -300,8 +590,9 @@ func (p *Parser) assignment() Expr {
 		switch lValue := expr.(type) {
 		case Variable:
 			return Assign{
-				Name:  lValue.Name,
-				Value: rValue,
+				Name:    lValue.Name,
+				Value:   rValue,
+				Binding: lValue.Binding,
 			}
 		case Get:
 			return Set{
@@ -354,7 +645,7 @@ func (p *Parser) equality() Expr {
 
 func (p *Parser) comparison() Expr {
 	next := func() Expr { return p.term() }
-	return p._binaryExpr(next, GREATER, GREATER_EQUAL, LESS, LESS_EQUAL)
+	return p._binaryExpr(next, GREATER, GREATER_EQUAL, LESS, LESS_EQUAL, IS)
 }
 
 func (p *Parser) term() Expr {
@@ -400,8 +691,13 @@ func (p *Parser) call() Expr {
 		if p.match(LEFT_PAREN) {
 			expr = p.finishCall(expr)
 		} else if p.match(DOT) {
-			name := p.consume(IDENTIFIER, "Expect property name after '.'.")
-			expr = Get{expr, name}
+			var name Token
+			if p.match(PRIVATE_IDENTIFIER) {
+				name = p.previous()
+			} else {
+				name = p.consume(IDENTIFIER, "Expect property name after '.'.")
+			}
+			expr = Get{Object: expr, Name: name, Cache: &MethodCache{}}
 		} else {
 			break
 		}
@@ -412,11 +708,21 @@ func (p *Parser) call() Expr {
 
 func (p *Parser) finishCall(callee Expr) Expr {
 	var args []Expr
+	var named []NamedArg
 	if !p._check(RIGHT_PAREN) {
 		// keep adding args as long as we find an arg with a
 		// trailing comma
 		for {
-			args = append(args, p.expression())
+			if p._check(IDENTIFIER) && p._checkNext(COLON) {
+				name := p.advance()
+				p.advance() // consume the ':'
+				named = append(named, NamedArg{Name: name, Value: p.expression()})
+			} else {
+				if len(named) > 0 {
+					p.parseError(p.previous().Line, "Expect named argument after a named argument.")
+				}
+				args = append(args, p.expression())
+			}
 			if !p.match(COMMA) {
 				break
 			}
@@ -428,6 +734,7 @@ func (p *Parser) finishCall(callee Expr) Expr {
 		Callee: callee,
 		Paren:  paren,
 		Args:   args,
+		Named:  named,
 	}
 }
 
@@ -453,15 +760,16 @@ func (p *Parser) primary() Expr {
 		return Super{
 			Keyword: keyword,
 			Method:  method,
+			Binding: &Binding{},
 		}
 	}
 
 	if p.match(THIS) {
-		return This{p.previous()}
+		return This{Keyword: p.previous(), Binding: &Binding{}}
 	}
 
 	if p.match(IDENTIFIER) {
-		return Variable{Name: p.previous(), Unique: p.nextUniqueVarRef()}
+		return Variable{Name: p.previous(), Binding: &Binding{}}
 	}
 
 	if p.match(LEFT_PAREN) {
@@ -516,6 +824,58 @@ func (p *Parser) _check(typ TokenType) bool {
 	return p.peek().Type == typ
 }
 
+func (p *Parser) _checkNext(typ TokenType) bool {
+	if p.current+1 >= len(p.Tokens) {
+		return false
+	}
+	return p.Tokens[p.current+1].Type == typ
+}
+
 func (p *Parser) isAtEnd() bool {
 	return p.current >= len(p.Tokens)
 }
+
+// sourceText reconstructs source code from tokens, with conventional
+// spacing rather than the spacing of the original.
+func sourceText(tokens []Token) string {
+	var sb strings.Builder
+	for idx, tok := range tokens {
+		if idx > 0 && spaceBetween(tokens[idx-1], tok, idx == 1 || !isOperand(tokens[idx-2])) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(tok.Lexeme)
+	}
+	return sb.String()
+}
+
+// spaceBetween reports whether a space goes between prev and next. A '-'
+// or '!' is taken to be a unary operator when it follows an operator, in
+// which case prevIsUnary is set.
+func spaceBetween(prev, next Token, prevIsUnary bool) bool {
+	switch prev.Type {
+	case LEFT_PAREN, DOT, BANG:
+		return false
+	case MINUS:
+		if prevIsUnary {
+			return false
+		}
+	}
+	switch next.Type {
+	case RIGHT_PAREN, COMMA, DOT, SEMICOLON, COLON:
+		return false
+	case LEFT_PAREN:
+		return !isOperand(prev)
+	}
+	return true
+}
+
+// isOperand reports whether tok ends an operand, as opposed to being an
+// operator or an opening bracket.
+func isOperand(tok Token) bool {
+	switch tok.Type {
+	case IDENTIFIER, PRIVATE_IDENTIFIER, STRING, NUMBER, RIGHT_PAREN,
+		TRUE, FALSE, NIL, THIS, SUPER:
+		return true
+	}
+	return false
+}
@@ -152,6 +152,19 @@ func TestParser_Parse(t *testing.T) {
 				Initializer: Literal{Value: 1},
 			}},
 		},
+		"annotated variable declaration": {
+			inTokens: []Token{
+				{Type: VAR},
+				{Type: IDENTIFIER, Lexeme: "myVar"},
+				{Type: COLON},
+				{Type: IDENTIFIER, Lexeme: "number"},
+				{Type: SEMICOLON},
+			},
+			expected: []Stmt{VariableStmt{
+				Name: Token{Type: IDENTIFIER, Lexeme: "myVar"},
+				Type: &Token{Type: IDENTIFIER, Lexeme: "number"},
+			}},
+		},
 		"block": {
 			inTokens: []Token{
 				{Type: LEFT_BRACE},
@@ -187,9 +200,9 @@ func TestParser_Parse(t *testing.T) {
 			},
 			expected: []Stmt{
 				IfStmt{
-					Condition: Variable{Name: Token{Type: IDENTIFIER, Lexeme: "a"}, Unique: 0},
-					Then:      PrintStmt{Variable{Name: Token{Type: IDENTIFIER, Lexeme: "a"}, Unique: 1}},
-					Else:      PrintStmt{Variable{Name: Token{Type: IDENTIFIER, Lexeme: "b"}, Unique: 2}},
+					Condition: Variable{Name: Token{Type: IDENTIFIER, Lexeme: "a"}, Binding: &Binding{}},
+					Then:      PrintStmt{Variable{Name: Token{Type: IDENTIFIER, Lexeme: "a"}, Binding: &Binding{}}},
+					Else:      PrintStmt{Variable{Name: Token{Type: IDENTIFIER, Lexeme: "b"}, Binding: &Binding{}}},
 				},
 			},
 		},
@@ -207,10 +220,81 @@ func TestParser_Parse(t *testing.T) {
 			expected: []Stmt{
 				WhileStmt{
 					Condition: Literal{Value: true},
-					Body:      PrintStmt{Expression: Variable{Name: Token{Type: IDENTIFIER, Lexeme: "a"}}},
+					Body:      PrintStmt{Expression: Variable{Name: Token{Type: IDENTIFIER, Lexeme: "a"}, Binding: &Binding{}}},
+				},
+			},
+		},
+		"data class can't declare init": {
+			// data class P(a) { init() {} }
+			inTokens: []Token{
+				{Type: IDENTIFIER, Lexeme: "data"},
+				{Type: CLASS},
+				{Type: IDENTIFIER, Lexeme: "P"},
+				{Type: LEFT_PAREN},
+				{Type: IDENTIFIER, Lexeme: "a"},
+				{Type: RIGHT_PAREN},
+				{Type: LEFT_BRACE},
+				{Type: IDENTIFIER, Lexeme: "init"},
+				{Type: LEFT_PAREN},
+				{Type: RIGHT_PAREN},
+				{Type: LEFT_BRACE},
+				{Type: RIGHT_BRACE},
+				{Type: RIGHT_BRACE},
+			},
+			errExpected:    true,
+			expectedErrStr: "Data classes can't declare an 'init' method",
+		},
+		"assert with a message": {
+			inTokens: []Token{
+				{Type: ASSERT, Lexeme: "assert"},
+				{Type: MINUS, Lexeme: "-"},
+				{Type: IDENTIFIER, Lexeme: "a"},
+				{Type: LESS, Lexeme: "<"},
+				{Type: NUMBER, Lexeme: "1", Literal: int64(1)},
+				{Type: COMMA, Lexeme: ","},
+				{Type: STRING, Lexeme: `"msg"`, Literal: "msg"},
+				{Type: SEMICOLON, Lexeme: ";"},
+			},
+			expected: []Stmt{
+				AssertStmt{
+					Keyword: Token{Type: ASSERT, Lexeme: "assert"},
+					Condition: Binary{
+						Left: Unary{
+							Operator: Token{Type: MINUS, Lexeme: "-"},
+							Right:    Variable{Name: Token{Type: IDENTIFIER, Lexeme: "a"}, Binding: &Binding{}},
+						},
+						Operator: Token{Type: LESS, Lexeme: "<"},
+						Right:    Literal{Value: int64(1)},
+					},
+					Message: Literal{Value: "msg"},
+					Source:  "-a < 1",
 				},
 			},
 		},
+		"spawn requires a call": {
+			inTokens: []Token{
+				{Type: SPAWN},
+				{Type: IDENTIFIER, Lexeme: "f"},
+				{Type: SEMICOLON},
+			},
+			errExpected:    true,
+			expectedErrStr: "Expect function call after 'spawn'",
+		},
+		"select case must send or receive": {
+			inTokens: []Token{
+				{Type: SELECT},
+				{Type: LEFT_BRACE},
+				{Type: CASE},
+				{Type: IDENTIFIER, Lexeme: "f"},
+				{Type: LEFT_PAREN},
+				{Type: RIGHT_PAREN},
+				{Type: LEFT_BRACE},
+				{Type: RIGHT_BRACE},
+				{Type: RIGHT_BRACE},
+			},
+			errExpected:    true,
+			expectedErrStr: "Expect a channel send() or receive() call",
+		},
 	}
 
 	for name, tc := range testCases {
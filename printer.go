@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// astPrinter renders a program as s-expressions, one statement per line,
+// with the statements nested in blocks and bodies indented beneath them:
+//
+//	(var x (+ 1 2))
+//	(while (< x 10)
+//	  (block
+//	    (print x)
+//	    (expr (= x (+ x 1)))))
+//
+// It's used by the -dump-ast flag, to show what the Optimizer has done.
+type astPrinter struct {
+	sb     strings.Builder
+	indent int
+}
+
+func printAST(stmts []Stmt) string {
+	p := &astPrinter{}
+	for _, stmt := range stmts {
+		p.stmt(stmt)
+		p.sb.WriteString("\n")
+	}
+	return p.sb.String()
+}
+
+func (p *astPrinter) stmt(stmt Stmt) {
+	stmt.Accept(p)
+}
+
+// open starts a nested statement list on a new line.
+func (p *astPrinter) open(head string) {
+	p.sb.WriteString("(" + head)
+	p.indent++
+}
+
+func (p *astPrinter) close() {
+	p.indent--
+	p.sb.WriteString(")")
+}
+
+func (p *astPrinter) nested(stmts ...Stmt) {
+	for _, stmt := range stmts {
+		p.sb.WriteString("\n" + strings.Repeat("  ", p.indent))
+		p.stmt(stmt)
+	}
+}
+
+func (p *astPrinter) expr(expr Expr) string {
+	return expr.Accept(p).(string)
+}
+
+func (p *astPrinter) list(head string, exprs ...Expr) string {
+	parts := []string{head}
+	for _, expr := range exprs {
+		parts = append(parts, p.expr(expr))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func tokenNames(tokens []Token) string {
+	names := make([]string, len(tokens))
+	for idx, tok := range tokens {
+		names[idx] = tok.Lexeme
+	}
+	return "(" + strings.Join(names, " ") + ")"
+}
+
+func (p *astPrinter) VisitAssign(expr Expr) interface{} {
+	ae := expr.(Assign)
+	return "(= " + ae.Name.Lexeme + " " + p.expr(ae.Value) + ")"
+}
+
+func (p *astPrinter) VisitBinary(expr Expr) interface{} {
+	be := expr.(Binary)
+	return p.list(be.Operator.Lexeme, be.Left, be.Right)
+}
+
+func (p *astPrinter) VisitCall(expr Expr) interface{} {
+	ce := expr.(Call)
+	s := p.list("call", append([]Expr{ce.Callee}, ce.Args...)...)
+	for _, arg := range ce.Named {
+		s = s[:len(s)-1] + " " + arg.Name.Lexeme + ": " + p.expr(arg.Value) + ")"
+	}
+	return s
+}
+
+func (p *astPrinter) VisitGet(expr Expr) interface{} {
+	ge := expr.(Get)
+	return "(. " + p.expr(ge.Object) + " " + ge.Name.Lexeme + ")"
+}
+
+func (p *astPrinter) VisitGrouping(expr Expr) interface{} {
+	return p.list("group", expr.(Grouping).Expression)
+}
+
+func (p *astPrinter) VisitLiteral(expr Expr) interface{} {
+	switch value := expr.(Literal).Value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return fmt.Sprintf("%q", value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+func (p *astPrinter) VisitLogical(expr Expr) interface{} {
+	le := expr.(Logical)
+	return p.list(le.Operator.Lexeme, le.Left, le.Right)
+}
+
+func (p *astPrinter) VisitSet(expr Expr) interface{} {
+	se := expr.(Set)
+	return "(= (. " + p.expr(se.Object) + " " + se.Name.Lexeme + ") " + p.expr(se.Value) + ")"
+}
+
+func (p *astPrinter) VisitSuper(expr Expr) interface{} {
+	return "(super " + expr.(Super).Method.Lexeme + ")"
+}
+
+func (p *astPrinter) VisitThis(expr Expr) interface{} {
+	return "this"
+}
+
+func (p *astPrinter) VisityUnary(expr Expr) interface{} {
+	ue := expr.(Unary)
+	return p.list(ue.Operator.Lexeme, ue.Right)
+}
+
+func (p *astPrinter) VisitVariable(expr Expr) interface{} {
+	return expr.(Variable).Name.Lexeme
+}
+
+func (p *astPrinter) VisitAssertStmt(stmt Stmt) interface{} {
+	as := stmt.(AssertStmt)
+	if as.Message == nil {
+		p.sb.WriteString(p.list("assert", as.Condition))
+	} else {
+		p.sb.WriteString(p.list("assert", as.Condition, as.Message))
+	}
+	return nil
+}
+
+func (p *astPrinter) VisitBlockStmt(stmt Stmt) interface{} {
+	p.open("block")
+	p.nested(stmt.(BlockStmt).Statements...)
+	p.close()
+	return nil
+}
+
+func (p *astPrinter) VisitClassStmt(stmt Stmt) interface{} {
+	cs := stmt.(ClassStmt)
+	head := "class " + cs.Name.Lexeme
+	if cs.IsData {
+		head = "data " + head + " " + tokenNames(cs.Fields)
+	}
+	if cs.Superclass != nil {
+		head += " < " + cs.Superclass.Name.Lexeme
+	}
+	for _, iface := range cs.Interfaces {
+		head += " : " + iface.Name.Lexeme
+	}
+	p.open(head)
+	for _, method := range cs.Methods {
+		p.nested(method)
+	}
+	p.close()
+	return nil
+}
+
+func (p *astPrinter) VisitExpressionStmt(stmt Stmt) interface{} {
+	p.sb.WriteString(p.list("expr", stmt.(ExprStmt).Expression))
+	return nil
+}
+
+func (p *astPrinter) VisitFunctionStmt(stmt Stmt) interface{} {
+	fs := stmt.(FunctionStmt)
+	p.open("fun " + fs.Name.Lexeme + " " + tokenNames(fs.Params))
+	p.nested(fs.Body...)
+	p.close()
+	return nil
+}
+
+func (p *astPrinter) VisitIfStmt(stmt Stmt) interface{} {
+	is := stmt.(IfStmt)
+	p.open("if " + p.expr(is.Condition))
+	p.nested(is.Then)
+	if is.Else != nil {
+		p.nested(is.Else)
+	}
+	p.close()
+	return nil
+}
+
+func (p *astPrinter) VisitInterfaceStmt(stmt Stmt) interface{} {
+	is := stmt.(InterfaceStmt)
+	p.sb.WriteString("(interface " + is.Name.Lexeme)
+	for _, method := range is.Methods {
+		p.sb.WriteString(" (" + method.Name.Lexeme + " " + tokenNames(method.Params) + ")")
+	}
+	p.sb.WriteString(")")
+	return nil
+}
+
+func (p *astPrinter) VisitPrintStmt(stmt Stmt) interface{} {
+	p.sb.WriteString(p.list("print", stmt.(PrintStmt).Expression))
+	return nil
+}
+
+func (p *astPrinter) VisitReturnStmt(stmt Stmt) interface{} {
+	rs := stmt.(ReturnStmt)
+	if rs.Value == nil {
+		p.sb.WriteString("(return)")
+	} else {
+		p.sb.WriteString(p.list("return", rs.Value))
+	}
+	return nil
+}
+
+func (p *astPrinter) VisitSelectStmt(stmt Stmt) interface{} {
+	ss := stmt.(SelectStmt)
+	p.open("select")
+	for _, sc := range ss.Cases {
+		p.sb.WriteString("\n" + strings.Repeat("  ", p.indent))
+		switch {
+		case sc.Send:
+			p.open("send " + p.expr(sc.Channel) + " " + p.expr(sc.Value))
+		case sc.Name != nil:
+			p.open("receive " + p.expr(sc.Channel) + " " + sc.Name.Lexeme)
+		default:
+			p.open("receive " + p.expr(sc.Channel))
+		}
+		p.nested(sc.Body...)
+		p.close()
+	}
+	if ss.Default != nil {
+		p.sb.WriteString("\n" + strings.Repeat("  ", p.indent))
+		p.open("default")
+		p.nested(ss.Default...)
+		p.close()
+	}
+	p.close()
+	return nil
+}
+
+func (p *astPrinter) VisitSpawnStmt(stmt Stmt) interface{} {
+	p.sb.WriteString(p.list("spawn", stmt.(SpawnStmt).Call))
+	return nil
+}
+
+func (p *astPrinter) VisitVarStmt(stmt Stmt) interface{} {
+	vs := stmt.(VariableStmt)
+	if vs.Initializer == nil {
+		p.sb.WriteString("(var " + vs.Name.Lexeme + ")")
+	} else {
+		p.sb.WriteString("(var " + vs.Name.Lexeme + " " + p.expr(vs.Initializer) + ")")
+	}
+	return nil
+}
+
+func (p *astPrinter) VisitWhileStmt(stmt Stmt) interface{} {
+	ws := stmt.(WhileStmt)
+	p.open("while " + p.expr(ws.Condition))
+	p.nested(ws.Body)
+	p.close()
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// List is a read-only sequence of values, as returned by the reflection
+// builtins fields() and methods().
+type List struct {
+	Elements []interface{}
+}
+
+func (l *List) String() string {
+	parts := make([]string, len(l.Elements))
+	for idx, element := range l.Elements {
+		parts[idx] = fmt.Sprint(element)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func (l *List) Get(name Token) (interface{}, error) {
+	switch name.Lexeme {
+	case "length":
+		return nativeFunction{name: "length", fn: func(*Interpreter, []interface{}) (interface{}, error) {
+			return int64(len(l.Elements)), nil
+		}}, nil
+	case "get":
+		return nativeFunction{name: "get", arity: 1, fn: l.get}, nil
+	}
+	return nil, fmt.Errorf("Undefined property %q.", name.Lexeme)
+}
+
+func (l *List) get(i *Interpreter, args []interface{}) (interface{}, error) {
+	index, ok := toInt(args[0])
+	if !ok || index < 0 || index >= int64(len(l.Elements)) {
+		return nil, fmt.Errorf("List index %v out of range.", args[0])
+	}
+	return l.Elements[index], nil
+}
+
+func stringList(values []string) *List {
+	list := &List{Elements: make([]interface{}, len(values))}
+	for idx, value := range values {
+		list.Elements[idx] = value
+	}
+	return list
+}
+
+// reflectionBuiltins let scripts inspect and modify instances and classes
+// by name, e.g. to write generic serialization or debugging helpers.
+// Private ("#name") members can't be reached this way.
+var reflectionBuiltins = []nativeFunction{
+	{name: "typeOf", arity: 1, fn: reflectTypeOf},
+	{name: "classOf", arity: 1, fn: reflectClassOf},
+	{name: "superclassOf", arity: 1, fn: reflectSuperclassOf},
+	{name: "fields", arity: 1, fn: reflectFields},
+	{name: "methods", arity: 1, fn: reflectMethods},
+	{name: "hasField", arity: 2, fn: reflectHasField},
+	{name: "getField", arity: 2, fn: reflectGetField},
+	{name: "setField", arity: 3, fn: reflectSetField},
+	{name: "deleteField", arity: 2, fn: reflectDeleteField},
+}
+
+// typeName returns the name typeOf() uses for the type of value.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case *Class:
+		return "class"
+	case *Interface:
+		return "interface"
+	case *Instance:
+		return "instance"
+	case *Channel:
+		return "channel"
+	case *List:
+		return "list"
+	case *HashSet:
+		return "set"
+	case *StringBuilder:
+		return "stringBuilder"
+	case Callable:
+		return "function"
+	}
+	if isNumber(value) {
+		return "number"
+	}
+	return "unknown"
+}
+
+func reflectTypeOf(i *Interpreter, args []interface{}) (interface{}, error) {
+	return typeName(args[0]), nil
+}
+
+func reflectClassOf(i *Interpreter, args []interface{}) (interface{}, error) {
+	inst, err := instanceArg("classOf", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return inst.Class, nil
+}
+
+func reflectSuperclassOf(i *Interpreter, args []interface{}) (interface{}, error) {
+	class, ok := args[0].(*Class)
+	if !ok {
+		return nil, fmt.Errorf("superclassOf() expects a class, got %s.", typeName(args[0]))
+	}
+	if class.Superclass == nil {
+		return nil, nil
+	}
+	return class.Superclass, nil
+}
+
+// reflectFields returns the sorted names of an instance's public fields.
+func reflectFields(i *Interpreter, args []interface{}) (interface{}, error) {
+	inst, err := instanceArg("fields", args[0])
+	if err != nil {
+		return nil, err
+	}
+	inst.mu.RLock()
+	names := make([]string, 0, len(inst.Fields))
+	for name := range inst.Fields {
+		names = append(names, name)
+	}
+	inst.mu.RUnlock()
+	sort.Strings(names)
+	return stringList(names), nil
+}
+
+// reflectMethods returns the sorted names of the public methods of a class
+// (or of an instance's class), including inherited ones.
+func reflectMethods(i *Interpreter, args []interface{}) (interface{}, error) {
+	class, ok := args[0].(*Class)
+	if inst, isInstance := args[0].(*Instance); isInstance {
+		class, ok = inst.Class, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("methods() expects a class or instance, got %s.", typeName(args[0]))
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for ; class != nil; class = class.Superclass {
+		for name := range class.Methods {
+			if !seen[name] && !strings.HasPrefix(name, "#") {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return stringList(names), nil
+}
+
+func reflectHasField(i *Interpreter, args []interface{}) (interface{}, error) {
+	inst, name, err := fieldArgs("hasField", args)
+	if err != nil {
+		return nil, err
+	}
+	inst.mu.RLock()
+	defer inst.mu.RUnlock()
+	_, found := inst.Fields[name]
+	return found, nil
+}
+
+func reflectGetField(i *Interpreter, args []interface{}) (interface{}, error) {
+	inst, name, err := fieldArgs("getField", args)
+	if err != nil {
+		return nil, err
+	}
+	inst.mu.RLock()
+	defer inst.mu.RUnlock()
+	value, found := inst.Fields[name]
+	if !found {
+		return nil, fmt.Errorf("Undefined field %q.", name)
+	}
+	return value, nil
+}
+
+func reflectSetField(i *Interpreter, args []interface{}) (interface{}, error) {
+	inst, name, err := fieldArgs("setField", args)
+	if err != nil {
+		return nil, err
+	}
+	added, err := inst.Set(Token{Lexeme: name}, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if added {
+		i.allocate(i.callLine, fieldSize)
+	}
+	return args[2], nil
+}
+
+// reflectDeleteField removes a field, returning whether it was present.
+func reflectDeleteField(i *Interpreter, args []interface{}) (interface{}, error) {
+	inst, name, err := fieldArgs("deleteField", args)
+	if err != nil {
+		return nil, err
+	}
+	if inst.Class.IsData && inst.Class.hasField(name) {
+		return nil, errDataField(inst.Class, name)
+	}
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	_, found := inst.Fields[name]
+	delete(inst.Fields, name)
+	return found, nil
+}
+
+func instanceArg(fn string, value interface{}) (*Instance, error) {
+	inst, ok := value.(*Instance)
+	if !ok {
+		return nil, fmt.Errorf("%s() expects an instance, got %s.", fn, typeName(value))
+	}
+	return inst, nil
+}
+
+var errPrivateReflection = errors.New("Private members can't be accessed by name.")
+
+// fieldArgs checks the (instance, field name) arguments shared by the
+// field builtins.
+func fieldArgs(fn string, args []interface{}) (*Instance, string, error) {
+	inst, err := instanceArg(fn, args[0])
+	if err != nil {
+		return nil, "", err
+	}
+	name, ok := args[1].(string)
+	if !ok {
+		return nil, "", fmt.Errorf("%s() expects a field name string, got %s.", fn, typeName(args[1]))
+	}
+	if strings.HasPrefix(name, "#") {
+		return nil, "", errPrivateReflection
+	}
+	return inst, name, nil
+}
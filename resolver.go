@@ -8,6 +8,10 @@ type scope struct {
 	declared   map[string]int
 	defined    map[string]int
 	referenced map[string]bool
+	// each variable's slot in the environment the interpreter creates
+	// for the scope, in order of declaration
+	slots map[string]int
+	size  int
 }
 
 func (s *scope) containsKey(key string) bool {
@@ -18,6 +22,8 @@ func (s *scope) containsKey(key string) bool {
 func (s *scope) declare(key string, line int) {
 	s.ensureInit()
 	s.declared[key] = line
+	s.slots[key] = s.size
+	s.size++
 }
 
 func (s *scope) isDeclared(key string) (bool, int) {
@@ -60,6 +66,9 @@ func (s *scope) ensureInit() {
 	if s.referenced == nil {
 		s.referenced = make(map[string]bool)
 	}
+	if s.slots == nil {
+		s.slots = make(map[string]int)
+	}
 }
 
 type resolutionError struct {
@@ -88,9 +97,11 @@ const (
 	CLASSCLASS    = ClassType(2)
 )
 
+// Resolver checks the scoping rules of a program, and records in the
+// Binding of each reference to a local variable where the interpreter
+// will find it.
 type Resolver struct {
 	scopes              []*scope
-	interpreter         *Interpreter
 	currentFunctionType FunctionType
 	currentClassType    ClassType
 }
@@ -98,7 +109,11 @@ type Resolver struct {
 func (r *Resolver) Resolve(stmts []Stmt) (returnErr error) {
 	defer func() {
 		if r := recover(); r != nil {
-			returnErr = r.(resolutionError).error()
+			resErr, ok := r.(resolutionError)
+			if !ok {
+				panic(r)
+			}
+			returnErr = resErr.error()
 		}
 	}()
 
@@ -133,6 +148,11 @@ func (r *Resolver) resolveFunction(fStmt FunctionStmt, typ FunctionType) {
 	r.currentFunctionType = typ
 
 	r.beginScope()
+	if typ == METHOD || typ == INITIALIZER {
+		r.peekScope().declare("this", fStmt.Name.Line)
+		r.peekScope().define("this", fStmt.Name.Line)
+		r.peekScope().size++ // ownerSlot
+	}
 	for _, param := range fStmt.Params {
 		r.declare(param)
 		r.define(param)
@@ -142,11 +162,19 @@ func (r *Resolver) resolveFunction(fStmt FunctionStmt, typ FunctionType) {
 	r.currentFunctionType = enclosingFunctionType
 }
 
-func (r *Resolver) resolveLocal(expr Expr, name Token) {
+func (r *Resolver) resolveLocal(binding *Binding, name Token) {
 	for depth := 0; depth < len(r.scopes); depth++ {
 		idx := len(r.scopes) - depth - 1
 		if r.scopes[idx].containsKey(name.Lexeme) {
-			r.interpreter.Resolve(expr, depth)
+			if binding == nil {
+				// e.g. a node built by hand rather than by the parser
+				r.resolveError(name.Line, fmt.Sprintf("Reference to local %q has no Binding to record it in.", name.Lexeme))
+			}
+			*binding = Binding{
+				Local: true,
+				Depth: depth,
+				Slot:  r.scopes[idx].slots[name.Lexeme],
+			}
 			r.scopes[idx].reference(name.Lexeme)
 			return
 		}
@@ -196,46 +224,97 @@ func (r *Resolver) define(name Token) {
 	r.peekScope().define(name.Lexeme, name.Line)
 }
 
-func (r *Resolver) VisitExpressionStmt(stmt Stmt) {
+func (r *Resolver) VisitExpressionStmt(stmt Stmt) interface{} {
 	eStmt := stmt.(ExprStmt)
 	r.resolveExpr(eStmt.Expression)
+	return nil
 }
 
-func (r *Resolver) VisitFunctionStmt(stmt Stmt) {
+func (r *Resolver) VisitFunctionStmt(stmt Stmt) interface{} {
 	fStmt := stmt.(FunctionStmt)
 	r.declare(fStmt.Name)
 	r.define(fStmt.Name)
 	r.resolveFunction(fStmt, FUNCTION)
+	return nil
 }
 
-func (r *Resolver) VisitIfStmt(stmt Stmt) {
+func (r *Resolver) VisitIfStmt(stmt Stmt) interface{} {
 	iStmt := stmt.(IfStmt)
 	r.resolveExpr(iStmt.Condition)
 	r.resolveStmt(iStmt.Then)
 	if iStmt.Else != nil {
 		r.resolveStmt(iStmt.Else)
 	}
+	return nil
+}
+
+func (r *Resolver) VisitInterfaceStmt(stmt Stmt) interface{} {
+	iStmt := stmt.(InterfaceStmt)
+	r.declare(iStmt.Name)
+	r.define(iStmt.Name)
+	return nil
 }
 
-func (r *Resolver) VisitPrintStmt(stmt Stmt) {
+func (r *Resolver) VisitAssertStmt(stmt Stmt) interface{} {
+	aStmt := stmt.(AssertStmt)
+	r.resolveExpr(aStmt.Condition)
+	if aStmt.Message != nil {
+		r.resolveExpr(aStmt.Message)
+	}
+	return nil
+}
+
+func (r *Resolver) VisitPrintStmt(stmt Stmt) interface{} {
 	pStmt := stmt.(PrintStmt)
 	r.resolveExpr(pStmt.Expression)
+	return nil
+}
+
+func (r *Resolver) VisitSelectStmt(stmt Stmt) interface{} {
+	sStmt := stmt.(SelectStmt)
+	for _, sc := range sStmt.Cases {
+		r.resolveExpr(sc.Channel)
+		if sc.Send {
+			r.resolveExpr(sc.Value)
+		}
+		r.beginScope()
+		if sc.Name != nil {
+			r.declare(*sc.Name)
+			r.define(*sc.Name)
+		}
+		r.resolveStmts(sc.Body)
+		r.endScope()
+	}
+	if sStmt.Default != nil {
+		r.beginScope()
+		r.resolveStmts(sStmt.Default)
+		r.endScope()
+	}
+	return nil
 }
 
-func (r *Resolver) VisitWhileStmt(stmt Stmt) {
+func (r *Resolver) VisitSpawnStmt(stmt Stmt) interface{} {
+	sStmt := stmt.(SpawnStmt)
+	r.resolveExpr(sStmt.Call)
+	return nil
+}
+
+func (r *Resolver) VisitWhileStmt(stmt Stmt) interface{} {
 	wStmt := stmt.(WhileStmt)
 	r.resolveExpr(wStmt.Condition)
 	r.resolveStmt(wStmt.Body)
+	return nil
 }
 
-func (r *Resolver) VisitBlockStmt(stmt Stmt) {
+func (r *Resolver) VisitBlockStmt(stmt Stmt) interface{} {
 	blockStmt := stmt.(BlockStmt)
 	r.beginScope()
 	r.resolveStmts(blockStmt.Statements)
 	r.endScope()
+	return nil
 }
 
-func (r *Resolver) VisitClassStmt(stmt Stmt) {
+func (r *Resolver) VisitClassStmt(stmt Stmt) interface{} {
 	enclosingClassType := r.currentClassType
 	r.currentClassType = CLASSCLASS
 
@@ -248,15 +327,17 @@ func (r *Resolver) VisitClassStmt(stmt Stmt) {
 		}
 		r.currentClassType = SUBCLASSCLASS
 		r.resolveExpr(cs.Superclass)
+	}
+	// interfaces are checked outside the scope holding "super"
+	for _, iface := range cs.Interfaces {
+		r.resolveExpr(iface)
+	}
+	if cs.Superclass != nil {
 		r.beginScope()
 		r.peekScope().declare("super", cs.Name.Line)
 		r.peekScope().define("super", cs.Name.Line)
 	}
 
-	r.beginScope()
-	r.peekScope().declare("this", cs.Name.Line)
-	r.peekScope().define("this", cs.Name.Line)
-
 	for _, method := range cs.Methods {
 		funcType := METHOD
 		if method.Name.Lexeme == "init" {
@@ -265,14 +346,14 @@ func (r *Resolver) VisitClassStmt(stmt Stmt) {
 		r.resolveFunction(method, funcType)
 	}
 
-	r.endScope()
 	if cs.Superclass != nil {
 		r.endScope()
 	}
 	r.currentClassType = enclosingClassType
+	return nil
 }
 
-func (r *Resolver) VisitReturnStmt(stmt Stmt) {
+func (r *Resolver) VisitReturnStmt(stmt Stmt) interface{} {
 	rStmt := stmt.(ReturnStmt)
 
 	if rStmt.Value != nil {
@@ -284,21 +365,23 @@ func (r *Resolver) VisitReturnStmt(stmt Stmt) {
 		}
 		r.resolveExpr(rStmt.Value)
 	}
+	return nil
 }
 
-func (r *Resolver) VisitVarStmt(stmt Stmt) {
+func (r *Resolver) VisitVarStmt(stmt Stmt) interface{} {
 	varStmt := stmt.(VariableStmt)
 	r.declare(varStmt.Name)
 	if varStmt.Initializer != nil {
 		r.resolveExpr(varStmt.Initializer)
 	}
 	r.define(varStmt.Name)
+	return nil
 }
 
 func (r *Resolver) VisitAssign(expr Expr) interface{} {
 	assignExpr := expr.(Assign)
 	r.resolveExpr(assignExpr.Value)
-	r.resolveLocal(assignExpr, assignExpr.Name)
+	r.resolveLocal(assignExpr.Binding, assignExpr.Name)
 	return nil
 }
 
@@ -315,6 +398,9 @@ func (r *Resolver) VisitCall(expr Expr) interface{} {
 	for _, param := range ce.Args {
 		r.resolveExpr(param)
 	}
+	for _, param := range ce.Named {
+		r.resolveExpr(param.Value)
+	}
 	return nil
 }
 
@@ -356,7 +442,7 @@ func (r *Resolver) VisitSuper(expr Expr) interface{} {
 	if r.currentClassType != SUBCLASSCLASS {
 		r.resolveError(se.Keyword.Line, "Can't use 'super' in a class with no superclass.")
 	}
-	r.resolveLocal(se, se.Keyword)
+	r.resolveLocal(se.Binding, se.Keyword)
 	return nil
 }
 
@@ -365,7 +451,7 @@ func (r *Resolver) VisitThis(expr Expr) interface{} {
 	if r.currentClassType == NONECLASS {
 		r.resolveError(te.Keyword.Line, "Cannot use 'this' outside of a class method.")
 	}
-	r.resolveLocal(te, te.Keyword)
+	r.resolveLocal(te.Binding, te.Keyword)
 	return nil
 }
 
@@ -391,6 +477,6 @@ func (r *Resolver) VisitVariable(expr Expr) interface{} {
 			}
 		}
 	}
-	r.resolveLocal(varExpr, varExpr.Name)
+	r.resolveLocal(varExpr.Binding, varExpr.Name)
 	return nil
 }
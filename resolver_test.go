@@ -60,14 +60,15 @@ func TestResolver_Resolve_script(t *testing.T) {
 
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
-			tokens := (&Scanner{}).ScanTokens(tc.in)
+			tokens, scanErr := (&Scanner{}).ScanTokens(tc.in)
+			if scanErr != nil {
+				t.Fatalf("scanning error in test input: %s", scanErr)
+			}
 			stmts, parseErr := (&Parser{Tokens: tokens}).Parse()
 			if parseErr != nil {
 				t.Fatalf("parsing error in test input: %s", parseErr)
 			}
-			interpreter := &Interpreter{}
-			interpreter.init()
-			resolver := &Resolver{interpreter: interpreter}
+			resolver := &Resolver{}
 			resolveErr := resolver.Resolve(stmts)
 			if !tc.errExpected && resolveErr != nil {
 				t.Errorf("unexpected error: %s", resolveErr)
@@ -104,14 +105,17 @@ func TestResolver_Resolve_AST(t *testing.T) {
 	// We have 4 references to "i" that we'll need to test:
 	// #1 in the conditional, "i < 3"
 	iRefTokLine1 := Token{Type: IDENTIFIER, Lexeme: "i", Line: 1}
-	whileCondLeftVar := Variable{Name: iRefTokLine1, Unique: 0}
+	whileCondLeftVar := Variable{Name: iRefTokLine1, Binding: &Binding{}}
 	// #2 in the print statement, "print i;"
-	printStmtVar := Variable{Name: Token{Type: IDENTIFIER, Lexeme: "i", Line: 2}}
+	printStmtVar := Variable{Name: Token{Type: IDENTIFIER, Lexeme: "i", Line: 2}, Binding: &Binding{}}
 	// #3 on the right side of the incrementor, "i = i + 1"
-	bodyIncrementExprRightVar := Variable{Name: iRefTokLine1, Unique: 1}
+	// (#1 and #3 are identical apart from their Bindings, but have
+	// different depths)
+	bodyIncrementExprRightVar := Variable{Name: iRefTokLine1, Binding: &Binding{}}
 	// #4 on the left side of the incrementor, "i = i + 1"
 	bodyIncrementExpr := Assign{
-		Name: iRefTokLine1,
+		Name:    iRefTokLine1,
+		Binding: &Binding{},
 		Value: Binary{
 			Left:     bodyIncrementExprRightVar,
 			Operator: Token{Type: PLUS, Lexeme: "+", Line: 1},
@@ -145,19 +149,61 @@ func TestResolver_Resolve_AST(t *testing.T) {
 		},
 	}}
 
-	interpreter := &Interpreter{}
-	resolver := &Resolver{interpreter: interpreter}
+	resolver := &Resolver{}
 	err := resolver.Resolve([]Stmt{outerBlock})
 	if err != nil {
 		t.Errorf("unexpected error: %s", err)
 	}
-	expected := map[Expr]int{
-		whileCondLeftVar:          0,
-		printStmtVar:              2,
-		bodyIncrementExprRightVar: 1,
-		bodyIncrementExpr:         1,
+	expected := []struct {
+		name     string
+		actual   *Binding
+		expected Binding
+	}{
+		{"condition", whileCondLeftVar.Binding, Binding{Local: true, Depth: 0, Slot: 0}},
+		{"print", printStmtVar.Binding, Binding{Local: true, Depth: 2, Slot: 0}},
+		{"increment operand", bodyIncrementExprRightVar.Binding, Binding{Local: true, Depth: 1, Slot: 0}},
+		{"increment assignment", bodyIncrementExpr.Binding, Binding{Local: true, Depth: 1, Slot: 0}},
+	}
+	for _, tc := range expected {
+		if !reflect.DeepEqual(*tc.actual, tc.expected) {
+			t.Errorf("%s: %+v != %+v", tc.name, *tc.actual, tc.expected)
+		}
 	}
-	if !reflect.DeepEqual(interpreter.localDistance, expected) {
-		t.Errorf("%v != %v", interpreter.localDistance, expected)
+}
+
+func TestResolver_Resolve_nilBinding(t *testing.T) {
+	// a local reference built without a Binding, which the parser would
+	// have given it
+	i := Token{Type: IDENTIFIER, Lexeme: "i", Line: 2}
+	stmts := []Stmt{BlockStmt{Statements: []Stmt{
+		VariableStmt{Name: i, Initializer: Literal{Value: float64(0)}},
+		PrintStmt{Expression: Variable{Name: i}},
+	}}}
+	err := (&Resolver{}).Resolve(stmts)
+	expectedErr := "resolution error on line 2: Reference to local \"i\" has no Binding"
+	if err == nil || !strings.Contains(err.Error(), expectedErr) {
+		t.Errorf("expected error containing %q, got %v", expectedErr, err)
 	}
+
+	// globals aren't recorded, so they don't need one
+	stmts = []Stmt{
+		VariableStmt{Name: i, Initializer: Literal{Value: float64(0)}},
+		PrintStmt{Expression: Variable{Name: i}},
+	}
+	if err := (&Resolver{}).Resolve(stmts); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestResolver_Resolve_internalError(t *testing.T) {
+	// a statement type the Resolver doesn't know about panics with
+	// something other than a resolutionError, which isn't swallowed
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("expected a panic, didn't get one")
+		} else if _, ok := r.(resolutionError); ok {
+			t.Errorf("expected a non-resolution panic, got %v", r)
+		}
+	}()
+	_ = (&Resolver{}).Resolve([]Stmt{nil})
 }
@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -14,6 +16,7 @@ const (
 	RIGHT_PAREN
 	LEFT_BRACE
 	RIGHT_BRACE
+	COLON
 	COMMA
 	DOT
 	MINUS
@@ -33,22 +36,32 @@ const (
 	LESS_EQUAL
 
 	// Literals
+	DOC_COMMENT
 	IDENTIFIER
+	PRIVATE_IDENTIFIER
 	STRING
 	NUMBER
 
 	// Keywords
 	AND
+	ASSERT
+	CASE
 	CLASS
+	DEFAULT
 	ELSE
 	FALSE
 	FUN
 	FOR
 	IF
+	IMPLEMENTS
+	INTERFACE
+	IS
 	NIL
 	OR
 	PRINT
 	RETURN
+	SELECT
+	SPAWN
 	SUPER
 	THIS
 	TRUE
@@ -64,6 +77,7 @@ var tokenTypeToPrintable = map[TokenType]string{
 	RIGHT_PAREN: "RIGHT_PAREN",
 	LEFT_BRACE:  "LEFT_BRACE",
 	RIGHT_BRACE: "RIGHT_BRACE",
+	COLON:       "COLON",
 	COMMA:       "COMMA",
 	DOT:         "DOT",
 	MINUS:       "MINUS",
@@ -83,49 +97,67 @@ var tokenTypeToPrintable = map[TokenType]string{
 	LESS_EQUAL:    "LESS_EQUAL",
 
 	// Literals
-	IDENTIFIER: "IDENTIFIER",
-	STRING:     "STRING",
-	NUMBER:     "NUMBER",
+	DOC_COMMENT:        "DOC_COMMENT",
+	IDENTIFIER:         "IDENTIFIER",
+	PRIVATE_IDENTIFIER: "PRIVATE_IDENTIFIER",
+	STRING:             "STRING",
+	NUMBER:             "NUMBER",
 
 	// Keywords
-	AND:    "AND",
-	CLASS:  "CLASS",
-	ELSE:   "ELSE",
-	FALSE:  "FALSE",
-	FUN:    "FUN",
-	FOR:    "FOR",
-	IF:     "IF",
-	NIL:    "NIL",
-	OR:     "OR",
-	PRINT:  "PRINT",
-	RETURN: "RETURN",
-	SUPER:  "SUPER",
-	THIS:   "THIS",
-	TRUE:   "TRUE",
-	VAR:    "VAR",
-	WHILE:  "WHILE",
+	AND:        "AND",
+	ASSERT:     "ASSERT",
+	CASE:       "CASE",
+	CLASS:      "CLASS",
+	DEFAULT:    "DEFAULT",
+	ELSE:       "ELSE",
+	FALSE:      "FALSE",
+	FUN:        "FUN",
+	FOR:        "FOR",
+	IF:         "IF",
+	IMPLEMENTS: "IMPLEMENTS",
+	INTERFACE:  "INTERFACE",
+	IS:         "IS",
+	NIL:        "NIL",
+	OR:         "OR",
+	PRINT:      "PRINT",
+	RETURN:     "RETURN",
+	SELECT:     "SELECT",
+	SPAWN:      "SPAWN",
+	SUPER:      "SUPER",
+	THIS:       "THIS",
+	TRUE:       "TRUE",
+	VAR:        "VAR",
+	WHILE:      "WHILE",
 
 	EOF: "EOF",
 }
 
 var identifierToTokenType = map[string]TokenType{
 	// Keywords
-	"and":    AND,
-	"class":  CLASS,
-	"else":   ELSE,
-	"false":  FALSE,
-	"fun":    FUN,
-	"for":    FOR,
-	"if":     IF,
-	"nil":    NIL,
-	"or":     OR,
-	"print":  PRINT,
-	"return": RETURN,
-	"super":  SUPER,
-	"this":   THIS,
-	"true":   TRUE,
-	"var":    VAR,
-	"while":  WHILE,
+	"and":        AND,
+	"assert":     ASSERT,
+	"case":       CASE,
+	"class":      CLASS,
+	"default":    DEFAULT,
+	"else":       ELSE,
+	"false":      FALSE,
+	"fun":        FUN,
+	"for":        FOR,
+	"if":         IF,
+	"implements": IMPLEMENTS,
+	"interface":  INTERFACE,
+	"is":         IS,
+	"nil":        NIL,
+	"or":         OR,
+	"print":      PRINT,
+	"return":     RETURN,
+	"select":     SELECT,
+	"spawn":      SPAWN,
+	"super":      SUPER,
+	"this":       THIS,
+	"true":       TRUE,
+	"var":        VAR,
+	"while":      WHILE,
 }
 
 type Token struct {
@@ -139,19 +171,40 @@ func (t Token) String() string {
 	return tokenTypeToPrintable[t.Type] + " '" + t.Lexeme + "' " + strconv.Itoa(t.Line)
 }
 
+type scanError struct {
+	line int
+	msg  string
+}
+
+func (se scanError) error() error {
+	return fmt.Errorf("scan error on line %d: %s", se.line, se.msg)
+}
+
 type Scanner struct {
 	srcRunes []rune
 	start    int
 	current  int
 	line     int
 	Tokens   []Token
+	interned map[string]string
 }
 
-func (s *Scanner) ScanTokens(src string) []Token {
+func (s *Scanner) ScanTokens(src string) (returnTokens []Token, returnErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(scanError)
+			if !ok {
+				panic(r)
+			}
+			returnErr = se.error()
+		}
+	}()
+
 	*s = Scanner{} // reset to zero value
 	s.line = 1
 	s.Tokens = make([]Token, 0, 8)
 	s.srcRunes = []rune(src)
+	s.interned = make(map[string]string)
 
 	for !s.isAtEnd() {
 		s.start = s.current
@@ -160,7 +213,14 @@ func (s *Scanner) ScanTokens(src string) []Token {
 
 	//s.Tokens = append(s.Tokens, Token{EOF, "", nil, s.line})
 
-	return s.Tokens
+	return s.Tokens, nil
+}
+
+func (s *Scanner) scanError(line int, msg string) {
+	panic(scanError{
+		line: line,
+		msg:  msg,
+	})
 }
 
 func (s *Scanner) isAtEnd() bool {
@@ -179,6 +239,8 @@ func (s *Scanner) scanToken() {
 		s.addToken(LEFT_BRACE, nil)
 	case '}':
 		s.addToken(RIGHT_BRACE, nil)
+	case ':':
+		s.addToken(COLON, nil)
 	case ',':
 		s.addToken(COMMA, nil)
 	case '.':
@@ -221,9 +283,18 @@ func (s *Scanner) scanToken() {
 	// comments and slash
 	case '/':
 		if s.matchNext('/') {
+			isDoc := s.matchNext('/')
 			for s.peek() != '\n' && !s.isAtEnd() {
 				s.advance()
 			}
+			// "///" doc comments are kept, for the parser to attach to
+			// the following declaration
+			if isDoc {
+				text := string(s.srcRunes[s.start+3 : s.current])
+				s.addToken(DOC_COMMENT, strings.TrimPrefix(strings.TrimRight(text, " \t\r"), " "))
+			}
+		} else if s.matchNext('*') {
+			s.blockComment()
 		} else {
 			s.addToken(SLASH, nil)
 		}
@@ -243,13 +314,21 @@ func (s *Scanner) scanToken() {
 	case '"':
 		s.scanString()
 
+	// private member names, like "#field"
+	case '#':
+		if !isAlpha(s.peek()) {
+			s.scanError(s.line, "Expect member name after '#'.")
+		}
+		s.scanIdentifier()
+		s.Tokens[len(s.Tokens)-1].Type = PRIVATE_IDENTIFIER
+
 	default:
-		if unicode.IsDigit(r) {
+		if isDecimalDigit(r) {
 			s.scanNumber()
-		} else if unicode.IsLetter(r) {
+		} else if isAlpha(r) {
 			s.scanIdentifier()
 		} else {
-			panic(fmt.Sprintf("unexpected character %q", r))
+			s.scanError(s.line, fmt.Sprintf("Unexpected character %q.", r))
 		}
 	}
 
@@ -286,7 +365,28 @@ func (s *Scanner) peekNext() rune {
 	return s.srcRunes[s.current+1]
 }
 
+// blockComment skips a "/* ... */" comment, whose opening "/*" has been
+// consumed. Block comments nest, so "/* a /* b */ c */" is one comment.
+func (s *Scanner) blockComment() {
+	startLine := s.line
+	depth := 1
+	for depth > 0 {
+		if s.isAtEnd() {
+			s.scanError(startLine, "Unterminated block comment.")
+		}
+		switch r := s.advance(); {
+		case r == '\n':
+			s.line++
+		case r == '/' && s.matchNext('*'):
+			depth++
+		case r == '*' && s.matchNext('/'):
+			depth--
+		}
+	}
+}
+
 func (s *Scanner) scanString() {
+	startLine := s.line
 	for s.peek() != '"' && !s.isAtEnd() {
 		if s.peek() == '\n' {
 			s.line++
@@ -295,35 +395,194 @@ func (s *Scanner) scanString() {
 	}
 
 	if s.isAtEnd() {
-		panic(fmt.Sprintf("Unterminated string starting on line %d", s.line))
+		s.scanError(startLine, "Unterminated string.")
 	}
 
 	s.advance() // consume the terminating '"'
 
 	literal := string(s.srcRunes[s.start+1 : s.current-1]) // note we trim the leading/trailing quotes
-	s.addToken(STRING, literal)
+	s.addToken(STRING, s.intern(literal))
+}
+
+// radixPrefixes maps the second character of a "0x", "0b" or "0o"
+// integer prefix to the literal's base.
+var radixPrefixes = map[rune]struct {
+	base int
+	name string
+}{
+	'x': {16, "hexadecimal"},
+	'X': {16, "hexadecimal"},
+	'b': {2, "binary"},
+	'B': {2, "binary"},
+	'o': {8, "octal"},
+	'O': {8, "octal"},
 }
 
 func (s *Scanner) scanNumber() {
-	for unicode.IsDigit(s.peek()) {
+	if radix, found := radixPrefixes[s.peek()]; found && s.srcRunes[s.start] == '0' {
 		s.advance()
+		s.scanRadixInteger(radix.base, radix.name)
+		return
 	}
-	if s.peek() == '.' && unicode.IsDigit(s.peekNext()) {
+
+	s.digits(isDecimalDigit)
+	isFloat := false
+	if s.peek() == '.' && isDecimalDigit(s.peekNext()) {
+		isFloat = true
 		s.advance() // consume the '.'
-		for unicode.IsDigit(s.peek()) {
+		s.digits(isDecimalDigit)
+	}
+	if s.peek() == 'e' || s.peek() == 'E' {
+		isFloat = true
+		s.advance()
+		if s.peek() == '+' || s.peek() == '-' {
 			s.advance()
 		}
+		if !isDecimalDigit(s.peek()) {
+			s.scanError(s.line, fmt.Sprintf("Expect digits in exponent of %q.", string(s.srcRunes[s.start:s.current])))
+		}
+		s.digits(isDecimalDigit)
+	}
+	str := strings.ReplaceAll(string(s.srcRunes[s.start:s.current]), "_", "")
+
+	// an 'n' suffix makes a bigint, a 'd' suffix makes a decimal
+	if !isFloat && s.matchSuffix('n') {
+		literal, _ := new(big.Int).SetString(str, 10)
+		s.addToken(NUMBER, literal)
+		return
 	}
-	str := string(s.srcRunes[s.start:s.current])
+	if s.matchSuffix('d') {
+		literal, err := parseDecimal(str)
+		if err != nil {
+			s.scanError(s.line, fmt.Sprintf("Invalid decimal %q: %s.", str, err))
+		}
+		s.addToken(NUMBER, literal)
+		return
+	}
+	s.checkNumberEnd()
+
+	// literals without a fractional part or exponent are integers, which
+	// aren't silently rounded to a float when they're too large
+	if !isFloat {
+		literal, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			s.scanError(s.line, fmt.Sprintf(
+				"Integer literal %q is too large; add an 'n' suffix for a bigint.",
+				string(s.srcRunes[s.start:s.current]),
+			))
+		}
+		s.addToken(NUMBER, literal)
+		return
+	}
+
 	literal, err := strconv.ParseFloat(str, 64)
 	if err != nil {
-		panic(fmt.Sprintf("error parsing float %q on line %d: %s", str, s.line, err))
+		s.scanError(s.line, fmt.Sprintf("Invalid number %q: %s.", str, err))
 	}
 	s.addToken(NUMBER, literal)
 }
 
+// scanRadixInteger scans the digits of a "0x", "0b" or "0o" literal, whose
+// prefix has been consumed. An 'n' suffix makes it a bigint.
+func (s *Scanner) scanRadixInteger(base int, name string) {
+	isDigit := func(r rune) bool {
+		value, ok := digitValue(r)
+		return ok && value < base
+	}
+	if !isDigit(s.peek()) {
+		s.scanError(s.line, fmt.Sprintf("Expect %s digits after %q.", name, string(s.srcRunes[s.start:s.current])))
+	}
+	s.digits(isDigit)
+	if _, ok := digitValue(s.peek()); ok {
+		s.scanError(s.line, fmt.Sprintf("Invalid digit %q in %s literal.", s.peek(), name))
+	}
+	str := strings.ReplaceAll(string(s.srcRunes[s.start+2:s.current]), "_", "")
+
+	if s.matchSuffix('n') {
+		literal, _ := new(big.Int).SetString(str, base)
+		s.addToken(NUMBER, literal)
+		return
+	}
+	s.checkNumberEnd()
+	literal, err := strconv.ParseInt(str, base, 64)
+	if err != nil {
+		s.scanError(s.line, fmt.Sprintf(
+			"Integer literal %q is too large; add an 'n' suffix for a bigint.",
+			string(s.srcRunes[s.start:s.current]),
+		))
+	}
+	s.addToken(NUMBER, literal)
+}
+
+// digits consumes a run of digits, which may be separated by single
+// underscores, as in "1_000_000".
+func (s *Scanner) digits(isDigit func(rune) bool) {
+	for {
+		switch {
+		case isDigit(s.peek()):
+			s.advance()
+		case s.peek() == '_':
+			s.advance()
+			if !isDigit(s.peek()) {
+				s.scanError(s.line, fmt.Sprintf(
+					"'_' must separate digits in number literal %q.",
+					string(s.srcRunes[s.start:s.current]),
+				))
+			}
+		default:
+			return
+		}
+	}
+}
+
+// matchSuffix consumes a one-letter type suffix ending a number literal.
+func (s *Scanner) matchSuffix(suffix rune) bool {
+	if s.peek() != suffix || isIdentifierRune(s.peekNext()) {
+		return false
+	}
+	s.advance()
+	return true
+}
+
+// checkNumberEnd rejects number literals running straight into a name,
+// like "12abc", which are almost certainly typos.
+func (s *Scanner) checkNumberEnd() {
+	if isAlpha(s.peek()) {
+		s.scanError(s.line, fmt.Sprintf(
+			"Unexpected %q after number literal %q.",
+			s.peek(), string(s.srcRunes[s.start:s.current]),
+		))
+	}
+}
+
+func isDecimalDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// digitValue returns the value of r as a digit in bases up to 16.
+func digitValue(r rune) (int, bool) {
+	switch {
+	case r >= '0' && r <= '9':
+		return int(r - '0'), true
+	case r >= 'a' && r <= 'f':
+		return int(r-'a') + 10, true
+	case r >= 'A' && r <= 'F':
+		return int(r-'A') + 10, true
+	}
+	return 0, false
+}
+
+// isAlpha reports whether r may start an identifier.
+func isAlpha(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentifierRune(r rune) bool {
+	return isAlpha(r) || unicode.IsDigit(r)
+}
+
 func (s *Scanner) scanIdentifier() {
-	for unicode.IsLetter(s.peek()) || unicode.IsDigit(s.peek()) {
+	for isIdentifierRune(s.peek()) {
 		s.advance()
 	}
 	lexeme := string(s.srcRunes[s.start:s.current])
@@ -336,6 +595,17 @@ func (s *Scanner) scanIdentifier() {
 }
 
 func (s *Scanner) addToken(typ TokenType, literal interface{}) {
-	lexeme := string(s.srcRunes[s.start:s.current])
+	lexeme := s.intern(string(s.srcRunes[s.start:s.current]))
 	s.Tokens = append(s.Tokens, Token{typ, lexeme, literal, s.line})
 }
+
+// intern returns the first copy of str the Scanner saw, so that the
+// tokens for each use of an identifier, keyword or string literal share
+// one string, and the rest can be garbage collected.
+func (s *Scanner) intern(str string) string {
+	if interned, found := s.interned[str]; found {
+		return interned
+	}
+	s.interned[str] = str
+	return str
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
 	"strings"
 	"testing"
+	"unsafe"
 )
 
 func compareTokens(left, right Token) (bool, string) {
@@ -34,26 +36,108 @@ func compareTokens(left, right Token) (bool, string) {
 
 func TestScanner_ScanTokens(t *testing.T) {
 	testCases := map[string]struct {
-		src      string
-		expected []Token
+		src         string
+		expected    []Token
+		errExpected bool
+		expectedErr string
 	}{
 		"number-with-decimal": {
 			src:      "10.10",
 			expected: []Token{{NUMBER, "10.10", 10.1, 1}},
 		},
+		"err: integer too large": {
+			src:         "99999999999999999999",
+			errExpected: true,
+			expectedErr: "Integer literal \"99999999999999999999\" is too large; add an 'n' suffix for a bigint.",
+		},
+		"bigint-suffix": {
+			src:      "123n",
+			expected: []Token{{NUMBER, "123n", big.NewInt(123), 1}},
+		},
+		"decimal-suffix": {
+			src:      "1.10d",
+			expected: []Token{{NUMBER, "1.10d", Decimal{unscaled: big.NewInt(110), scale: 2}, 1}},
+		},
+		"digit-separators": {
+			src:      "1_000_000",
+			expected: []Token{{NUMBER, "1_000_000", int64(1000000), 1}},
+		},
+		"hex-binary-octal": {
+			src: "0xFF 0b1010 0o17",
+			expected: []Token{
+				{NUMBER, "0xFF", int64(255), 1},
+				{NUMBER, "0b1010", int64(10), 1},
+				{NUMBER, "0o17", int64(15), 1},
+			},
+		},
+		"err: radix prefix without digits": {
+			src:         "0x_1",
+			errExpected: true,
+			expectedErr: "Expect hexadecimal digits after \"0x\".",
+		},
+		"large-hex-bigint": {
+			src:      "0xFFFF_FFFF_FFFF_FFFF_FFn",
+			expected: []Token{{NUMBER, "0xFFFF_FFFF_FFFF_FFFF_FFn", new(big.Int).SetBytes([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}), 1}},
+		},
+		"exponents": {
+			src: "1.5e-3 2E10 1_0e+1_0",
+			expected: []Token{
+				{NUMBER, "1.5e-3", 0.0015, 1},
+				{NUMBER, "2E10", 2e10, 1},
+				{NUMBER, "1_0e+1_0", 1e11, 1},
+			},
+		},
+		"err: exponent without digits": {
+			src:         "1e+",
+			errExpected: true,
+			expectedErr: "Expect digits in exponent of \"1e+\".",
+		},
+		"err: invalid binary digit": {
+			src:         "0b102",
+			errExpected: true,
+			expectedErr: "Invalid digit '2' in binary literal.",
+		},
+		"err: trailing separator": {
+			src:         "1_000_",
+			errExpected: true,
+			expectedErr: "'_' must separate digits in number literal \"1_000_\".",
+		},
+		"err: doubled separator": {
+			src:         "1__0",
+			errExpected: true,
+			expectedErr: "'_' must separate digits in number literal \"1_\".",
+		},
+		"err: letters after a number": {
+			src:         "12abc",
+			errExpected: true,
+			expectedErr: "Unexpected 'a' after number literal \"12\".",
+		},
+		"err: hex literal too large": {
+			src:         "0x1_0000_0000_0000_0000",
+			errExpected: true,
+			expectedErr: "Integer literal \"0x1_0000_0000_0000_0000\" is too large",
+		},
+		"identifiers with underscores": {
+			src: "my_var _private __x2",
+			expected: []Token{
+				{IDENTIFIER, "my_var", nil, 1},
+				{IDENTIFIER, "_private", nil, 1},
+				{IDENTIFIER, "__x2", nil, 1},
+			},
+		},
 		"numbers-whitespace-delimited": {
 			src: "1 2",
 			expected: []Token{
-				{NUMBER, "1", 1.0, 1},
-				{NUMBER, "2", 2.0, 1},
+				{NUMBER, "1", int64(1), 1},
+				{NUMBER, "2", int64(2), 1},
 			},
 		},
 		"numbers-and-operator": {
 			src: "1* 3",
 			expected: []Token{
-				{NUMBER, "1", 1.0, 1},
+				{NUMBER, "1", int64(1), 1},
 				{STAR, "*", nil, 1},
-				{NUMBER, "3", 3.0, 1},
+				{NUMBER, "3", int64(3), 1},
 			},
 		},
 		"string": {
@@ -74,6 +158,14 @@ func TestScanner_ScanTokens(t *testing.T) {
 				{IDENTIFIER, "myVar", nil, 1},
 			},
 		},
+		"private identifier": {
+			src: "this.#secret",
+			expected: []Token{
+				{THIS, "this", nil, 1},
+				{DOT, ".", nil, 1},
+				{PRIVATE_IDENTIFIER, "#secret", nil, 1},
+			},
+		},
 		"keyword": {
 			src: "and",
 			expected: []Token{
@@ -96,15 +188,46 @@ func TestScanner_ScanTokens(t *testing.T) {
 		"toks separated by comments": {
 			src: "1 / // k\n2",
 			expected: []Token{
-				{NUMBER, "1", 1.0, 1},
+				{NUMBER, "1", int64(1), 1},
 				{SLASH, "/", nil, 1},
-				{NUMBER, "2", 2.0, 2},
+				{NUMBER, "2", int64(2), 2},
+			},
+		},
+		"doc comments are kept": {
+			src: "/// Adds things.\n///\nfun",
+			expected: []Token{
+				{DOC_COMMENT, "/// Adds things.", "Adds things.", 1},
+				{DOC_COMMENT, "///", "", 2},
+				{FUN, "fun", nil, 3},
+			},
+		},
+		"block comments nest and count lines": {
+			src: "1 /* a\n /* b */\n * c */ 2 /**/ 3",
+			expected: []Token{
+				{NUMBER, "1", int64(1), 1},
+				{NUMBER, "2", int64(2), 3},
+				{NUMBER, "3", int64(3), 3},
 			},
 		},
+		"unterminated block comment": {
+			src:         "1\n/* a /* b */\n\n",
+			errExpected: true,
+			expectedErr: "scan error on line 2: Unterminated block comment.",
+		},
+		"unterminated string": {
+			src:         "\n\"abc\n",
+			errExpected: true,
+			expectedErr: "scan error on line 2: Unterminated string.",
+		},
+		"unexpected character": {
+			src:         "1 @",
+			errExpected: true,
+			expectedErr: "scan error on line 1: Unexpected character '@'.",
+		},
 		"ignore newline but increment line": {
 			src: "\n1",
 			expected: []Token{
-				{NUMBER, "1", 1.0, 2},
+				{NUMBER, "1", int64(1), 2},
 			},
 		},
 	}
@@ -112,7 +235,14 @@ func TestScanner_ScanTokens(t *testing.T) {
 	for name, tc := range testCases {
 		t.Run(name, func(t *testing.T) {
 			s := &Scanner{}
-			actual := s.ScanTokens(tc.src)
+			actual, err := s.ScanTokens(tc.src)
+			if tc.errExpected && err == nil {
+				t.Fatal("expected error, didn't get one")
+			} else if !tc.errExpected && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err != nil && !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Fatalf("expected error containing %q, got %q", tc.expectedErr, err)
+			}
 			if len(actual) != len(tc.expected) {
 				t.Errorf("expected %d tokens, got %d", len(tc.expected), len(actual))
 			}
@@ -125,3 +255,23 @@ func TestScanner_ScanTokens(t *testing.T) {
 		})
 	}
 }
+
+func TestScanner_ScanTokens_interning(t *testing.T) {
+	data := func(s string) uintptr {
+		return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+	}
+	tokens, err := (&Scanner{}).ScanTokens(`var name = "s"; name = name + "s";`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	names := []Token{tokens[1], tokens[5], tokens[7]}
+	for _, tok := range names[1:] {
+		if data(tok.Lexeme) != data(names[0].Lexeme) {
+			t.Errorf("expected identifier %q on line %d to be interned", tok.Lexeme, tok.Line)
+		}
+	}
+	first, second := tokens[3].Literal.(string), tokens[9].Literal.(string)
+	if data(first) != data(second) {
+		t.Errorf("expected string literal %q to be interned", first)
+	}
+}
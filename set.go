@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// variadic is the Arity() of callables which accept any number of
+// arguments.
+const variadic = -1
+
+// SetBuiltin is set(...), which makes a HashSet of its arguments.
+type SetBuiltin struct{}
+
+func (sb SetBuiltin) Arity() int { return variadic }
+
+func (sb SetBuiltin) Call(i *Interpreter, args []interface{}) interface{} {
+	set := &HashSet{}
+	for _, arg := range args {
+		if _, err := set.add(i, arg); err != nil {
+			i.runtimeError(i.callLine, err.Error())
+		}
+	}
+	return set
+}
+
+func (sb SetBuiltin) String() string {
+	return "<native fn set>"
+}
+
+// HashSet is an unordered collection of distinct values. Membership follows
+// the rules of "==": numbers are equal by value whatever their
+// representation, data class instances are equal by their fields (which
+// can't change once they're set), and other instances only to themselves.
+// Sets can't be members of sets, since they're compared by contents which
+// can change. Sets iterate and print in insertion order, so output is
+// deterministic.
+type HashSet struct {
+	mu      sync.RWMutex // sets can be shared between tasks
+	members map[interface{}]interface{}
+	order   []interface{} // keys of members, in insertion order
+}
+
+func (s *HashSet) String() string {
+	elements := s.elements()
+	parts := make([]string, len(elements))
+	for idx, element := range elements {
+		parts[idx] = fmt.Sprint(element)
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func (s *HashSet) Get(name Token) (interface{}, error) {
+	method := func(name string, arity int, fn func(*Interpreter, []interface{}) (interface{}, error)) nativeFunction {
+		return nativeFunction{name: name, arity: arity, fn: fn}
+	}
+	switch name.Lexeme {
+	case "add":
+		return method("add", 1, func(i *Interpreter, args []interface{}) (interface{}, error) {
+			return s.add(i, args[0])
+		}), nil
+	case "remove":
+		return method("remove", 1, func(i *Interpreter, args []interface{}) (interface{}, error) {
+			return s.remove(args[0])
+		}), nil
+	case "contains":
+		return method("contains", 1, func(i *Interpreter, args []interface{}) (interface{}, error) {
+			return s.contains(args[0])
+		}), nil
+	case "size":
+		return method("size", 0, func(*Interpreter, []interface{}) (interface{}, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return int64(len(s.order)), nil
+		}), nil
+	case "values":
+		return method("values", 0, func(i *Interpreter, _ []interface{}) (interface{}, error) {
+			elements := s.elements()
+			i.allocate(i.callLine, valueSize*len(elements))
+			return &List{Elements: elements}, nil
+		}), nil
+	case "forEach":
+		return method("forEach", 1, s.forEach), nil
+	case "union":
+		return method("union", 1, s.algebra("union", func(inThis, inOther bool) bool { return inThis || inOther })), nil
+	case "intersection":
+		return method("intersection", 1, s.algebra("intersection", func(inThis, inOther bool) bool { return inThis && inOther })), nil
+	case "difference":
+		return method("difference", 1, s.algebra("difference", func(inThis, inOther bool) bool { return inThis && !inOther })), nil
+	case "isSubsetOf":
+		return method("isSubsetOf", 1, func(i *Interpreter, args []interface{}) (interface{}, error) {
+			other, err := setArg("isSubsetOf", args[0])
+			if err != nil {
+				return nil, err
+			}
+			return s.isSubsetOf(other), nil
+		}), nil
+	}
+	return nil, fmt.Errorf("Undefined property %q.", name.Lexeme)
+}
+
+// add inserts value, returning whether it wasn't already present.
+func (s *HashSet) add(i *Interpreter, value interface{}) (interface{}, error) {
+	key, err := hashKey(value)
+	if err != nil {
+		return nil, err
+	}
+	added := s.insert(key, value)
+	if added {
+		i.allocate(i.callLine, setMemberSize)
+	}
+	return added, nil
+}
+
+// remove deletes value, returning whether it was present.
+func (s *HashSet) remove(value interface{}) (interface{}, error) {
+	key, err := hashKey(value)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.members[key]; !found {
+		return false, nil
+	}
+	delete(s.members, key)
+	for idx, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:idx], s.order[idx+1:]...)
+			break
+		}
+	}
+	return true, nil
+}
+
+func (s *HashSet) contains(value interface{}) (interface{}, error) {
+	key, err := hashKey(value)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, found := s.members[key]
+	return found, nil
+}
+
+func (s *HashSet) hasKey(key interface{}) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, found := s.members[key]
+	return found
+}
+
+// elements returns the members in insertion order.
+func (s *HashSet) elements() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	elements := make([]interface{}, len(s.order))
+	for idx, key := range s.order {
+		elements[idx] = s.members[key]
+	}
+	return elements
+}
+
+// forEach calls its argument with each member in turn. It iterates over a
+// snapshot, so the callback may modify the set.
+func (s *HashSet) forEach(i *Interpreter, args []interface{}) (interface{}, error) {
+	fn, ok := args[0].(Callable)
+	if !ok || (fn.Arity() != 1 && fn.Arity() != variadic) {
+		return nil, fmt.Errorf("forEach() expects a function of one argument.")
+	}
+	for _, element := range s.elements() {
+		fn.Call(i, []interface{}{element})
+	}
+	return nil, nil
+}
+
+// algebra makes a method combining this set with another into a new set,
+// containing the members of either for which keep returns true.
+func (s *HashSet) algebra(name string, keep func(inThis, inOther bool) bool) func(*Interpreter, []interface{}) (interface{}, error) {
+	return func(i *Interpreter, args []interface{}) (interface{}, error) {
+		other, err := setArg(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		// work from copies, so that only one set is locked at a time
+		thisOrder, thisMembers := s.snapshot()
+		otherOrder, otherMembers := other.snapshot()
+		result := &HashSet{}
+		for _, keys := range [][]interface{}{thisOrder, otherOrder} {
+			for _, key := range keys {
+				thisValue, inThis := thisMembers[key]
+				otherValue, inOther := otherMembers[key]
+				if !keep(inThis, inOther) {
+					continue
+				}
+				value := otherValue
+				if inThis {
+					value = thisValue
+				}
+				if result.insert(key, value) {
+					i.allocate(i.callLine, setMemberSize)
+				}
+			}
+		}
+		return result, nil
+	}
+}
+
+func (s *HashSet) snapshot() ([]interface{}, map[interface{}]interface{}) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members := make(map[interface{}]interface{}, len(s.members))
+	for key, value := range s.members {
+		members[key] = value
+	}
+	return append([]interface{}(nil), s.order...), members
+}
+
+// insert adds a member with a precomputed key, returning whether it
+// wasn't already present.
+func (s *HashSet) insert(key, value interface{}) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.members[key]; found {
+		return false
+	}
+	if s.members == nil {
+		s.members = make(map[interface{}]interface{})
+	}
+	s.members[key] = value
+	s.order = append(s.order, key)
+	return true
+}
+
+func (s *HashSet) isSubsetOf(other *HashSet) bool {
+	order, _ := s.snapshot()
+	for _, key := range order {
+		if !other.hasKey(key) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *HashSet) size() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.order)
+}
+
+// setEqual reports whether two sets have the same members.
+func setEqual(a, b *HashSet) bool {
+	return a.size() == b.size() && a.isSubsetOf(b)
+}
+
+func setArg(fn string, value interface{}) (*HashSet, error) {
+	set, ok := value.(*HashSet)
+	if !ok {
+		return nil, fmt.Errorf("%s() expects a set, got %s.", fn, typeName(value))
+	}
+	return set, nil
+}
+
+// numberKey identifies a non-integral or very large number by its exact
+// value, so that e.g. 0.5 and 0.5d are the same member.
+type numberKey string
+
+// nanKey is the key of a NaN. Since NaN doesn't equal anything, not even
+// itself, each NaN gets a key of its own, which no lookup will match.
+type nanKey struct {
+	_ byte // so that each one has a distinct address
+}
+
+// dataKey identifies a data class instance by its class and the keys of
+// its field values.
+type dataKey struct {
+	class  *Class
+	fields interface{} // a fieldKey, or nil if there are no fields
+}
+
+// fieldKey chains the keys of a data class instance's field values, in
+// order, so that the whole chain is comparable.
+type fieldKey struct {
+	key  interface{}
+	rest interface{} // a fieldKey for the following fields, or nil
+}
+
+// functionKey identifies a function by its declaration and closure, and
+// a method by the instance it's bound to as well.
+type functionKey struct {
+	closure  *environment
+	name     string
+	line     int
+	receiver *Instance
+}
+
+func newFunctionKey(f Function) functionKey {
+	return functionKey{closure: f.Closure, name: f.Declaration.Name.Lexeme, line: f.Declaration.Name.Line, receiver: f.receiver}
+}
+
+// hashKey maps value to a comparable key, such that two values have the
+// same key exactly when they're equal according to "==". So a NaN's key
+// doesn't match any other, including that of the same NaN.
+func hashKey(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, bool, string:
+		return v, nil
+	case int64, float64, *big.Int, Decimal:
+		// integers are their own keys, whatever their representation
+		if n, ok := toInt(v); ok {
+			return n, nil
+		}
+		switch n := v.(type) {
+		case float64:
+			if math.IsNaN(n) {
+				return &nanKey{}, nil
+			}
+			if math.IsInf(n, 0) {
+				return numberKey(fmt.Sprint(n)), nil
+			}
+			return numberKey(new(big.Rat).SetFloat64(n).RatString()), nil
+		case *big.Int:
+			return numberKey(n.String()), nil
+		case Decimal:
+			return numberKey(n.rat().RatString()), nil
+		}
+	case *Instance:
+		if !v.Class.IsData {
+			return v, nil
+		}
+		var fields interface{}
+		for idx := len(v.Class.Fields) - 1; idx >= 0; idx-- {
+			key, err := hashKey(v.field(v.Class.Fields[idx]))
+			if err != nil {
+				return nil, err
+			}
+			fields = fieldKey{key: key, rest: fields}
+		}
+		return dataKey{class: v.Class, fields: fields}, nil
+	case Function:
+		return newFunctionKey(v), nil
+	case *vmBoundMethod:
+		return *v, nil
+	case *HashSet:
+		// sets are equal by their contents, which can change
+		return nil, fmt.Errorf("Can't use a set as a set member.")
+	}
+	if !reflect.TypeOf(value).Comparable() {
+		return nil, fmt.Errorf("Can't use %v as a set member.", value)
+	}
+	return value, nil
+}
@@ -3,47 +3,80 @@ package main
 import "fmt"
 
 type StmtVisitor interface {
-	VisitClassStmt(Stmt)
-	VisitExpressionStmt(Stmt)
-	VisitFunctionStmt(Stmt)
-	VisitIfStmt(Stmt)
-	VisitPrintStmt(Stmt)
-	VisitWhileStmt(Stmt)
-	VisitBlockStmt(Stmt)
-	VisitReturnStmt(Stmt)
-	VisitVarStmt(Stmt)
+	VisitAssertStmt(Stmt) interface{}
+	VisitClassStmt(Stmt) interface{}
+	VisitExpressionStmt(Stmt) interface{}
+	VisitFunctionStmt(Stmt) interface{}
+	VisitIfStmt(Stmt) interface{}
+	VisitInterfaceStmt(Stmt) interface{}
+	VisitPrintStmt(Stmt) interface{}
+	VisitWhileStmt(Stmt) interface{}
+	VisitBlockStmt(Stmt) interface{}
+	VisitReturnStmt(Stmt) interface{}
+	VisitSelectStmt(Stmt) interface{}
+	VisitSpawnStmt(Stmt) interface{}
+	VisitVarStmt(Stmt) interface{}
 }
 
 type Stmt interface {
-	Accept(visitor StmtVisitor)
+	Accept(visitor StmtVisitor) interface{}
+}
+
+// AssertStmt is "assert condition, message;". Source is the text of the
+// condition, reconstructed from its tokens, for the failure message.
+type AssertStmt struct {
+	Keyword   Token
+	Condition Expr
+	Message   Expr // may be nil
+	Source    string
+}
+
+func (as AssertStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitAssertStmt(as)
+}
+
+func (as AssertStmt) String() string {
+	if as.Message == nil {
+		return fmt.Sprintf("assert %v", as.Condition)
+	}
+	return fmt.Sprintf("assert %v, %v", as.Condition, as.Message)
 }
 
 type ExprStmt struct {
 	Expression Expr
 }
 
-func (es ExprStmt) Accept(visitor StmtVisitor) {
-	visitor.VisitExpressionStmt(es)
+func (es ExprStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitExpressionStmt(es)
 }
 
 type ClassStmt struct {
 	Name       Token
 	Superclass *Variable
 	Methods    []FunctionStmt
+	Interfaces []Variable
+	IsData     bool
+	Fields     []Token // the fields declared by a data class
+	Doc        string  // from "///" comments preceding the declaration
 }
 
-func (cs ClassStmt) Accept(visitor StmtVisitor) {
-	visitor.VisitClassStmt(cs)
+func (cs ClassStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitClassStmt(cs)
 }
 
 type FunctionStmt struct {
 	Name   Token
 	Params []Token
-	Body   []Stmt
+	// optional type annotations, see Checker: ParamTypes is either nil
+	// or has an entry (possibly nil) for every parameter
+	ParamTypes []*Token
+	ReturnType *Token
+	Body       []Stmt
+	Doc        string // from "///" comments preceding the declaration
 }
 
-func (fs FunctionStmt) Accept(visitor StmtVisitor) {
-	visitor.VisitFunctionStmt(fs)
+func (fs FunctionStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitFunctionStmt(fs)
 }
 
 type IfStmt struct {
@@ -52,8 +85,8 @@ type IfStmt struct {
 	Else      Stmt
 }
 
-func (i IfStmt) Accept(visitor StmtVisitor) {
-	visitor.VisitIfStmt(i)
+func (i IfStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitIfStmt(i)
 }
 
 func (i IfStmt) String() string {
@@ -63,12 +96,29 @@ func (i IfStmt) String() string {
 	return fmt.Sprintf("if(%v) %v else %v", i.Condition, i.Then, i.Else)
 }
 
+type InterfaceStmt struct {
+	Name    Token
+	Methods []InterfaceMethod
+}
+
+func (is InterfaceStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitInterfaceStmt(is)
+}
+
+// InterfaceMethod is a method signature required by an interface.
+type InterfaceMethod struct {
+	Name       Token    `json:"name"`
+	Params     []Token  `json:"params"`
+	ParamTypes []*Token `json:"paramTypes"`
+	ReturnType *Token   `json:"returnType"`
+}
+
 type PrintStmt struct {
 	Expression Expr
 }
 
-func (p PrintStmt) Accept(visitor StmtVisitor) {
-	visitor.VisitPrintStmt(p)
+func (p PrintStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitPrintStmt(p)
 }
 
 func (p PrintStmt) String() string {
@@ -80,21 +130,62 @@ type ReturnStmt struct {
 	Value   Expr
 }
 
-func (r ReturnStmt) Accept(visitor StmtVisitor) {
-	visitor.VisitReturnStmt(r)
+func (r ReturnStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitReturnStmt(r)
 }
 
 func (r ReturnStmt) String() string {
 	return fmt.Sprintf("return %v; ", r.Value)
 }
 
+type SelectStmt struct {
+	Keyword Token
+	Cases   []SelectCase
+	Default []Stmt // nil if there's no default clause
+}
+
+func (s SelectStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitSelectStmt(s)
+}
+
+// SelectCase is a single "case" clause of a select statement. Each clause
+// is either a send:
+//
+//	case ch.send(value) { ... }
+//
+// or a receive, optionally binding the received value to a new variable
+// scoped to the clause body:
+//
+//	case var v = ch.receive() { ... }
+type SelectCase struct {
+	Keyword Token
+	Channel Expr
+	Send    bool
+	Value   Expr   // the value to send, for send clauses
+	Name    *Token // the variable to bind, for receive clauses
+	Body    []Stmt
+}
+
+type SpawnStmt struct {
+	Keyword Token
+	Call    Call
+}
+
+func (s SpawnStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitSpawnStmt(s)
+}
+
+func (s SpawnStmt) String() string {
+	return fmt.Sprintf("spawn %v", s.Call)
+}
+
 type WhileStmt struct {
 	Condition Expr
 	Body      Stmt
 }
 
-func (w WhileStmt) Accept(visitor StmtVisitor) {
-	visitor.VisitWhileStmt(w)
+func (w WhileStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitWhileStmt(w)
 }
 
 func (w WhileStmt) String() string {
@@ -105,8 +196,8 @@ type BlockStmt struct {
 	Statements []Stmt
 }
 
-func (b BlockStmt) Accept(visitor StmtVisitor) {
-	visitor.VisitBlockStmt(b)
+func (b BlockStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitBlockStmt(b)
 }
 
 func (b BlockStmt) String() string {
@@ -115,9 +206,10 @@ func (b BlockStmt) String() string {
 
 type VariableStmt struct {
 	Name        Token
+	Type        *Token // optional type annotation, see Checker
 	Initializer Expr
 }
 
-func (vs VariableStmt) Accept(visitor StmtVisitor) {
-	visitor.VisitVarStmt(vs)
+func (vs VariableStmt) Accept(visitor StmtVisitor) interface{} {
+	return visitor.VisitVarStmt(vs)
 }
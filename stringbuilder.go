@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// StringBuilderBuiltin is stringBuilder(), which makes an empty
+// StringBuilder.
+type StringBuilderBuiltin struct{}
+
+func (sb StringBuilderBuiltin) Arity() int { return 0 }
+
+func (sb StringBuilderBuiltin) Call(i *Interpreter, args []interface{}) interface{} {
+	i.allocate(i.callLine, stringSize)
+	return &StringBuilder{}
+}
+
+func (sb StringBuilderBuiltin) String() string {
+	return "<native fn stringBuilder>"
+}
+
+// StringBuilder builds up a string in place. Building one with repeated
+// "+" copies the whole string each time, which is quadratic:
+//
+//	var sb = stringBuilder();
+//	for (var i = 0; i < 3; i = i + 1) sb.append(i).append(",");
+//	print sb.toString(); // 0,1,2,
+//
+// Values are appended as print would show them.
+type StringBuilder struct {
+	mu     sync.Mutex // builders can be shared between tasks
+	buf    strings.Builder
+	length int // in characters
+}
+
+func (s *StringBuilder) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+func (s *StringBuilder) Get(name Token) (interface{}, error) {
+	switch name.Lexeme {
+	case "append":
+		return nativeFunction{name: "append", arity: 1, fn: s.append}, nil
+	case "length":
+		return nativeFunction{name: "length", fn: func(*Interpreter, []interface{}) (interface{}, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return int64(s.length), nil
+		}}, nil
+	case "toString":
+		return nativeFunction{name: "toString", fn: func(*Interpreter, []interface{}) (interface{}, error) {
+			return s.String(), nil
+		}}, nil
+	}
+	return nil, fmt.Errorf("Undefined property %q.", name.Lexeme)
+}
+
+// append adds a value to the end of the string, returning the builder so
+// that calls can be chained.
+func (s *StringBuilder) append(i *Interpreter, args []interface{}) (interface{}, error) {
+	str := fmt.Sprint(args[0])
+	i.allocate(i.callLine, len(str))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.WriteString(str)
+	s.length += utf8.RuneCountInString(str)
+	return s, nil
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// VM runs programs compiled to bytecode by the Compiler, as an alternative
+// to the tree-walking Interpreter. The two backends share their runtime:
+// globals, builtins, classes, instances and the task scheduler all live in
+// the Interpreter which the VM is created for, so native functions work
+// the same under both.
+//
+// Like the Interpreter, a VM runs a single task; spawned tasks get their
+// own VM.
+type VM struct {
+	runtime *Interpreter
+	stack   []interface{}
+	frames  []callFrame
+}
+
+type callFrame struct {
+	closure *vmClosure
+	ip      int
+	base    int // stack index of slot 0
+}
+
+// NewVM returns a VM which runs code on behalf of runtime.
+func NewVM(runtime *Interpreter) *VM {
+	vm := &VM{runtime: runtime}
+	runtime.vm = vm
+	return vm
+}
+
+// Interpret compiles and runs stmts in the main task, returning once any
+// spawned tasks have finished, just like Interpreter.Interpret.
+func (vm *VM) Interpret(stmts []Stmt) error {
+	return vm.InterpretContext(context.Background(), stmts)
+}
+
+// InterpretContext is the VM's version of Interpreter.InterpretContext.
+func (vm *VM) InterpretContext(ctx context.Context, stmts []Stmt) (returnErr error) {
+	script, err := (&Compiler{}).Compile(stmts)
+	if err != nil {
+		return err
+	}
+	i := vm.runtime
+	i.ensureInit()
+	i.limits = newLimits(ctx, i)
+	i.tasks.done = i.limits.done
+
+	i.tasks.begin()
+	defer func() {
+		if r := recover(); r != nil {
+			returnErr = recoveredError(r)
+		}
+		i.tasks.finish()
+		if err := i.tasks.wait(); returnErr == nil {
+			returnErr = err
+		}
+	}()
+
+	// a previous runtime error may have left the stack unwound part way
+	vm.stack = vm.stack[:0]
+	vm.frames = vm.frames[:0]
+
+	closure := &vmClosure{fn: script}
+	vm.push(closure)
+	vm.call(closure, 0, 0)
+	vm.run(0)
+	return nil
+}
+
+// vmClosure is a compiled function along with the variables it captured.
+// It's the VM's equivalent of Function.
+type vmClosure struct {
+	fn       *vmFunction
+	upvalues []*vmUpvalue
+	// the class declaring the method, or the method this closure was
+	// created in, which determines the private members it can see
+	owner *Class
+}
+
+func (c *vmClosure) Arity() int {
+	return c.fn.arity
+}
+
+// Call runs the closure from Go code, e.g. when it's passed to a builtin.
+func (c *vmClosure) Call(i *Interpreter, args []interface{}) interface{} {
+	return i.vm.callFromGo(c, nil, args)
+}
+
+func (c *vmClosure) bind(inst *Instance) Callable {
+	return &vmBoundMethod{receiver: inst, method: c}
+}
+
+func (c *vmClosure) declaration() FunctionStmt {
+	return c.fn.decl
+}
+
+func (c *vmClosure) String() string {
+	return c.fn.String()
+}
+
+// vmBoundMethod is a method looked up on an instance but not immediately
+// called, e.g. "var f = obj.method;".
+type vmBoundMethod struct {
+	receiver *Instance
+	method   *vmClosure
+}
+
+func (bm *vmBoundMethod) Arity() int {
+	return bm.method.Arity()
+}
+
+func (bm *vmBoundMethod) Call(i *Interpreter, args []interface{}) interface{} {
+	return i.vm.callFromGo(bm.method, bm.receiver, args)
+}
+
+func (bm *vmBoundMethod) declaration() FunctionStmt {
+	return bm.method.fn.decl
+}
+
+func (bm *vmBoundMethod) String() string {
+	return bm.method.String()
+}
+
+// vmUpvalue is a variable captured by a closure. The variable's value is
+// moved into the upvalue when it's first captured, and the upvalue takes
+// its place in the stack slot, so the local variable and the closures see
+// the same value. Closures can be shared with spawned tasks, which run on
+// other goroutines with stacks of their own, so the value is guarded by mu
+// rather than ever being read from another task's stack.
+type vmUpvalue struct {
+	mu    sync.Mutex
+	value interface{}
+}
+
+func (u *vmUpvalue) get() interface{} {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.value
+}
+
+func (u *vmUpvalue) set(value interface{}) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.value = value
+}
+
+func (vm *VM) push(value interface{}) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) pop() interface{} {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack[len(vm.stack)-1] = nil
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+func (vm *VM) peek(distance int) interface{} {
+	return vm.stack[len(vm.stack)-1-distance]
+}
+
+// popN pops n values, returning a copy of them in the order they were
+// pushed.
+func (vm *VM) popN(n int) []interface{} {
+	values := make([]interface{}, n)
+	copy(values, vm.stack[len(vm.stack)-n:])
+	vm.truncate(len(vm.stack) - n)
+	return values
+}
+
+func (vm *VM) truncate(size int) {
+	for idx := size; idx < len(vm.stack); idx++ {
+		vm.stack[idx] = nil
+	}
+	vm.stack = vm.stack[:size]
+}
+
+// callFromGo calls closure with args, and runs it to completion. If
+// receiver is given, it's bound to "this".
+func (vm *VM) callFromGo(closure *vmClosure, receiver *Instance, args []interface{}) interface{} {
+	line := vm.runtime.callLine
+	if receiver != nil {
+		vm.push(receiver)
+	} else {
+		vm.push(closure)
+	}
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	depth := len(vm.frames)
+	vm.call(closure, len(args), line)
+	return vm.run(depth)
+}
+
+// call starts a new frame for closure, whose arguments are on top of the
+// stack, above the callee (or receiver) in slot 0.
+func (vm *VM) call(closure *vmClosure, argc int, line int) {
+	if argc != closure.fn.arity {
+		vm.runtime.runtimeError(line, fmt.Sprintf("Expected %d args but got %d.", closure.fn.arity, argc))
+	}
+	// the script itself has the bottom frame
+	if len(vm.frames) > vm.runtime.maxCallDepth() {
+		vm.runtime.stackOverflow(line, closure.fn.name)
+	}
+	// locals live on the stack, but charge for them as the Interpreter
+	// does for a call's environment
+	vm.runtime.allocate(line, environmentSize+valueSize*argc)
+	vm.frames = append(vm.frames, callFrame{
+		closure: closure,
+		base:    len(vm.stack) - argc - 1,
+	})
+}
+
+// replaceCaller removes the frame below the top one, moving the top
+// frame's callee and arguments down into its slots. It's used when the
+// caller was going to return the result of the call straight away, so
+// that tail calls, including mutually recursive ones, run in constant
+// space.
+func (vm *VM) replaceCaller() {
+	callee := vm.frames[len(vm.frames)-1]
+	caller := vm.frames[len(vm.frames)-2]
+	n := copy(vm.stack[caller.base:], vm.stack[callee.base:])
+	vm.truncate(caller.base + n)
+	callee.base = caller.base
+	vm.frames[len(vm.frames)-2] = callee
+	vm.frames = vm.frames[:len(vm.frames)-1]
+}
+
+// callValue calls callee, which is below its arguments on the stack.
+// Closures get a new frame, to be run by the caller's loop; other
+// callables are run straight away and their result pushed.
+func (vm *VM) callValue(callee interface{}, argc int, line int) {
+	switch typed := callee.(type) {
+	case *vmClosure:
+		vm.call(typed, argc, line)
+		return
+	case *vmBoundMethod:
+		vm.stack[len(vm.stack)-argc-1] = typed.receiver
+		vm.call(typed.method, argc, line)
+		return
+	case *Class:
+		instance := &Instance{Class: typed}
+		vm.stack[len(vm.stack)-argc-1] = instance
+		if initializer, found := typed.findMethod("init"); found {
+			if closure, ok := initializer.(*vmClosure); ok {
+				vm.runtime.allocate(line, instanceSize)
+				vm.call(closure, argc, line)
+				return
+			}
+		}
+	}
+	args := vm.popN(argc)
+	vm.pop()
+	vm.push(vm.callNative(callee, args, nil, line))
+}
+
+// callNative calls anything other than a closure, using the same checks
+// as the Interpreter.
+func (vm *VM) callNative(callee interface{}, args []interface{}, named map[string]interface{}, line int) interface{} {
+	function := vm.runtime.checkCall(callee, args, named, line)
+	vm.runtime.callLine = line
+	return vm.runtime.call(function, args, named)
+}
+
+// invoke calls the named method of the receiver below the arguments on
+// the stack, without creating a bound method for it when it's a closure.
+func (vm *VM) invoke(name string, argc int, line int) {
+	receiver := vm.peek(argc)
+	instance, ok := receiver.(*Instance)
+	if !ok {
+		vm.callValue(vm.getProperty(receiver, name, line), argc, line)
+		return
+	}
+
+	// fields shadow methods
+	instance.mu.RLock()
+	field, found := instance.Fields[name]
+	instance.mu.RUnlock()
+	if found {
+		vm.stack[len(vm.stack)-argc-1] = field
+		vm.callValue(field, argc, line)
+		return
+	}
+	if method, found := instance.Class.findMethod(name); found {
+		if closure, ok := method.(*vmClosure); ok {
+			vm.call(closure, argc, line)
+			return
+		}
+	}
+	vm.callValue(vm.getProperty(receiver, name, line), argc, line)
+}
+
+func (vm *VM) getProperty(obj interface{}, name string, line int) interface{} {
+	getter, ok := obj.(propertyGetter)
+	if !ok {
+		vm.runtime.runtimeError(line, "Only class instances have properties.")
+	}
+	value, err := getter.Get(Token{Lexeme: name, Line: line})
+	if err != nil {
+		vm.runtime.runtimeError(line, err.Error())
+	}
+	return value
+}
+
+// namedArgs pops the values of named arguments, whose names are given
+// by the constant at index.
+func (vm *VM) namedArgs(chunk *Chunk, index int) map[string]interface{} {
+	names := chunk.Constants[index].([]Token)
+	if len(names) == 0 {
+		return nil
+	}
+	values := vm.popN(len(names))
+	named := make(map[string]interface{}, len(names))
+	for idx, name := range names {
+		if _, dup := named[name.Lexeme]; dup {
+			vm.runtime.runtimeError(name.Line, fmt.Sprintf("Duplicate named argument %q.", name.Lexeme))
+		}
+		named[name.Lexeme] = values[idx]
+	}
+	return named
+}
+
+// captureUpvalue returns the upvalue for the local variable in slot,
+// moving the variable into one if it hasn't been captured before.
+func (vm *VM) captureUpvalue(slot int) *vmUpvalue {
+	if upvalue, ok := vm.stack[slot].(*vmUpvalue); ok {
+		return upvalue
+	}
+	upvalue := &vmUpvalue{value: vm.stack[slot]}
+	vm.stack[slot] = upvalue
+	return upvalue
+}
+
+// vmOperators are the tokens passed to the Interpreter's operator
+// implementations for each operator instruction.
+var vmOperators = map[OpCode]Token{
+	OP_EQUAL:         {Type: EQUAL_EQUAL, Lexeme: "=="},
+	OP_NOT_EQUAL:     {Type: BANG_EQUAL, Lexeme: "!="},
+	OP_GREATER:       {Type: GREATER, Lexeme: ">"},
+	OP_GREATER_EQUAL: {Type: GREATER_EQUAL, Lexeme: ">="},
+	OP_LESS:          {Type: LESS, Lexeme: "<"},
+	OP_LESS_EQUAL:    {Type: LESS_EQUAL, Lexeme: "<="},
+	OP_IS:            {Type: IS, Lexeme: "is"},
+	OP_ADD:           {Type: PLUS, Lexeme: "+"},
+	OP_SUBTRACT:      {Type: MINUS, Lexeme: "-"},
+	OP_MULTIPLY:      {Type: STAR, Lexeme: "*"},
+	OP_DIVIDE:        {Type: SLASH, Lexeme: "/"},
+	OP_NOT:           {Type: BANG, Lexeme: "!"},
+	OP_NEGATE:        {Type: MINUS, Lexeme: "-"},
+}
+
+// run executes instructions until the frame count drops to stopDepth,
+// and returns the value returned by the last frame.
+func (vm *VM) run(stopDepth int) interface{} {
+	i := vm.runtime
+	frame := &vm.frames[len(vm.frames)-1]
+	chunk := &frame.closure.fn.chunk
+
+	readByte := func() int {
+		b := chunk.Code[frame.ip]
+		frame.ip++
+		return int(b)
+	}
+	readU16 := func() int {
+		value := chunk.readU16(frame.ip)
+		frame.ip += 2
+		return value
+	}
+	readString := func() string {
+		return chunk.Constants[readU16()].(string)
+	}
+	// called after each call instruction, with the frame count before it
+	tailCall := func(depth int) {
+		if len(vm.frames) > depth && OpCode(chunk.Code[frame.ip]) == OP_RETURN {
+			vm.replaceCaller()
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.fn.chunk
+		}
+	}
+
+	for {
+		line := chunk.Lines[frame.ip]
+		op := OpCode(readByte())
+		switch op {
+		case OP_CONSTANT:
+			vm.push(chunk.Constants[readU16()])
+		case OP_NIL:
+			vm.push(nil)
+		case OP_TRUE:
+			vm.push(true)
+		case OP_FALSE:
+			vm.push(false)
+		case OP_POP:
+			vm.pop()
+		case OP_STATEMENT:
+			i.limits.step()
+
+		case OP_GET_LOCAL:
+			value := vm.stack[frame.base+readByte()]
+			if upvalue, ok := value.(*vmUpvalue); ok {
+				value = upvalue.get()
+			}
+			vm.push(value)
+		case OP_SET_LOCAL:
+			slot := frame.base + readByte()
+			if upvalue, ok := vm.stack[slot].(*vmUpvalue); ok {
+				upvalue.set(vm.peek(0))
+			} else {
+				vm.stack[slot] = vm.peek(0)
+			}
+		case OP_GET_GLOBAL:
+			vm.push(i.globals.get(Token{Lexeme: readString(), Line: line}))
+		case OP_DEFINE_GLOBAL:
+			i.globals.define(readString(), vm.pop())
+		case OP_SET_GLOBAL:
+			i.globals.assign(Token{Lexeme: readString(), Line: line}, vm.peek(0))
+		case OP_GET_UPVALUE:
+			vm.push(frame.closure.upvalues[readByte()].get())
+		case OP_SET_UPVALUE:
+			frame.closure.upvalues[readByte()].set(vm.peek(0))
+
+		case OP_GET_PROPERTY:
+			name := readString()
+			vm.push(vm.getProperty(vm.pop(), name, line))
+		case OP_SET_PROPERTY:
+			name := readString()
+			value := vm.pop()
+			instance, ok := vm.pop().(*Instance)
+			if !ok {
+				i.runtimeError(line, "Only class instances have fields.")
+			}
+			added, err := instance.Set(Token{Lexeme: name, Line: line}, value)
+			if err != nil {
+				i.runtimeError(line, err.Error())
+			}
+			if added {
+				i.allocate(line, fieldSize)
+			}
+			vm.push(value)
+		case OP_GET_PRIVATE:
+			name := Token{Lexeme: readString(), Line: line}
+			value, err := vm.pop().(*Instance).getPrivate(frame.closure.owner, name)
+			if err != nil {
+				i.runtimeError(line, err.Error())
+			}
+			vm.push(value)
+		case OP_SET_PRIVATE:
+			name := Token{Lexeme: readString(), Line: line}
+			value := vm.pop()
+			if vm.pop().(*Instance).setPrivate(frame.closure.owner, name, value) {
+				i.allocate(line, fieldSize)
+			}
+			vm.push(value)
+		case OP_GET_SUPER:
+			name := readString()
+			superclass := vm.pop().(*Class)
+			method, found := superclass.findMethod(name)
+			if !found {
+				i.runtimeError(line, fmt.Sprintf("Undefined property %q.", name))
+			}
+			vm.push(method.bind(vm.pop().(*Instance)))
+
+		case OP_EQUAL, OP_NOT_EQUAL, OP_GREATER, OP_GREATER_EQUAL, OP_LESS, OP_LESS_EQUAL,
+			OP_IS, OP_ADD, OP_SUBTRACT, OP_MULTIPLY, OP_DIVIDE:
+			operator := vmOperators[op]
+			operator.Line = line
+			right := vm.pop()
+			left := vm.pop()
+			vm.push(i.binaryOp(operator, left, right))
+		case OP_NOT, OP_NEGATE:
+			operator := vmOperators[op]
+			operator.Line = line
+			vm.push(i.unaryOp(operator, vm.pop()))
+
+		case OP_PRINT:
+			i.print(vm.pop())
+
+		case OP_JUMP:
+			offset := readU16()
+			frame.ip += offset
+		case OP_JUMP_IF_FALSE:
+			offset := readU16()
+			if !i._isTruthy(vm.peek(0)) {
+				frame.ip += offset
+			}
+		case OP_LOOP:
+			offset := readU16()
+			frame.ip -= offset
+
+		case OP_CALL:
+			argc := readByte()
+			depth := len(vm.frames)
+			vm.callValue(vm.peek(argc), argc, line)
+			tailCall(depth)
+		case OP_CALL_NAMED:
+			argc := readByte()
+			named := vm.namedArgs(chunk, readU16())
+			args := vm.popN(argc)
+			vm.push(vm.callNative(vm.pop(), args, named, line))
+		case OP_INVOKE:
+			name := readString()
+			depth := len(vm.frames)
+			vm.invoke(name, readByte(), line)
+			tailCall(depth)
+		case OP_SUPER_INVOKE:
+			name := readString()
+			argc := readByte()
+			superclass := vm.pop().(*Class)
+			method, found := superclass.findMethod(name)
+			if !found {
+				i.runtimeError(line, fmt.Sprintf("Undefined property %q.", name))
+			}
+			depth := len(vm.frames)
+			vm.callValue(method, argc, line)
+			tailCall(depth)
+
+		case OP_CLOSURE:
+			fn := chunk.Constants[readU16()].(*vmFunction)
+			closure := &vmClosure{
+				fn:       fn,
+				upvalues: make([]*vmUpvalue, fn.upvalueCount),
+				owner:    frame.closure.owner,
+			}
+			// a local function referring to itself captures the slot the
+			// closure is about to be pushed into
+			var self *vmUpvalue
+			for idx := range closure.upvalues {
+				isLocal := readByte() == 1
+				index := readByte()
+				switch {
+				case !isLocal:
+					closure.upvalues[idx] = frame.closure.upvalues[index]
+				case frame.base+index == len(vm.stack):
+					if self == nil {
+						self = &vmUpvalue{value: closure}
+					}
+					closure.upvalues[idx] = self
+				default:
+					closure.upvalues[idx] = vm.captureUpvalue(frame.base + index)
+				}
+			}
+			if self != nil {
+				vm.push(self)
+			} else {
+				vm.push(closure)
+			}
+		case OP_CLOSE_UPVALUE:
+			// the upvalue already holds the variable's value
+			vm.pop()
+
+		case OP_RETURN:
+			result := vm.pop()
+			vm.truncate(frame.base)
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == stopDepth {
+				return result
+			}
+			vm.push(result)
+
+		case OP_CLASS:
+			template := chunk.Constants[readU16()].(*classTemplate)
+			vm.push(&Class{
+				Name:    template.name,
+				Methods: make(map[string]Method),
+				IsData:  template.isData,
+				Fields:  template.fields,
+				Doc:     template.doc,
+			})
+		case OP_INHERIT:
+			superclass, ok := vm.peek(1).(*Class)
+			if !ok {
+				i.runtimeError(line, "Superclass must be a class.")
+			}
+			vm.pop().(*Class).inherit(superclass)
+		case OP_METHOD:
+			name := readString()
+			method := vm.pop().(*vmClosure)
+			class := vm.peek(0).(*Class)
+			method.owner = class
+			class.addMethod(name, method)
+		case OP_IMPLEMENTS:
+			check := chunk.Constants[readU16()].(implementsCheck)
+			iface, ok := vm.pop().(*Interface)
+			if !ok {
+				i.runtimeError(line, fmt.Sprintf("%q is not an interface.", check.interfaceName))
+			}
+			class := vm.peek(0).(*Class)
+			if missing := iface.missingMethod(class); missing != "" {
+				i.runtimeError(
+					check.classLine,
+					fmt.Sprintf("Class %s doesn't implement %s: %s.", class.Name, iface.Name, missing),
+				)
+			}
+
+		case OP_ASSERT:
+			offset := readU16()
+			if i.DisableAssertions {
+				frame.ip += offset
+			}
+		case OP_ASSERT_FAIL:
+			template := chunk.Constants[readU16()].(assertTemplate)
+			panic(assertionError(line, template.source, template.hasMessage, vm.pop()))
+
+		case OP_SPAWN:
+			argc := readByte()
+			named := vm.namedArgs(chunk, readU16())
+			args := vm.popN(argc)
+			function := i.checkCall(vm.pop(), args, named, line)
+			task := i.fork()
+			NewVM(task)
+			i.tasks.spawn(func() {
+				task.callLine = line
+				task.call(function, args, named)
+			})
+
+		case OP_SELECT:
+			vm.selectCase(chunk.Constants[readU16()].(selectTemplate), line)
+		case OP_SELECT_CASE:
+			index := int64(readByte())
+			offset := readU16()
+			if vm.peek(0).(int64) != index {
+				frame.ip += offset
+			} else {
+				vm.pop()
+			}
+
+		default:
+			panic(fmt.Sprintf("VM hit unknown opcode %v", op))
+		}
+
+		// calls and returns change the current frame
+		if top := &vm.frames[len(vm.frames)-1]; top != frame {
+			frame = top
+			chunk = &frame.closure.fn.chunk
+		}
+	}
+}
+
+// selectCase performs a select statement's channel operations, whose
+// operands are on the stack, and leaves the received value (if any) and
+// the index of the chosen case on the stack for OP_SELECT_CASE.
+func (vm *VM) selectCase(template selectTemplate, line int) {
+	operands := 0
+	for _, sc := range template.cases {
+		operands++
+		if sc.send {
+			operands++
+		}
+	}
+	values := vm.popN(operands)
+
+	ops := make([]chanOp, len(template.cases))
+	for idx, sc := range template.cases {
+		ch, ok := values[0].(*Channel)
+		if !ok {
+			vm.runtime.runtimeError(sc.line, "Can only select on channels.")
+		}
+		ops[idx] = chanOp{ch: ch, send: sc.send}
+		values = values[1:]
+		if sc.send {
+			ops[idx].value = values[0]
+			values = values[1:]
+		}
+	}
+
+	chosen, value, err := vm.runtime.tasks.perform(ops, !template.hasDefault)
+	if err != nil {
+		vm.runtime.runtimeError(line, err.Error())
+	}
+	if chosen < 0 {
+		chosen = selectDefaultCase
+	}
+	vm.push(value)
+	vm.push(int64(chosen))
+}